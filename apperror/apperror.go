@@ -0,0 +1,172 @@
+// Package apperror defines the typed errors shared by every blueprint.
+// Repositories translate driver and ORM errors into these, services pass
+// them up unchanged (optionally wrapped with %w), and package response turns
+// them into an HTTP status and a stable machine-readable code.
+package apperror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Code is the machine-readable identifier sent to clients. Values are part
+// of the public API and must not change.
+type Code string
+
+const (
+	CodeBadRequest           Code = "BAD_REQUEST"
+	CodeNotFound             Code = "NOT_FOUND"
+	CodeConflict             Code = "CONFLICT"
+	CodeVersionConflict      Code = "VERSION_CONFLICT"
+	CodePreconditionFailed   Code = "PRECONDITION_FAILED"
+	CodeValidation           Code = "VALIDATION_FAILED"
+	CodeForbidden            Code = "FORBIDDEN"
+	CodeNotAcceptable        Code = "NOT_ACCEPTABLE"
+	CodeUnsupportedMediaType Code = "UNSUPPORTED_MEDIA_TYPE"
+	CodeTimeout              Code = "TIMEOUT"
+	CodeInternal             Code = "INTERNAL_ERROR"
+)
+
+// Sentinels for errors.Is. Any *Error with the same Code matches them.
+var (
+	ErrNotFound           = &Error{Code: CodeNotFound, Message: "resource not found"}
+	ErrConflict           = &Error{Code: CodeConflict, Message: "resource conflict"}
+	ErrVersionConflict    = &Error{Code: CodeVersionConflict, Message: "resource was modified concurrently"}
+	ErrPreconditionFailed = &Error{Code: CodePreconditionFailed, Message: "precondition failed"}
+	ErrValidation         = &Error{Code: CodeValidation, Message: "validation failed"}
+	ErrForbidden          = &Error{Code: CodeForbidden, Message: "forbidden"}
+	ErrTimeout            = &Error{Code: CodeTimeout, Message: "operation timed out"}
+)
+
+type Error struct {
+	Code    Code
+	Message string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+func NotFound(format string, args ...any) *Error {
+	return &Error{Code: CodeNotFound, Message: fmt.Sprintf(format, args...)}
+}
+
+func Conflict(format string, args ...any) *Error {
+	return &Error{Code: CodeConflict, Message: fmt.Sprintf(format, args...)}
+}
+
+// VersionConflict reports a write based on an outdated version of a row.
+func VersionConflict(format string, args ...any) *Error {
+	return &Error{Code: CodeVersionConflict, Message: fmt.Sprintf(format, args...)}
+}
+
+// PreconditionFailed reports a conditional request, such as one with
+// If-Match, whose condition does not hold.
+func PreconditionFailed(format string, args ...any) *Error {
+	return &Error{Code: CodePreconditionFailed, Message: fmt.Sprintf(format, args...)}
+}
+
+func Validation(format string, args ...any) *Error {
+	return &Error{Code: CodeValidation, Message: fmt.Sprintf(format, args...)}
+}
+
+func Forbidden(format string, args ...any) *Error {
+	return &Error{Code: CodeForbidden, Message: fmt.Sprintf(format, args...)}
+}
+
+func Timeout(format string, args ...any) *Error {
+	return &Error{Code: CodeTimeout, Message: fmt.Sprintf(format, args...)}
+}
+
+// Wrap attaches code and a client-facing message to err, keeping err in the
+// chain for logging and errors.Is/As.
+func Wrap(code Code, err error, message string) *Error {
+	return &Error{Code: code, Message: message, Err: err}
+}
+
+// CodeOf returns the Code of the first *Error in err's chain. Deadline errors
+// that were never translated still count as CodeTimeout.
+func CodeOf(err error) Code {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return CodeTimeout
+	}
+	return CodeInternal
+}
+
+// MessageOf returns the client-facing message of the first *Error in err's
+// chain, or an empty string for untyped errors.
+func MessageOf(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Message
+	}
+	return ""
+}
+
+// HTTPStatus maps c to the status code used in responses.
+func (c Code) HTTPStatus() int {
+	switch c {
+	case CodeBadRequest, CodeValidation:
+		return http.StatusBadRequest
+	case CodeNotFound:
+		return http.StatusNotFound
+	case CodeConflict, CodeVersionConflict:
+		return http.StatusConflict
+	case CodePreconditionFailed:
+		return http.StatusPreconditionFailed
+	case CodeForbidden:
+		return http.StatusForbidden
+	case CodeNotAcceptable:
+		return http.StatusNotAcceptable
+	case CodeUnsupportedMediaType:
+		return http.StatusUnsupportedMediaType
+	case CodeTimeout:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// CodeForStatus is the inverse of HTTPStatus, used for errors that carry no
+// Code of their own.
+func CodeForStatus(status int) Code {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeBadRequest
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusPreconditionFailed:
+		return CodePreconditionFailed
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotAcceptable:
+		return CodeNotAcceptable
+	case http.StatusUnsupportedMediaType:
+		return CodeUnsupportedMediaType
+	case http.StatusGatewayTimeout:
+		return CodeTimeout
+	default:
+		return CodeInternal
+	}
+}
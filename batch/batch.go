@@ -0,0 +1,231 @@
+// Package batch holds what the bulk endpoints share: the request and response
+// shapes, per-item decoding and validation, and the routing of custom
+// methods such as POST /products:batch.
+package batch
+
+import (
+	"bytes"
+	"db_blueprints/apperror"
+	"db_blueprints/response"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// Mode decides what happens to the valid items of a batch when some fail.
+type Mode string
+
+const (
+	// AllOrNothing writes nothing unless every item is valid. It is the
+	// default.
+	AllOrNothing Mode = "all_or_nothing"
+	// BestEffort writes the valid items and reports the others as failed.
+	BestEffort Mode = "best_effort"
+)
+
+// ErrAborted is returned from a transaction to roll back an all_or_nothing
+// batch in which some items failed.
+var ErrAborted = errors.New("batch aborted: some items failed")
+
+// InsertSize is the number of rows written per INSERT statement.
+const InsertSize = 100
+
+// CreateRequest is the body of a bulk create of up to 500 items. Items are
+// decoded one by one, so a malformed item fails on its own instead of failing
+// the request.
+type CreateRequest struct {
+	Mode  Mode              `json:"mode" binding:"omitempty,oneof=all_or_nothing best_effort"`
+	Items []json.RawMessage `json:"items" binding:"required,min=1,max=500"`
+}
+
+// UpdateRequest is the body of a bulk update of up to 500 items, each naming
+// the row it changes by id. Items are decoded one by one, as for
+// CreateRequest.
+type UpdateRequest struct {
+	Mode  Mode              `json:"mode" binding:"omitempty,oneof=all_or_nothing best_effort"`
+	Items []json.RawMessage `json:"items" binding:"required,min=1,max=500"`
+}
+
+// DeleteRequest is the body of a bulk delete of up to 500 ids.
+type DeleteRequest struct {
+	Mode Mode    `json:"mode" binding:"omitempty,oneof=all_or_nothing best_effort"`
+	IDs  []int64 `json:"ids" binding:"required,min=1,max=500,dive,min=1"`
+}
+
+// Status is the outcome of a single item.
+type Status string
+
+const (
+	Created Status = "created"
+	Updated Status = "updated"
+	Deleted Status = "deleted"
+	Failed  Status = "failed"
+	// Skipped marks a valid item that was not written because another item
+	// of an all_or_nothing batch failed.
+	Skipped Status = "skipped"
+)
+
+type Result struct {
+	// Index is the position of the item in the request.
+	Index  int    `json:"index"`
+	Status Status `json:"status"`
+	ID     int64  `json:"id,omitempty"`
+	// Version is the new version of an updated row.
+	Version int64               `json:"version,omitempty"`
+	Error   *response.ErrorBody `json:"error,omitempty"`
+}
+
+type Response struct {
+	Results   []*Result `json:"results"`
+	Succeeded int       `json:"succeeded"`
+	Failed    int       `json:"failed"`
+}
+
+// Results holds one Result per item of a request, in request order.
+type Results []*Result
+
+func NewResults(n int) Results {
+	results := make(Results, n)
+	for i := range results {
+		results[i] = &Result{Index: i}
+	}
+	return results
+}
+
+// Fail records err as the outcome of item i.
+func (r Results) Fail(i int, err error, message string) {
+	r[i].Status = Failed
+	r[i].Error = response.ItemError(err, message)
+}
+
+// Succeed records the outcome of a written item i.
+func (r Results) Succeed(i int, status Status, id int64) {
+	r[i].Status = status
+	r[i].ID = id
+}
+
+// SucceedUpdate records the outcome of item i, which updated the row with id
+// to version.
+func (r Results) SucceedUpdate(i int, id, version int64) {
+	r.Succeed(i, Updated, id)
+	r[i].Version = version
+}
+
+// Failed reports whether any item failed.
+func (r Results) Failed() bool {
+	for _, result := range r {
+		if result.Status == Failed {
+			return true
+		}
+	}
+	return false
+}
+
+// Abort reports whether a batch in mode must stop without writing, and if
+// so marks every item that has not failed as skipped.
+func (r Results) Abort(mode Mode) bool {
+	if mode == BestEffort || !r.Failed() {
+		return false
+	}
+	for _, result := range r {
+		if result.Status != Failed {
+			result.Status = Skipped
+		}
+	}
+	return true
+}
+
+// DeleteResults reports on a bulk delete of ids in mode, in which the missing
+// ids matched no row of entity.
+func DeleteResults(ids, missing []int64, mode Mode, entity string) Results {
+	results := NewResults(len(ids))
+	for i, id := range ids {
+		if slices.Contains(missing, id) {
+			results.Fail(i, apperror.NotFound("%s with id %d not found", entity, id), "Not found")
+		}
+	}
+	if results.Abort(mode) {
+		return results
+	}
+
+	for i, id := range ids {
+		if results[i].Status != Failed {
+			results.Succeed(i, Deleted, id)
+		}
+	}
+	return results
+}
+
+// Respond writes the results. The status is ok when every item succeeded,
+// 200 when a best_effort batch partly succeeded and 422 when nothing was
+// written.
+func (r Results) Respond(c *gin.Context, ok int) {
+	res := Response{Results: r}
+	for _, result := range r {
+		if result.Status == Failed {
+			res.Failed++
+		} else if result.Status != Skipped {
+			res.Succeeded++
+		}
+	}
+
+	status := ok
+	switch {
+	case res.Succeeded == 0:
+		status = http.StatusUnprocessableEntity
+	case res.Failed > 0:
+		status = http.StatusOK
+	}
+	response.JSON(c, status, res)
+}
+
+// Item is an item of a bulk request that passed validation.
+type Item[T any] struct {
+	Index int
+	Value *T
+}
+
+// Decode decodes and validates every raw item as a T, the same way binding a
+// single request would. Items that fail are recorded in results; the others
+// are returned in request order.
+func Decode[T any](raw []json.RawMessage, results Results) []Item[T] {
+	items := make([]Item[T], 0, len(raw))
+	for i, data := range raw {
+		if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+			results.Fail(i, apperror.Validation("item must be a JSON object"), "Invalid item")
+			continue
+		}
+
+		value := new(T)
+		if err := json.Unmarshal(data, value); err != nil {
+			results.Fail(i, err, "Invalid item")
+			continue
+		}
+		if err := binding.Validator.ValidateStruct(value); err != nil {
+			results.Fail(i, err, "Invalid item")
+			continue
+		}
+		items = append(items, Item[T]{Index: i, Value: value})
+	}
+	return items
+}
+
+// Actions routes custom methods, the ":name" suffix of a path such as
+// /products:batch. gin reads a colon in a route as a parameter, so they are
+// registered as one route, e.g. "/products:action", and dispatched by the
+// value of its parameter, which keeps the colon.
+func Actions(param string, handlers map[string]gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		handler, ok := handlers[strings.TrimPrefix(c.Param(param), ":")]
+		if !ok {
+			response.Error(c, http.StatusNotFound, apperror.NotFound("no such method %q", c.Param(param)), "Not found")
+			return
+		}
+		handler(c)
+	}
+}
@@ -2,7 +2,11 @@ package config
 
 import (
 	"fmt"
+	"math"
+	"net"
+	"net/url"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/spf13/viper"
@@ -14,11 +18,42 @@ var (
 
 const (
 	ProductionEnv      = "production" //production or development
+	DevelopmentEnv     = "development"
 	DatabaseTimeout    = time.Second * 5
 	ProductCachingTime = time.Minute * 1
+
+	// DefaultSoftDeleteRetention is how long soft-deleted rows are kept
+	// before the purge job removes them.
+	DefaultSoftDeleteRetention = time.Hour * 24 * 30
+	DefaultPurgeInterval       = time.Hour
+
+	// Defaults of the HTTP server timeouts. Exports lift the write timeout
+	// for their own responses.
+	DefaultHTTPReadTimeout  = time.Second * 15
+	DefaultHTTPWriteTimeout = time.Second * 30
+	DefaultHTTPIdleTimeout  = time.Minute
+	DefaultShutdownTimeout  = time.Second * 20
+	DefaultShutdownDelay    = time.Duration(0)
+
+	// Defaults of the connection pool and of connecting to the database.
+	DefaultDBMaxOpenConns    = 25
+	DefaultDBMaxIdleConns    = 25
+	DefaultDBConnMaxLifetime = time.Minute * 5
+	DefaultDBConnectTimeout  = time.Second * 10
+	DefaultDBConnectBackoff  = time.Second
+
+	DefaultDBReplicaCheckInterval = time.Second * 5
+)
+
+// Supported DB_DRIVER values.
+const (
+	DriverMySQL    = "mysql"
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
 )
 
 type Config struct {
+	ENV         string `mapstructure:"ENV"`
 	HTTP_PORT   string `mapstructure:"HTTP_PORT"`
 	DB_DRIVER   string `mapstructure:"DB_DRIVER"`
 	DB_USER     string `mapstructure:"DB_USER"`
@@ -26,10 +61,79 @@ type Config struct {
 	DB_HOST     string `mapstructure:"DB_HOST"`
 	DB_PORT     string `mapstructure:"DB_PORT"`
 	DB_NAME     string `mapstructure:"DB_NAME"`
+	// DB_MAX_OPEN_CONNS, DB_MAX_IDLE_CONNS, DB_CONN_MAX_LIFETIME and
+	// DB_CONN_MAX_IDLE_TIME size the connection pool, with the meaning of
+	// the sql.DB setters: zero or less means no limit, except for idle
+	// connections, of which none are kept. SQLite always uses one
+	// connection.
+	DB_MAX_OPEN_CONNS     int           `mapstructure:"DB_MAX_OPEN_CONNS"`
+	DB_MAX_IDLE_CONNS     int           `mapstructure:"DB_MAX_IDLE_CONNS"`
+	DB_CONN_MAX_LIFETIME  time.Duration `mapstructure:"DB_CONN_MAX_LIFETIME"`
+	DB_CONN_MAX_IDLE_TIME time.Duration `mapstructure:"DB_CONN_MAX_IDLE_TIME"`
+	// DB_CONNECT_TIMEOUT bounds opening one connection. DB_READ_TIMEOUT and
+	// DB_WRITE_TIMEOUT bound network reads and writes, MySQL only; zero
+	// turns them off.
+	DB_CONNECT_TIMEOUT time.Duration `mapstructure:"DB_CONNECT_TIMEOUT"`
+	DB_READ_TIMEOUT    time.Duration `mapstructure:"DB_READ_TIMEOUT"`
+	DB_WRITE_TIMEOUT   time.Duration `mapstructure:"DB_WRITE_TIMEOUT"`
+	// DB_TLS is the tls parameter of MySQL (true, false, skip-verify or
+	// preferred) or the sslmode of PostgreSQL (disable, require,
+	// verify-ca or verify-full). Empty means no TLS.
+	DB_TLS string `mapstructure:"DB_TLS"`
+	// DB_CHARSET and DB_LOC are the charset and time zone of MySQL
+	// connections.
+	DB_CHARSET string `mapstructure:"DB_CHARSET"`
+	DB_LOC     string `mapstructure:"DB_LOC"`
+	// DB_CONNECT_RETRIES is how many times connecting is retried at startup,
+	// waiting DB_CONNECT_BACKOFF at first and twice as long after each
+	// attempt.
+	DB_CONNECT_RETRIES int           `mapstructure:"DB_CONNECT_RETRIES"`
+	DB_CONNECT_BACKOFF time.Duration `mapstructure:"DB_CONNECT_BACKOFF"`
+	// DB_REPLICAS lists read replicas as comma-separated host or host:port
+	// entries. They share the credentials, database and settings of the
+	// primary. DB_REPLICA_CHECK_INTERVAL is how often they are pinged; one
+	// that does not answer gets no reads until it does.
+	DB_REPLICAS               string        `mapstructure:"DB_REPLICAS"`
+	DB_REPLICA_CHECK_INTERVAL time.Duration `mapstructure:"DB_REPLICA_CHECK_INTERVAL"`
+	// AUTO_MIGRATE applies pending migrations when a server starts.
+	AUTO_MIGRATE bool `mapstructure:"AUTO_MIGRATE"`
+	// ADMIN_TOKEN is the bearer token of admin requests. Admin-only features
+	// are disabled while it is empty.
+	ADMIN_TOKEN string `mapstructure:"ADMIN_TOKEN"`
+	// SOFT_DELETE_RETENTION is how long soft-deleted rows are kept, e.g.
+	// "720h". PURGE_INTERVAL is how often the purge job runs; 0 disables it.
+	SOFT_DELETE_RETENTION time.Duration `mapstructure:"SOFT_DELETE_RETENTION"`
+	PURGE_INTERVAL        time.Duration `mapstructure:"PURGE_INTERVAL"`
+	// HTTP_READ_TIMEOUT, HTTP_WRITE_TIMEOUT and HTTP_IDLE_TIMEOUT bound
+	// reading a request, writing its response and keeping an idle
+	// connection open. SHUTDOWN_TIMEOUT is how long in-flight requests get
+	// to finish once the server is asked to stop. SHUTDOWN_DELAY is how long
+	// it keeps serving before that, with /readyz failing, so load balancers
+	// stop sending it traffic first.
+	HTTP_READ_TIMEOUT  time.Duration `mapstructure:"HTTP_READ_TIMEOUT"`
+	HTTP_WRITE_TIMEOUT time.Duration `mapstructure:"HTTP_WRITE_TIMEOUT"`
+	HTTP_IDLE_TIMEOUT  time.Duration `mapstructure:"HTTP_IDLE_TIMEOUT"`
+	SHUTDOWN_TIMEOUT   time.Duration `mapstructure:"SHUTDOWN_TIMEOUT"`
+	SHUTDOWN_DELAY     time.Duration `mapstructure:"SHUTDOWN_DELAY"`
 }
 
 func LoadConfig() *Config {
 	viper.AutomaticEnv()
+	viper.SetDefault("DB_MAX_OPEN_CONNS", DefaultDBMaxOpenConns)
+	viper.SetDefault("DB_MAX_IDLE_CONNS", DefaultDBMaxIdleConns)
+	viper.SetDefault("DB_CONN_MAX_LIFETIME", DefaultDBConnMaxLifetime)
+	viper.SetDefault("DB_CONNECT_TIMEOUT", DefaultDBConnectTimeout)
+	viper.SetDefault("DB_CHARSET", "utf8mb4")
+	viper.SetDefault("DB_LOC", "Local")
+	viper.SetDefault("DB_CONNECT_BACKOFF", DefaultDBConnectBackoff)
+	viper.SetDefault("DB_REPLICA_CHECK_INTERVAL", DefaultDBReplicaCheckInterval)
+	viper.SetDefault("SOFT_DELETE_RETENTION", DefaultSoftDeleteRetention)
+	viper.SetDefault("PURGE_INTERVAL", DefaultPurgeInterval)
+	viper.SetDefault("HTTP_READ_TIMEOUT", DefaultHTTPReadTimeout)
+	viper.SetDefault("HTTP_WRITE_TIMEOUT", DefaultHTTPWriteTimeout)
+	viper.SetDefault("HTTP_IDLE_TIMEOUT", DefaultHTTPIdleTimeout)
+	viper.SetDefault("SHUTDOWN_TIMEOUT", DefaultShutdownTimeout)
+	viper.SetDefault("SHUTDOWN_DELAY", DefaultShutdownDelay)
 
 	if _, err := os.Stat(".env"); err == nil {
 		viper.SetConfigFile(".env")
@@ -40,6 +144,7 @@ func LoadConfig() *Config {
 	}
 
 	cfg = Config{
+		ENV:         viper.GetString("ENV"),
 		HTTP_PORT:   viper.GetString("HTTP_PORT"),
 		DB_DRIVER:   viper.GetString("DB_DRIVER"),
 		DB_USER:     viper.GetString("DB_USER"),
@@ -47,6 +152,34 @@ func LoadConfig() *Config {
 		DB_HOST:     viper.GetString("DB_HOST"),
 		DB_PORT:     viper.GetString("DB_PORT"),
 		DB_NAME:     viper.GetString("DB_NAME"),
+
+		DB_MAX_OPEN_CONNS:     viper.GetInt("DB_MAX_OPEN_CONNS"),
+		DB_MAX_IDLE_CONNS:     viper.GetInt("DB_MAX_IDLE_CONNS"),
+		DB_CONN_MAX_LIFETIME:  viper.GetDuration("DB_CONN_MAX_LIFETIME"),
+		DB_CONN_MAX_IDLE_TIME: viper.GetDuration("DB_CONN_MAX_IDLE_TIME"),
+		DB_CONNECT_TIMEOUT:    viper.GetDuration("DB_CONNECT_TIMEOUT"),
+		DB_READ_TIMEOUT:       viper.GetDuration("DB_READ_TIMEOUT"),
+		DB_WRITE_TIMEOUT:      viper.GetDuration("DB_WRITE_TIMEOUT"),
+		DB_TLS:                viper.GetString("DB_TLS"),
+		DB_CHARSET:            viper.GetString("DB_CHARSET"),
+		DB_LOC:                viper.GetString("DB_LOC"),
+		DB_CONNECT_RETRIES:    viper.GetInt("DB_CONNECT_RETRIES"),
+		DB_CONNECT_BACKOFF:    viper.GetDuration("DB_CONNECT_BACKOFF"),
+
+		DB_REPLICAS:               viper.GetString("DB_REPLICAS"),
+		DB_REPLICA_CHECK_INTERVAL: viper.GetDuration("DB_REPLICA_CHECK_INTERVAL"),
+
+		AUTO_MIGRATE: viper.GetBool("AUTO_MIGRATE"),
+		ADMIN_TOKEN:  viper.GetString("ADMIN_TOKEN"),
+
+		SOFT_DELETE_RETENTION: viper.GetDuration("SOFT_DELETE_RETENTION"),
+		PURGE_INTERVAL:        viper.GetDuration("PURGE_INTERVAL"),
+
+		HTTP_READ_TIMEOUT:  viper.GetDuration("HTTP_READ_TIMEOUT"),
+		HTTP_WRITE_TIMEOUT: viper.GetDuration("HTTP_WRITE_TIMEOUT"),
+		HTTP_IDLE_TIMEOUT:  viper.GetDuration("HTTP_IDLE_TIMEOUT"),
+		SHUTDOWN_TIMEOUT:   viper.GetDuration("SHUTDOWN_TIMEOUT"),
+		SHUTDOWN_DELAY:     viper.GetDuration("SHUTDOWN_DELAY"),
 	}
 
 	return &cfg
@@ -55,3 +188,112 @@ func LoadConfig() *Config {
 func GetConfig() *Config {
 	return &cfg
 }
+
+// Driver returns DB_DRIVER normalised to one of the Driver constants. Common
+// aliases are accepted and an empty value means MySQL. Unknown values are
+// returned lower-cased so callers can report them.
+func (c *Config) Driver() string {
+	switch driver := strings.ToLower(c.DB_DRIVER); driver {
+	case "", DriverMySQL:
+		return DriverMySQL
+	case DriverPostgres, "postgresql", "pgx":
+		return DriverPostgres
+	case DriverSQLite, "sqlite3":
+		return DriverSQLite
+	default:
+		return driver
+	}
+}
+
+// IsDevelopment reports whether ENV is explicitly "development". Internal
+// error details are only shown then, so a deployment that forgets to set ENV
+// does not leak them.
+func (c *Config) IsDevelopment() bool {
+	return c.ENV == DevelopmentEnv
+}
+
+// DSN builds the connection string for Driver. For SQLite, DB_NAME is the
+// path of the database file and the connection fields are ignored.
+func (c *Config) DSN() string {
+	switch c.Driver() {
+	case DriverPostgres:
+		sslmode := c.DB_TLS
+		if sslmode == "" {
+			sslmode = "disable"
+		}
+		dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+			pgValue(c.DB_HOST),
+			pgValue(c.DB_PORT),
+			pgValue(c.DB_USER),
+			pgValue(c.DB_PASSWORD),
+			pgValue(c.DB_NAME),
+			pgValue(sslmode),
+		)
+		if c.DB_CONNECT_TIMEOUT > 0 {
+			// connect_timeout is in whole seconds.
+			dsn += fmt.Sprintf(" connect_timeout=%d", int(math.Ceil(c.DB_CONNECT_TIMEOUT.Seconds())))
+		}
+		return dsn
+	case DriverSQLite:
+		return fmt.Sprintf("file:%s?_foreign_keys=on&_busy_timeout=5000", c.DB_NAME)
+	default:
+		params := url.Values{}
+		params.Set("parseTime", "True")
+		if c.DB_CHARSET != "" {
+			params.Set("charset", c.DB_CHARSET)
+		}
+		if c.DB_LOC != "" {
+			params.Set("loc", c.DB_LOC)
+		}
+		if c.DB_TLS != "" {
+			params.Set("tls", c.DB_TLS)
+		}
+		if c.DB_CONNECT_TIMEOUT > 0 {
+			params.Set("timeout", c.DB_CONNECT_TIMEOUT.String())
+		}
+		if c.DB_READ_TIMEOUT > 0 {
+			params.Set("readTimeout", c.DB_READ_TIMEOUT.String())
+		}
+		if c.DB_WRITE_TIMEOUT > 0 {
+			params.Set("writeTimeout", c.DB_WRITE_TIMEOUT.String())
+		}
+		return fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?%s",
+			c.DB_USER,
+			c.DB_PASSWORD,
+			c.DB_HOST,
+			c.DB_PORT,
+			c.DB_NAME,
+			params.Encode(),
+		)
+	}
+}
+
+// pgValue quotes v for a PostgreSQL keyword/value connection string, so that
+// a password with spaces or quotes cannot end the value early.
+func pgValue(v string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v) + "'"
+}
+
+// ReplicaAddrs returns the host:port of every DB_REPLICAS entry. Entries
+// without a port use DB_PORT.
+func (c *Config) ReplicaAddrs() []string {
+	var addrs []string
+	for _, entry := range strings.Split(c.DB_REPLICAS, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(entry); err != nil {
+			entry = net.JoinHostPort(entry, c.DB_PORT)
+		}
+		addrs = append(addrs, entry)
+	}
+	return addrs
+}
+
+// ReplicaDSN is DSN for the replica at addr, one of ReplicaAddrs.
+func (c *Config) ReplicaDSN(addr string) string {
+	replica := *c
+	replica.DB_HOST, replica.DB_PORT, _ = net.SplitHostPort(addr)
+	return replica.DSN()
+}
@@ -0,0 +1,26 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestPostgresDSN(t *testing.T) {
+	passwords := []string{"secret", "", "two words", `it's`, `back\slash`, `a=b c='d'`}
+
+	for _, password := range passwords {
+		t.Run(password, func(t *testing.T) {
+			c := &Config{DB_DRIVER: DriverPostgres, DB_HOST: "db", DB_PORT: "5432", DB_USER: "app", DB_PASSWORD: password, DB_NAME: "shop"}
+
+			parsed, err := pgconn.ParseConfig(c.DSN())
+			if err != nil {
+				t.Fatalf("ParseConfig(%q) failed: %v", c.DSN(), err)
+			}
+			if parsed.Password != password || parsed.User != "app" || parsed.Database != "shop" || parsed.Host != "db" {
+				t.Errorf("DSN %q parsed as user %q, password %q, database %q, host %q",
+					c.DSN(), parsed.User, parsed.Password, parsed.Database, parsed.Host)
+			}
+		})
+	}
+}
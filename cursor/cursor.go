@@ -0,0 +1,213 @@
+// Package cursor implements keyset pagination: pages are read after (or
+// before) the row a cursor marks instead of at an offset, so they stay stable
+// while rows are inserted and never need a COUNT.
+package cursor
+
+import (
+	"db_blueprints/apperror"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Cursor marks the row at the edge of a keyset-paginated page. It is sent to
+// clients as an opaque base64 token and must only be decoded by Decode.
+type Cursor struct {
+	OrderBy  string `json:"o"`
+	Desc     bool   `json:"d,omitempty"`
+	Value    string `json:"v"`
+	ID       int64  `json:"id"`
+	Backward bool   `json:"b,omitempty"`
+}
+
+func Encode(c Cursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// Decode parses a token produced by Encode. An empty token means "first page"
+// and yields a nil cursor.
+func Decode(token string) (*Cursor, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, apperror.Validation("invalid cursor")
+	}
+
+	var c Cursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, apperror.Validation("invalid cursor")
+	}
+
+	return &c, nil
+}
+
+// SortKey is a column that keyset pagination can order by. Format renders a
+// row's value into the cursor and Parse turns it back into a query argument.
+type SortKey[T any] struct {
+	Column string
+	Format func(*T) string
+	Parse  func(string) (any, error)
+}
+
+func IntKey[T any](column string, get func(*T) int64) SortKey[T] {
+	return SortKey[T]{
+		Column: column,
+		Format: func(item *T) string { return strconv.FormatInt(get(item), 10) },
+		Parse:  func(v string) (any, error) { return strconv.ParseInt(v, 10, 64) },
+	}
+}
+
+func FloatKey[T any](column string, get func(*T) float64) SortKey[T] {
+	return SortKey[T]{
+		Column: column,
+		Format: func(item *T) string { return strconv.FormatFloat(get(item), 'g', -1, 64) },
+		Parse:  func(v string) (any, error) { return strconv.ParseFloat(v, 64) },
+	}
+}
+
+func StringKey[T any](column string, get func(*T) string) SortKey[T] {
+	return SortKey[T]{
+		Column: column,
+		Format: get,
+		Parse:  func(v string) (any, error) { return v, nil },
+	}
+}
+
+func TimeKey[T any](column string, get func(*T) time.Time) SortKey[T] {
+	return SortKey[T]{
+		Column: column,
+		Format: func(item *T) string { return get(item).Format(time.RFC3339Nano) },
+		Parse:  func(v string) (any, error) { return time.Parse(time.RFC3339Nano, v) },
+	}
+}
+
+// Keyset builds the query parts of a cursor-paginated listing ordered by Key
+// and then by id, so rows sharing the same Key value keep a stable order.
+type Keyset[T any] struct {
+	Key    SortKey[T]
+	Desc   bool
+	Size   int64
+	Cursor *Cursor
+	ID     func(*T) int64
+}
+
+// NewKeyset decodes token and checks that it was issued for the same
+// ordering. size is the number of rows per page and must be positive.
+func NewKeyset[T any](key SortKey[T], desc bool, token string, size int64, id func(*T) int64) (*Keyset[T], error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("keyset page size must be positive, got %d", size)
+	}
+	cursor, err := Decode(token)
+	if err != nil {
+		return nil, err
+	}
+	if cursor != nil && (cursor.OrderBy != key.Column || cursor.Desc != desc) {
+		return nil, apperror.Validation("cursor does not match the requested ordering")
+	}
+
+	return &Keyset[T]{Key: key, Desc: desc, Size: size, Cursor: cursor, ID: id}, nil
+}
+
+// Where returns the predicate selecting rows after (or, for a backward
+// cursor, before) the cursor. It is empty on the first page.
+func (k *Keyset[T]) Where() (string, []any, error) {
+	if k.Cursor == nil {
+		return "", nil, nil
+	}
+
+	op := ">"
+	if k.Desc != k.Cursor.Backward {
+		op = "<"
+	}
+
+	if k.Key.Column == "id" {
+		return fmt.Sprintf("id %s ?", op), []any{k.Cursor.ID}, nil
+	}
+
+	value, err := k.Key.Parse(k.Cursor.Value)
+	if err != nil {
+		return "", nil, apperror.Validation("invalid cursor")
+	}
+
+	where := fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", k.Key.Column, op)
+	return where, []any{value, value, k.Cursor.ID}, nil
+}
+
+// OrderBy returns the ORDER BY list. Backward pages are read in reverse and
+// flipped back by Page.
+func (k *Keyset[T]) OrderBy() string {
+	dir := "ASC"
+	if k.Descending() {
+		dir = "DESC"
+	}
+
+	if k.Key.Column == "id" {
+		return "id " + dir
+	}
+	return fmt.Sprintf("%s %s, id %s", k.Key.Column, dir, dir)
+}
+
+// Descending reports whether rows are read in descending order: a descending
+// sort, or a backward page of an ascending one.
+func (k *Keyset[T]) Descending() bool {
+	return k.Desc != (k.Cursor != nil && k.Cursor.Backward)
+}
+
+// Limit fetches one extra row to find out whether another page exists.
+func (k *Keyset[T]) Limit() int64 {
+	return k.Size + 1
+}
+
+// Page is the metadata of a page: its size and the cursors of the pages
+// before and after it, empty when there is none.
+type Page struct {
+	Size       int64
+	NextCursor string
+	PrevCursor string
+}
+
+// Page trims the look-ahead row, restores the requested order and returns
+// the cursors of the pages around the items.
+func (k *Keyset[T]) Page(items []*T) ([]*T, Page) {
+	backward := k.Cursor != nil && k.Cursor.Backward
+
+	hasMore := int64(len(items)) > k.Size
+	if hasMore {
+		items = items[:k.Size]
+	}
+	if backward {
+		slices.Reverse(items)
+	}
+
+	page := Page{Size: k.Size}
+	if len(items) == 0 {
+		return items, page
+	}
+
+	first, last := items[0], items[len(items)-1]
+	if hasMore || backward {
+		page.NextCursor = k.cursorAt(last, false)
+	}
+	if (backward && hasMore) || (!backward && k.Cursor != nil) {
+		page.PrevCursor = k.cursorAt(first, true)
+	}
+
+	return items, page
+}
+
+func (k *Keyset[T]) cursorAt(item *T, backward bool) string {
+	return Encode(Cursor{
+		OrderBy:  k.Key.Column,
+		Desc:     k.Desc,
+		Value:    k.Key.Format(item),
+		ID:       k.ID(item),
+		Backward: backward,
+	})
+}
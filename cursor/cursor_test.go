@@ -0,0 +1,176 @@
+package cursor
+
+import (
+	"database/sql"
+	"db_blueprints/apperror"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type item struct {
+	ID    int64
+	Price float64
+}
+
+var (
+	itemID   = func(i *item) int64 { return i.ID }
+	priceKey = FloatKey("price", func(i *item) float64 { return i.Price })
+	idKey    = IntKey("id", itemID)
+)
+
+// openItems returns a table of seven items whose prices repeat, so paging by
+// price has to fall back to id to keep a stable order.
+func openItems(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := db.Exec("CREATE TABLE items (id INTEGER PRIMARY KEY, price REAL NOT NULL)"); err != nil {
+		t.Fatal(err)
+	}
+	for i, price := range []float64{3, 1, 2, 1, 3, 2, 1} {
+		if _, err := db.Exec("INSERT INTO items (id, price) VALUES (?, ?)", i+1, price); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+// fetch reads the page of k the way the repositories do.
+func fetch(t *testing.T, db *sql.DB, k *Keyset[item]) ([]int64, Page) {
+	t.Helper()
+
+	where, args, err := k.Where()
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := "SELECT id, price FROM items"
+	if where != "" {
+		query += " WHERE " + where
+	}
+	query += fmt.Sprintf(" ORDER BY %s LIMIT %d", k.OrderBy(), k.Limit())
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var items []*item
+	for rows.Next() {
+		var i item
+		if err := rows.Scan(&i.ID, &i.Price); err != nil {
+			t.Fatal(err)
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	items, page := k.Page(items)
+	ids := make([]int64, len(items))
+	for i, it := range items {
+		ids[i] = it.ID
+	}
+	return ids, page
+}
+
+func TestKeysetRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		key   SortKey[item]
+		desc  bool
+		pages [][]int64
+	}{
+		{name: "id", key: idKey, pages: [][]int64{{1, 2, 3}, {4, 5, 6}, {7}}},
+		{name: "id descending", key: idKey, desc: true, pages: [][]int64{{7, 6, 5}, {4, 3, 2}, {1}}},
+		{name: "price ties broken by id", key: priceKey, pages: [][]int64{{2, 4, 7}, {3, 6, 1}, {5}}},
+		{name: "price descending", key: priceKey, desc: true, pages: [][]int64{{5, 1, 6}, {3, 7, 4}, {2}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := openItems(t)
+
+			// Walk forward to the last page, then back to the first one.
+			var prev []string
+			token := ""
+			for i, want := range tt.pages {
+				k, err := NewKeyset(tt.key, tt.desc, token, 3, itemID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ids, page := fetch(t, db, k)
+				if !reflect.DeepEqual(ids, want) {
+					t.Fatalf("page %d = %v, want %v", i, ids, want)
+				}
+				if last := i == len(tt.pages)-1; (page.NextCursor == "") != last {
+					t.Fatalf("page %d next cursor = %q", i, page.NextCursor)
+				}
+				if (page.PrevCursor == "") != (i == 0) {
+					t.Fatalf("page %d prev cursor = %q", i, page.PrevCursor)
+				}
+				prev = append(prev, page.PrevCursor)
+				token = page.NextCursor
+			}
+
+			for i := len(tt.pages) - 1; i > 0; i-- {
+				k, err := NewKeyset(tt.key, tt.desc, prev[i], 3, itemID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ids, page := fetch(t, db, k)
+				if want := tt.pages[i-1]; !reflect.DeepEqual(ids, want) {
+					t.Fatalf("going back to page %d = %v, want %v", i-1, ids, want)
+				}
+				if (page.PrevCursor == "") != (i == 1) || page.NextCursor == "" {
+					t.Fatalf("going back to page %d gave cursors %+v", i-1, page)
+				}
+			}
+		})
+	}
+}
+
+func TestNewKeysetRejects(t *testing.T) {
+	token := Encode(Cursor{OrderBy: "price", Value: "2", ID: 3})
+
+	tests := []struct {
+		name  string
+		key   SortKey[item]
+		desc  bool
+		token string
+	}{
+		{name: "other column", key: idKey, token: token},
+		{name: "other direction", key: priceKey, desc: true, token: token},
+		{name: "not base64", key: priceKey, token: "not a cursor"},
+		{name: "not json", key: priceKey, token: "bm90IGpzb24"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewKeyset(tt.key, tt.desc, tt.token, 3, itemID); !errors.Is(err, apperror.ErrValidation) {
+				t.Errorf("NewKeyset() error = %v, want a validation error", err)
+			}
+		})
+	}
+}
+
+func TestWhereRejectsBadValue(t *testing.T) {
+	k, err := NewKeyset(priceKey, false, Encode(Cursor{OrderBy: "price", Value: "cheap", ID: 3}), 3, itemID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := k.Where(); !errors.Is(err, apperror.ErrValidation) {
+		t.Errorf("Where() error = %v, want a validation error", err)
+	}
+}
@@ -1,37 +1,98 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"db_blueprints/config"
 	db "db_blueprints/db_sql/database"
+	product "db_blueprints/db_sql/internal/domain/product/repository"
+	user "db_blueprints/db_sql/internal/domain/user/repository"
 	"db_blueprints/db_sql/internal/server"
+	"db_blueprints/dbschema"
+	"db_blueprints/migration"
+	"flag"
 	"fmt"
 	"log"
-	"sync"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
-var wg sync.WaitGroup
-
 func main() {
 	cfg := config.LoadConfig()
 
+	autoMigrate := flag.Bool("auto-migrate", cfg.AUTO_MIGRATE, "apply pending migrations before starting the server")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] [migrate|schema <command>]\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintln(flag.CommandLine.Output(), "\n"+migration.Usage)
+		fmt.Fprintln(flag.CommandLine.Output(), "\n"+dbschema.Usage)
+	}
+	flag.Parse()
+
 	database, err := db.NewDatabase(cfg)
 	if err != nil {
-		fmt.Println("Cannot connect to database", err)
+		// Only "schema diff -stand-in" can do without a database.
+		if flag.Arg(0) != "schema" {
+			log.Fatalln("Cannot connect to database:", err)
+		}
+		log.Println("Cannot connect to database:", err)
 	}
 
-	httpSvr := server.NewServer(database, cfg)
+	// Run "schema <command>" and exit. It can run without a connection
+	// when checking the migrations alone.
+	if flag.Arg(0) == "schema" {
+		if err := runSchema(database, cfg, flag.Args()[1:]); err != nil {
+			log.Fatalln("Schema error:", err)
+		}
+		return
+	}
+	defer database.Close()
 
-	wg.Add(1)
+	// Run "migrate <command>" and exit
+	if flag.Arg(0) == "migrate" {
+		if err := runMigrate(database, cfg, flag.Args()[1:]); err != nil {
+			log.Fatalln("Migration error:", err)
+		}
+		return
+	}
 
-	// Run HTTP server
-	go func() {
-		defer wg.Done()
-		if err := httpSvr.Run(); err != nil {
-			log.Println("Running HTTP server error:", err)
+	if *autoMigrate {
+		if err := runMigrate(database, cfg, []string{"up"}); err != nil {
+			log.Fatalln("Migration error:", err)
 		}
-	}()
+	}
+
+	// SIGINT or SIGTERM starts a graceful shutdown. A second one kills the
+	// process without waiting for in-flight requests.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	context.AfterFunc(ctx, stop)
 
-	wg.Wait()
+	httpSvr := server.NewServer(database, cfg)
+	if err := httpSvr.Run(ctx); err != nil {
+		database.Close()
+		log.Fatalln("Running HTTP server error:", err)
+	}
+	log.Println("Server stopped")
+}
+
+func runMigrate(database *db.DB, cfg *config.Config, args []string) error {
+	migrator, err := migration.New(database.DB, cfg.Driver())
+	if err != nil {
+		return err
+	}
+	migrator.Logf = log.Printf
+
+	return migration.Command(context.Background(), migrator, args, os.Stdout)
+}
+
+func runSchema(database *db.DB, cfg *config.Config, args []string) error {
+	var sqlDB *sql.DB
+	if database != nil {
+		sqlDB = database.DB
+	}
 
-	println(database)
+	expected := []dbschema.Table{user.Schema(), product.Schema()}
+	return dbschema.Command(context.Background(), sqlDB, cfg.Driver(), expected, args, os.Stdout)
 }
@@ -4,11 +4,12 @@ import (
 	"context"
 	"database/sql"
 	"db_blueprints/config"
+	"db_blueprints/dbpool"
 	"fmt"
 
-	"time"
-
 	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "github.com/mattn/go-sqlite3"
 )
 
 type DBTX interface {
@@ -18,29 +19,43 @@ type DBTX interface {
 	QueryRowContext(context.Context, string, ...interface{}) *sql.Row
 }
 
-func NewDatabase(config *config.Config) (*sql.DB, error) {
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local",
-		config.DB_USER,
-		config.DB_PASSWORD,
-		config.DB_HOST,
-		config.DB_PORT,
-		config.DB_NAME,
-	)
+// NewDatabase opens the database selected by DB_DRIVER (mysql, postgres or
+// sqlite) and wraps it so queries written with ? placeholders work on all
+// of them. The pool is sized from the DB_* settings, and the first
+// connection is retried DB_CONNECT_RETRIES times. Reads are spread over the
+// DB_REPLICAS, if any.
+func NewDatabase(config *config.Config) (*DB, error) {
+	var driverName string
+	dialect := Dialect(config.Driver())
+	switch dialect {
+	case MySQL:
+		driverName = "mysql"
+	case Postgres:
+		driverName = "pgx"
+	case SQLite:
+		driverName = "sqlite3"
+	default:
+		return nil, fmt.Errorf("unsupported DB_DRIVER %q", config.DB_DRIVER)
+	}
 
-	db, err := sql.Open("mysql", dsn)
+	db, err := sql.Open(driverName, config.DSN())
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database connection: %w", err)
 	}
 
-	db.SetMaxOpenConns(25)
-	db.SetMaxIdleConns(25)
-	db.SetConnMaxLifetime(5 * time.Minute)
+	dbpool.Configure(db, config)
 
-	if err := db.Ping(); err != nil {
+	if err := dbpool.Connect(context.Background(), config, db.PingContext); err != nil {
 		db.Close()
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
+	replicas, err := dbpool.OpenReplicas(config, driverName)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
 	fmt.Println("Successfully connected to the database!")
-	return db, nil
+	return &DB{DB: db, dialect: dialect, replicas: replicas}, nil
 }
@@ -0,0 +1,138 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"db_blueprints/dbpool"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dialect is the SQL flavour of the connected database. Repositories write
+// queries with ? placeholders and DB/Tx rewrite them for the dialect.
+type Dialect string
+
+const (
+	MySQL    Dialect = "mysql"
+	Postgres Dialect = "postgres"
+	SQLite   Dialect = "sqlite"
+)
+
+// Rebind rewrites ? placeholders into $1, $2, ... for PostgreSQL. Question
+// marks inside single-quoted literals are left alone.
+func (d Dialect) Rebind(query string) string {
+	if d != Postgres || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+
+	n, quoted := 0, false
+	for i := 0; i < len(query); i++ {
+		switch c := query[i]; {
+		case c == '\'':
+			quoted = !quoted
+			b.WriteByte(c)
+		case c == '?' && !quoted:
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// DialectOf returns the dialect of db. Connections that were not opened
+// through NewDatabase, such as a bare *sql.DB, are assumed to be MySQL.
+func DialectOf(db DBTX) Dialect {
+	if d, ok := db.(interface{ Dialect() Dialect }); ok {
+		return d.Dialect()
+	}
+	return MySQL
+}
+
+// DB is a *sql.DB that rebinds placeholders for its dialect. The *sql.DB is
+// the primary; with read replicas, QueryContext and QueryRowContext send
+// SELECTs to one of them.
+type DB struct {
+	*sql.DB
+	dialect  Dialect
+	replicas *dbpool.Replicas
+	observe  QueryObserver
+}
+
+func (db *DB) Dialect() Dialect {
+	return db.dialect
+}
+
+func (db *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	result, err := db.DB.ExecContext(ctx, db.dialect.Rebind(query), args...)
+	db.observe.done(ctx, start, err)
+	return result, err
+}
+
+func (db *DB) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	start := time.Now()
+	stmt, err := db.DB.PrepareContext(ctx, db.dialect.Rebind(query))
+	db.observe.done(ctx, start, err)
+	return stmt, err
+}
+
+func (db *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := db.reader(ctx, query).QueryContext(ctx, db.dialect.Rebind(query), args...)
+	db.observe.done(ctx, start, err)
+	return rows, err
+}
+
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	start := time.Now()
+	row := db.reader(ctx, query).QueryRowContext(ctx, db.dialect.Rebind(query), args...)
+	db.observe.done(ctx, start, row.Err())
+	return row
+}
+
+// Tx is a *sql.Tx that rebinds placeholders for its dialect. WithTx hands
+// one to its callback.
+type Tx struct {
+	*sql.Tx
+	dialect Dialect
+	observe QueryObserver
+}
+
+func (tx *Tx) Dialect() Dialect {
+	return tx.dialect
+}
+
+func (tx *Tx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	result, err := tx.Tx.ExecContext(ctx, tx.dialect.Rebind(query), args...)
+	tx.observe.done(ctx, start, err)
+	return result, err
+}
+
+func (tx *Tx) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	start := time.Now()
+	stmt, err := tx.Tx.PrepareContext(ctx, tx.dialect.Rebind(query))
+	tx.observe.done(ctx, start, err)
+	return stmt, err
+}
+
+func (tx *Tx) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := tx.Tx.QueryContext(ctx, tx.dialect.Rebind(query), args...)
+	tx.observe.done(ctx, start, err)
+	return rows, err
+}
+
+func (tx *Tx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	start := time.Now()
+	row := tx.Tx.QueryRowContext(ctx, tx.dialect.Rebind(query), args...)
+	tx.observe.done(ctx, start, row.Err())
+	return row
+}
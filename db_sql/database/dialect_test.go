@@ -0,0 +1,69 @@
+package database
+
+import "testing"
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialect Dialect
+		query   string
+		want    string
+	}{
+		{
+			name:    "numbers placeholders in order",
+			dialect: Postgres,
+			query:   "SELECT id FROM users WHERE email = ? AND id > ? LIMIT ?",
+			want:    "SELECT id FROM users WHERE email = $1 AND id > $2 LIMIT $3",
+		},
+		{
+			name:    "no placeholder",
+			dialect: Postgres,
+			query:   "SELECT 1",
+			want:    "SELECT 1",
+		},
+		{
+			name:    "more than nine placeholders",
+			dialect: Postgres,
+			query:   "IN (?,?,?,?,?,?,?,?,?,?,?)",
+			want:    "IN ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)",
+		},
+		{
+			name:    "quoted question mark is kept",
+			dialect: Postgres,
+			query:   "SELECT '?' AS mark, name FROM users WHERE id = ?",
+			want:    "SELECT '?' AS mark, name FROM users WHERE id = $1",
+		},
+		{
+			name:    "escaped quote inside a literal",
+			dialect: Postgres,
+			query:   "SELECT * FROM users WHERE name = 'it''s ?' AND id = ?",
+			want:    "SELECT * FROM users WHERE name = 'it''s ?' AND id = $1",
+		},
+		{
+			name:    "placeholders around a literal",
+			dialect: Postgres,
+			query:   "UPDATE products SET name = ?, note = 'why?' WHERE id = ?",
+			want:    "UPDATE products SET name = $1, note = 'why?' WHERE id = $2",
+		},
+		{
+			name:    "mysql is unchanged",
+			dialect: MySQL,
+			query:   "SELECT id FROM users WHERE id = ?",
+			want:    "SELECT id FROM users WHERE id = ?",
+		},
+		{
+			name:    "sqlite is unchanged",
+			dialect: SQLite,
+			query:   "SELECT id FROM users WHERE id = ?",
+			want:    "SELECT id FROM users WHERE id = ?",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.dialect.Rebind(tt.query); got != tt.want {
+				t.Errorf("Rebind(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
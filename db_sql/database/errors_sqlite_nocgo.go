@@ -0,0 +1,9 @@
+//go:build !cgo
+
+package database
+
+// translateSQLiteError is a no-op without cgo, where the SQLite driver is a
+// stub that cannot open a database.
+func translateSQLiteError(err error) (error, bool) {
+	return nil, false
+}
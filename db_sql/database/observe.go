@@ -0,0 +1,32 @@
+package database
+
+import (
+	"context"
+	"time"
+)
+
+// QueryObserver is called after every statement once the database has
+// answered it, with the context of the statement, the time it started and its
+// error.
+type QueryObserver func(ctx context.Context, start time.Time, err error)
+
+func (o QueryObserver) done(ctx context.Context, start time.Time, err error) {
+	if o != nil {
+		o(ctx, start, err)
+	}
+}
+
+// Observe makes db, and the transactions WithTx starts on it, call observe
+// after every statement. It must be called before db is used.
+func (db *DB) Observe(observe QueryObserver) {
+	db.observe = observe
+}
+
+// observerOf returns the QueryObserver of db, nil for connections that were
+// not opened through NewDatabase.
+func observerOf(db DBTX) QueryObserver {
+	if d, ok := db.(*DB); ok {
+		return d.observe
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"db_blueprints/dbpool"
+	"errors"
+	"strings"
+)
+
+// Replicas returns the read replicas of db, nil when there are none.
+func (db *DB) Replicas() *dbpool.Replicas {
+	return db.replicas
+}
+
+// Close closes the replicas, then the primary.
+func (db *DB) Close() error {
+	var err error
+	if db.replicas != nil {
+		err = db.replicas.Close()
+	}
+	return errors.Join(err, db.DB.Close())
+}
+
+// reader returns the pool query runs on. A SELECT goes to a healthy replica
+// unless ctx carries a transaction or asks for the primary (see
+// dbpool.WithPrimary). Anything else, such as INSERT ... RETURNING, goes to
+// the primary, and so does every query while no replica is healthy.
+func (db *DB) reader(ctx context.Context, query string) *sql.DB {
+	if db.replicas == nil || dbpool.UsesPrimary(ctx) || !isSelect(query) {
+		return db.DB
+	}
+	if _, inTx := TxFromContext(ctx); inTx {
+		return db.DB
+	}
+	if i := db.replicas.Pick(); i >= 0 {
+		return db.replicas.DB(i)
+	}
+	return db.DB
+}
+
+// isSelect reports whether query is a SELECT that takes no row locks.
+func isSelect(query string) bool {
+	query = strings.ToUpper(strings.TrimSpace(query))
+	return strings.HasPrefix(query, "SELECT") &&
+		!strings.Contains(query, " FOR UPDATE") &&
+		!strings.Contains(query, " FOR SHARE")
+}
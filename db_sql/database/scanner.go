@@ -2,7 +2,13 @@ package database
 
 import "database/sql"
 
-type ScanFunc[T any] func(*sql.Rows) (*T, error)
+// Scanner is implemented by both *sql.Row and *sql.Rows, so one ScanFunc
+// serves single-row lookups and listings alike.
+type Scanner interface {
+	Scan(dest ...any) error
+}
+
+type ScanFunc[T any] func(Scanner) (*T, error)
 
 func ScanRows[T any](rows *sql.Rows, scan ScanFunc[T]) ([]*T, error) {
 	defer rows.Close()
@@ -23,3 +29,21 @@ func ScanRows[T any](rows *sql.Rows, scan ScanFunc[T]) ([]*T, error) {
 
 	return results, nil
 }
+
+// StreamRows scans rows one at a time and hands each item to fn, so the
+// result set is never held in memory. It stops at the first error.
+func StreamRows[T any](rows *sql.Rows, scan ScanFunc[T], fn func(*T) error) error {
+	defer rows.Close()
+
+	for rows.Next() {
+		item, err := scan(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
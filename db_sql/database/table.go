@@ -0,0 +1,495 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"db_blueprints/apperror"
+	"db_blueprints/dbschema"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Table maps an entity to a table without reflection: the entity supplies its
+// column list together with the functions that scan and bind it, and Table
+// builds the SQL for the usual CRUD and listing queries.
+//
+// Columns and Scan must agree on order, as must Writable and Values. The
+// table is expected to have an auto-increment "id" primary key and, when
+// Timestamps is set, created_at/updated_at columns that Table maintains.
+// Queries are written with ? placeholders and portable SQL; DB and Tx rebind
+// them for the dialect.
+//
+// With SoftDelete set, the table needs a nullable deleted_at column: Delete
+// only stamps it, and every other query skips the rows it marks. Unscoped
+// returns a view of the table that sees them again, as in gorm.
+//
+// With Version set, the table needs an integer version column defaulting to
+// 1. Update then only writes a row whose version still matches the item's
+// and bumps it, so concurrent writers cannot overwrite each other unnoticed.
+type Table[T any] struct {
+	Name       string
+	Entity     string
+	Columns    []string
+	Writable   []string
+	Timestamps bool
+	SoftDelete bool
+
+	Scan   ScanFunc[T]
+	Values func(*T) []any
+	ID     func(*T) int64
+	SetID  func(*T, int64)
+
+	Version    func(*T) int64
+	SetVersion func(*T, int64)
+}
+
+// ListQuery narrows a Table listing. Each Where condition is parenthesised
+// and joined to the query with AND, and must only use placeholders for values; OrderBy is inserted verbatim, so
+// it must come from a whitelist. A zero Limit means no limit.
+type ListQuery struct {
+	Where   []string
+	Args    []any
+	OrderBy string
+	Limit   int64
+	Offset  int64
+}
+
+// And adds a condition and its arguments to q.
+func (q *ListQuery) And(cond string, args ...any) {
+	q.Where = append(q.Where, cond)
+	q.Args = append(q.Args, args...)
+}
+
+// Unscoped returns a copy of t that ignores soft deletes: its queries see
+// deleted rows and its Delete removes rows for good.
+func (t *Table[T]) Unscoped() *Table[T] {
+	unscoped := *t
+	unscoped.SoftDelete = false
+	return &unscoped
+}
+
+func (t *Table[T]) Get(ctx context.Context, db DBTX, id int64) (*T, error) {
+	query := fmt.Sprintf("SELECT %s FROM %s%s", t.columns(), t.Name, t.where([]string{"id = ?"}))
+	item, err := t.Scan(db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, t.notFound(id)
+		}
+		return nil, TranslateError(fmt.Errorf("get %s by id: %w", t.Entity, err))
+	}
+	return item, nil
+}
+
+// Insert writes item and sets its ID from the generated key. PostgreSQL has
+// no LastInsertId, so the key is read back with RETURNING there.
+func (t *Table[T]) Insert(ctx context.Context, db DBTX, item *T) error {
+	columns := t.Writable
+	placeholders := strings.Repeat("?, ", len(columns))
+	placeholders = strings.TrimSuffix(placeholders, ", ")
+	if t.Timestamps {
+		columns = append(columns[:len(columns):len(columns)], "created_at", "updated_at")
+		placeholders += ", CURRENT_TIMESTAMP, CURRENT_TIMESTAMP"
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", t.Name, strings.Join(columns, ", "), placeholders)
+
+	var id int64
+	if DialectOf(db) == Postgres {
+		if err := db.QueryRowContext(ctx, query+" RETURNING id", t.Values(item)...).Scan(&id); err != nil {
+			return TranslateError(fmt.Errorf("create %s: %w", t.Entity, err))
+		}
+	} else {
+		result, err := db.ExecContext(ctx, query, t.Values(item)...)
+		if err != nil {
+			return TranslateError(fmt.Errorf("create %s: %w", t.Entity, err))
+		}
+
+		id, err = result.LastInsertId()
+		if err != nil {
+			return fmt.Errorf("get last insert id for %s: %w", t.Entity, err)
+		}
+	}
+
+	t.SetID(item, id)
+	if t.SetVersion != nil {
+		t.SetVersion(item, 1)
+	}
+	return nil
+}
+
+// InsertInBatches writes items with multi-row INSERT statements of up to
+// size rows each and sets their IDs. Run it in a transaction to write all of
+// the items or none.
+//
+// PostgreSQL and SQLite read the keys back with RETURNING. MySQL only reports
+// the first key of a statement, and with innodb_autoinc_lock_mode = 2, the
+// default since MySQL 8.0, the keys of a multi-row INSERT need not follow it,
+// so on MySQL the items are inserted one row at a time instead.
+func (t *Table[T]) InsertInBatches(ctx context.Context, db DBTX, items []*T, size int) error {
+	if DialectOf(db) == MySQL {
+		for _, item := range items {
+			if err := t.Insert(ctx, db, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for start := 0; start < len(items); start += size {
+		if err := t.insertBatch(ctx, db, items[start:min(start+size, len(items))]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertBatch writes items with one INSERT and reads their keys back with
+// RETURNING.
+func (t *Table[T]) insertBatch(ctx context.Context, db DBTX, items []*T) error {
+	columns := t.Writable
+	row := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	if t.Timestamps {
+		columns = append(columns[:len(columns):len(columns)], "created_at", "updated_at")
+		row += ", CURRENT_TIMESTAMP, CURRENT_TIMESTAMP"
+	}
+	row += ")"
+
+	values := make([]string, 0, len(items))
+	args := make([]any, 0, len(items)*len(t.Writable))
+	for _, item := range items {
+		values = append(values, row)
+		args = append(args, t.Values(item)...)
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s RETURNING id", t.Name, strings.Join(columns, ", "), strings.Join(values, ", "))
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return TranslateError(fmt.Errorf("create %s: %w", t.Entity, err))
+	}
+	defer rows.Close()
+
+	ids := make([]int64, 0, len(items))
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return fmt.Errorf("scan %s id: %w", t.Entity, err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return TranslateError(fmt.Errorf("create %s: %w", t.Entity, err))
+	}
+	if len(ids) != len(items) {
+		return fmt.Errorf("create %s: got %d ids for %d rows", t.Entity, len(ids), len(items))
+	}
+
+	for i, item := range items {
+		t.SetID(item, ids[i])
+		if t.SetVersion != nil {
+			t.SetVersion(item, 1)
+		}
+	}
+	return nil
+}
+
+// Update writes the Writable columns of item to the row with its ID. On a
+// versioned table the row must still have the item's version, otherwise
+// Update fails with apperror.ErrVersionConflict; on success the item gets the
+// new version.
+func (t *Table[T]) Update(ctx context.Context, db DBTX, item *T) error {
+	return t.update(ctx, db, item, t.Writable, t.Values(item))
+}
+
+// UpdateColumns is Update restricted to the named Writable columns. It writes
+// nothing when no column is given.
+func (t *Table[T]) UpdateColumns(ctx context.Context, db DBTX, item *T, columns ...string) error {
+	if len(columns) == 0 {
+		return nil
+	}
+
+	values := t.Values(item)
+	picked := make([]any, 0, len(columns))
+	for _, column := range columns {
+		i := slices.Index(t.Writable, column)
+		if i < 0 {
+			return fmt.Errorf("update %s: %q is not a writable column", t.Entity, column)
+		}
+		picked = append(picked, values[i])
+	}
+	return t.update(ctx, db, item, columns, picked)
+}
+
+func (t *Table[T]) update(ctx context.Context, db DBTX, item *T, columns []string, values []any) error {
+	sets := make([]string, 0, len(columns)+2)
+	for _, column := range columns {
+		sets = append(sets, column+" = ?")
+	}
+	if t.Timestamps {
+		sets = append(sets, "updated_at = CURRENT_TIMESTAMP")
+	}
+
+	conds := []string{"id = ?"}
+	args := append(values[:len(values):len(values)], t.ID(item))
+	if t.Version != nil {
+		sets = append(sets, "version = version + 1")
+		conds = append(conds, "version = ?")
+		args = append(args, t.Version(item))
+	}
+
+	query := fmt.Sprintf("UPDATE %s SET %s%s", t.Name, strings.Join(sets, ", "), t.where(conds))
+	result, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return TranslateError(fmt.Errorf("update %s: %w", t.Entity, err))
+	}
+
+	if t.Version == nil {
+		return t.checkAffected(result, t.ID(item), "update")
+	}
+
+	affected, err := t.rowsAffected(result, "update")
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return t.versionConflict(ctx, db, item)
+	}
+	t.SetVersion(item, t.Version(item)+1)
+	return nil
+}
+
+// versionConflict explains why a versioned update matched no row: either the
+// row is gone or another write got there first.
+func (t *Table[T]) versionConflict(ctx context.Context, db DBTX, item *T) error {
+	exists, err := t.Exists(ctx, db, "id = ?", t.ID(item))
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return t.notFound(t.ID(item))
+	}
+	return apperror.VersionConflict("%s with id %d was modified since version %d", t.Entity, t.ID(item), t.Version(item))
+}
+
+// Delete removes the row with id, or marks it deleted on a SoftDelete table.
+func (t *Table[T]) Delete(ctx context.Context, db DBTX, id int64) error {
+	affected, err := t.DeleteWhere(ctx, db, "id = ?", id)
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return t.notFound(id)
+	}
+	return nil
+}
+
+// DeleteWhere deletes, or soft-deletes, every row matching cond and returns
+// how many there were.
+func (t *Table[T]) DeleteWhere(ctx context.Context, db DBTX, cond string, args ...any) (int64, error) {
+	query := fmt.Sprintf("DELETE FROM %s%s", t.Name, t.where([]string{cond}))
+	if t.SoftDelete {
+		query = fmt.Sprintf("UPDATE %s SET deleted_at = ?%s", t.Name, t.where([]string{cond}))
+		args = append([]any{deletionTime()}, args...)
+	}
+
+	result, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, TranslateError(fmt.Errorf("delete %s: %w", t.Entity, err))
+	}
+	return t.rowsAffected(result, "delete")
+}
+
+// Restore clears the deletion mark of the soft-deleted row with id.
+func (t *Table[T]) Restore(ctx context.Context, db DBTX, id int64) error {
+	affected, err := t.RestoreWhere(ctx, db, "id = ?", id)
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return apperror.NotFound("deleted %s with id %d not found", t.Entity, id)
+	}
+	return nil
+}
+
+// RestoreWhere clears the deletion mark of every soft-deleted row matching
+// cond and returns how many there were.
+func (t *Table[T]) RestoreWhere(ctx context.Context, db DBTX, cond string, args ...any) (int64, error) {
+	query := fmt.Sprintf("UPDATE %s SET deleted_at = NULL WHERE deleted_at IS NOT NULL AND (%s)", t.Name, cond)
+	result, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, TranslateError(fmt.Errorf("restore %s: %w", t.Entity, err))
+	}
+	return t.rowsAffected(result, "restore")
+}
+
+// Purge removes the rows soft-deleted before the given time for good.
+func (t *Table[T]) Purge(ctx context.Context, db DBTX, before time.Time) (int64, error) {
+	return t.Unscoped().DeleteWhere(ctx, db, "deleted_at IS NOT NULL AND deleted_at < ?", before)
+}
+
+// Exists reports whether any row matches cond.
+func (t *Table[T]) Exists(ctx context.Context, db DBTX, cond string, args ...any) (bool, error) {
+	var exists bool
+	query := fmt.Sprintf("SELECT EXISTS(SELECT 1 FROM %s%s)", t.Name, t.where([]string{cond}))
+	if err := db.QueryRowContext(ctx, query, args...).Scan(&exists); err != nil {
+		return false, TranslateError(fmt.Errorf("check %s exists: %w", t.Entity, err))
+	}
+	return exists, nil
+}
+
+// List returns one page of rows matching q together with the total number of
+// matching rows.
+func (t *Table[T]) List(ctx context.Context, db DBTX, q ListQuery) ([]*T, int64, error) {
+	var total int64
+	countQuery := fmt.Sprintf("SELECT COUNT(id) FROM %s%s", t.Name, t.where(q.Where))
+	if err := db.QueryRowContext(ctx, countQuery, q.Args...).Scan(&total); err != nil {
+		return nil, 0, TranslateError(fmt.Errorf("count %s: %w", t.Name, err))
+	}
+	if total == 0 {
+		return []*T{}, 0, nil
+	}
+
+	items, err := t.Select(ctx, db, q)
+	if err != nil {
+		return nil, 0, err
+	}
+	return items, total, nil
+}
+
+// Select returns every row matching q, without counting.
+func (t *Table[T]) Select(ctx context.Context, db DBTX, q ListQuery) ([]*T, error) {
+	rows, err := t.Query(ctx, db, q)
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := ScanRows(rows, t.Scan)
+	if err != nil {
+		return nil, fmt.Errorf("scan %s: %w", t.Name, err)
+	}
+	return items, nil
+}
+
+// Stream hands every row matching q to fn without buffering the result set.
+func (t *Table[T]) Stream(ctx context.Context, db DBTX, q ListQuery, fn func(*T) error) error {
+	rows, err := t.Query(ctx, db, q)
+	if err != nil {
+		return err
+	}
+
+	if err := StreamRows(rows, t.Scan, fn); err != nil {
+		return TranslateError(fmt.Errorf("stream %s: %w", t.Name, err))
+	}
+	return nil
+}
+
+func (t *Table[T]) ListByIDs(ctx context.Context, db DBTX, ids []int64) ([]*T, error) {
+	if len(ids) == 0 {
+		return []*T{}, nil
+	}
+
+	var q ListQuery
+	cond, args := In("id", ids)
+	q.And(cond, args...)
+	return t.Select(ctx, db, q)
+}
+
+// In returns the condition that column is one of ids, with its arguments.
+// ids must not be empty.
+func In(column string, ids []int64) (string, []any) {
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return column + " IN (?" + strings.Repeat(",?", len(ids)-1) + ")", args
+}
+
+// Query runs the SELECT described by q and leaves the rows to the caller.
+func (t *Table[T]) Query(ctx context.Context, db DBTX, q ListQuery) (*sql.Rows, error) {
+	var query strings.Builder
+	query.WriteString(fmt.Sprintf("SELECT %s FROM %s%s", t.columns(), t.Name, t.where(q.Where)))
+
+	args := q.Args
+	if q.OrderBy != "" {
+		query.WriteString(" ORDER BY " + q.OrderBy)
+	}
+	if q.Limit > 0 {
+		query.WriteString(" LIMIT ? OFFSET ?")
+		args = append(args[:len(args):len(args)], q.Limit, q.Offset)
+	}
+
+	rows, err := db.QueryContext(ctx, query.String(), args...)
+	if err != nil {
+		return nil, TranslateError(fmt.Errorf("list %s: %w", t.Name, err))
+	}
+	return rows, nil
+}
+
+// Schema describes the columns t reads, for comparison with a live schema.
+// Table does not know column types, so only names are checked.
+func (t *Table[T]) Schema() dbschema.Table {
+	table := dbschema.Table{Name: t.Name}
+	for _, column := range t.Columns {
+		table.Columns = append(table.Columns, dbschema.Column{Name: column})
+	}
+	return table
+}
+
+func (t *Table[T]) columns() string {
+	return strings.Join(t.Columns, ", ")
+}
+
+func (t *Table[T]) checkAffected(result sql.Result, id int64, op string) error {
+	rowsAffected, err := t.rowsAffected(result, op)
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return t.notFound(id)
+	}
+	return nil
+}
+
+func (t *Table[T]) rowsAffected(result sql.Result, op string) (int64, error) {
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("get rows affected for %s %s: %w", t.Entity, op, err)
+	}
+	return rowsAffected, nil
+}
+
+func (t *Table[T]) notFound(id int64) error {
+	return apperror.NotFound("%s with id %d not found", t.Entity, id)
+}
+
+// where joins conds into a WHERE clause, adding the soft delete scope. Each
+// condition is parenthesised so that an OR in one cannot escape the others.
+func (t *Table[T]) where(conds []string) string {
+	if t.SoftDelete {
+		conds = append(conds[:len(conds):len(conds)], "deleted_at IS NULL")
+	}
+	switch len(conds) {
+	case 0:
+		return ""
+	case 1:
+		return " WHERE " + conds[0]
+	}
+
+	var where strings.Builder
+	for i, cond := range conds {
+		if i > 0 {
+			where.WriteString(" AND ")
+		}
+		where.WriteString("(" + cond + ")")
+	}
+	return " WHERE " + where.String()
+}
+
+// deletionTime is the deleted_at value of a soft delete. It is truncated to
+// whole seconds, the precision of a MySQL TIMESTAMP, so the stored value
+// reads back unchanged.
+func deletionTime() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
@@ -0,0 +1,206 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"db_blueprints/apperror"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type note struct {
+	ID   int64
+	Text string
+}
+
+var noteTable = &Table[note]{
+	Name:       "notes",
+	Entity:     "note",
+	Columns:    []string{"id", "text"},
+	Writable:   []string{"text"},
+	SoftDelete: true,
+	Scan: func(row Scanner) (*note, error) {
+		var n note
+		if err := row.Scan(&n.ID, &n.Text); err != nil {
+			return nil, err
+		}
+		return &n, nil
+	},
+	Values: func(n *note) []any { return []any{n.Text} },
+	ID:     func(n *note) int64 { return n.ID },
+	SetID:  func(n *note, id int64) { n.ID = id },
+}
+
+// openNotes returns a DB with the notes a, b, c and d, ids 1 to 4.
+func openNotes(t *testing.T) *DB {
+	t.Helper()
+
+	sqlDB, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	if _, err := sqlDB.Exec("CREATE TABLE notes (id INTEGER PRIMARY KEY, text TEXT NOT NULL, deleted_at TIMESTAMP NULL)"); err != nil {
+		t.Fatal(err)
+	}
+
+	db := &DB{DB: sqlDB, dialect: SQLite}
+	for _, text := range []string{"a", "b", "c", "d"} {
+		if err := noteTable.Insert(context.Background(), db, &note{Text: text}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+// liveNotes returns the ids of the notes that are not deleted.
+func liveNotes(t *testing.T, db *DB) []int64 {
+	t.Helper()
+
+	notes, err := noteTable.Select(context.Background(), db, ListQuery{OrderBy: "id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []int64{}
+	for _, n := range notes {
+		ids = append(ids, n.ID)
+	}
+	return ids
+}
+
+func TestTableSoftDelete(t *testing.T) {
+	ctx := context.Background()
+	db := openNotes(t)
+
+	if err := noteTable.Delete(ctx, db, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := noteTable.Delete(ctx, db, 2); !errors.Is(err, apperror.ErrNotFound) {
+		t.Errorf("deleting a deleted note: error = %v, want not found", err)
+	}
+	if _, err := noteTable.Get(ctx, db, 2); !errors.Is(err, apperror.ErrNotFound) {
+		t.Errorf("Get() of a deleted note: error = %v, want not found", err)
+	}
+	if _, err := noteTable.Unscoped().Get(ctx, db, 2); err != nil {
+		t.Errorf("Unscoped().Get() of a deleted note failed: %v", err)
+	}
+
+	if err := noteTable.Restore(ctx, db, 2); err != nil {
+		t.Fatalf("Restore() failed: %v", err)
+	}
+	if err := noteTable.Restore(ctx, db, 2); !errors.Is(err, apperror.ErrNotFound) {
+		t.Errorf("restoring a live note: error = %v, want not found", err)
+	}
+	if got, want := liveNotes(t, db), []int64{1, 2, 3, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("live notes after restore = %v, want %v", got, want)
+	}
+}
+
+func TestTablePurge(t *testing.T) {
+	ctx := context.Background()
+	db := openNotes(t)
+
+	if _, err := noteTable.DeleteWhere(ctx, db, "id IN (?, ?)", 1, 2); err != nil {
+		t.Fatal(err)
+	}
+	// Backdate note 1 so that only it is old enough to be purged.
+	if _, err := db.ExecContext(ctx, "UPDATE notes SET deleted_at = ? WHERE id = 1", time.Now().UTC().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	purged, err := noteTable.Purge(ctx, db, time.Now().UTC().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 1 {
+		t.Errorf("Purge() removed %d rows, want 1", purged)
+	}
+
+	var ids []int64
+	rows, err := db.QueryContext(ctx, "SELECT id FROM notes ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if want := []int64{2, 3, 4}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("rows left after purge = %v, want %v", ids, want)
+	}
+}
+
+// An OR in a caller's condition must not widen the query beyond the soft
+// delete scope or the other conditions.
+func TestTableParenthesisesConditions(t *testing.T) {
+	ctx := context.Background()
+	db := openNotes(t)
+
+	if err := noteTable.Delete(ctx, db, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	exists, err := noteTable.Exists(ctx, db, "id = ? OR id = ?", 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Error("Exists() matched a deleted note")
+	}
+
+	var q ListQuery
+	q.And("text = ? OR text = ?", "a", "b")
+	q.And("id <> ?", 2)
+	notes, err := noteTable.Select(ctx, db, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != 0 {
+		t.Errorf("Select() = %+v, want no notes", notes)
+	}
+
+	if _, err := noteTable.DeleteWhere(ctx, db, "id = ? OR id = ?", 3, 4); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := noteTable.RestoreWhere(ctx, db, "id = ? OR id = ?", 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored != 1 {
+		t.Errorf("RestoreWhere() restored %d rows, want 1", restored)
+	}
+	if got, want := liveNotes(t, db), []int64{2, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("live notes = %v, want %v", got, want)
+	}
+}
+
+func TestTableInsertInBatches(t *testing.T) {
+	ctx := context.Background()
+	db := openNotes(t)
+
+	notes := []*note{{Text: "e"}, {Text: "f"}, {Text: "g"}, {Text: "h"}, {Text: "i"}}
+	if err := noteTable.InsertInBatches(ctx, db, notes, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, n := range notes {
+		if want := int64(5 + i); n.ID != want {
+			t.Errorf("note %q got id %d, want %d", n.Text, n.ID, want)
+		}
+		stored, err := noteTable.Get(ctx, db, n.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.Text != n.Text {
+			t.Errorf("row %d holds %q, want %q", n.ID, stored.Text, n.Text)
+		}
+	}
+}
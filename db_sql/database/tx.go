@@ -0,0 +1,163 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// TxFunc is the unit of work executed by WithTx. The ctx it receives carries
+// the active transaction, so nested WithTx calls join it instead of opening a
+// second one.
+type TxFunc func(ctx context.Context, tx DBTX) error
+
+// TxBeginner is implemented by *sql.DB and *DB.
+type TxBeginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+type TxOption func(*txOptions)
+
+type txOptions struct {
+	sqlOptions *sql.TxOptions
+	savepoint  bool
+}
+
+// WithTxOptions sets the isolation level / read-only flag used when a new
+// transaction is started. It is ignored when joining an outer transaction.
+func WithTxOptions(opts *sql.TxOptions) TxOption {
+	return func(o *txOptions) {
+		o.sqlOptions = opts
+	}
+}
+
+// WithSavepoint makes a nested WithTx call run inside a SAVEPOINT, so its
+// failure only rolls back its own work instead of the whole transaction.
+func WithSavepoint() TxOption {
+	return func(o *txOptions) {
+		o.savepoint = true
+	}
+}
+
+type txKey struct{}
+
+type txState struct {
+	tx    *Tx
+	depth int
+}
+
+// TxFromContext returns the transaction started by an enclosing WithTx call.
+func TxFromContext(ctx context.Context) (*Tx, bool) {
+	state, ok := ctx.Value(txKey{}).(*txState)
+	if !ok {
+		return nil, false
+	}
+	return state.tx, true
+}
+
+// WithTx runs fn inside a transaction. The transaction is committed when fn
+// returns nil and rolled back when fn returns an error or panics; a panic is
+// re-raised after the rollback.
+//
+// If ctx already carries a transaction, or db itself is a *Tx, fn joins that
+// transaction. Pass WithSavepoint to isolate the nested unit of work. A bare
+// *sql.Tx is rejected, since nothing tells which dialect it speaks.
+func WithTx(ctx context.Context, db DBTX, fn TxFunc, opts ...TxOption) error {
+	var o txOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	state, ok := ctx.Value(txKey{}).(*txState)
+	if !ok {
+		tx, isTx, err := asTx(db)
+		if err != nil {
+			return err
+		}
+		if isTx {
+			state = &txState{tx: tx}
+			ctx = context.WithValue(ctx, txKey{}, state)
+			ok = true
+		}
+	}
+
+	if ok {
+		if !o.savepoint {
+			return fn(ctx, state.tx)
+		}
+		return withSavepoint(ctx, state, fn)
+	}
+
+	beginner, isBeginner := db.(TxBeginner)
+	if !isBeginner {
+		return fmt.Errorf("begin transaction: %T cannot start a transaction", db)
+	}
+
+	tx, err := beginner.BeginTx(ctx, o.sqlOptions)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+
+	dialectTx := &Tx{Tx: tx, dialect: DialectOf(db), observe: observerOf(db)}
+	return runTx(
+		func() error { return fn(context.WithValue(ctx, txKey{}, &txState{tx: dialectTx}), dialectTx) },
+		tx.Commit,
+		tx.Rollback,
+	)
+}
+
+func asTx(db DBTX) (*Tx, bool, error) {
+	switch tx := db.(type) {
+	case *Tx:
+		return tx, true, nil
+	case *sql.Tx:
+		return nil, false, errors.New("join transaction: a *sql.Tx has no dialect, pass the *Tx handed out by WithTx")
+	default:
+		return nil, false, nil
+	}
+}
+
+func withSavepoint(ctx context.Context, state *txState, fn TxFunc) error {
+	state.depth++
+	defer func() { state.depth-- }()
+
+	name := fmt.Sprintf("sp_%d", state.depth)
+	if _, err := state.tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return fmt.Errorf("create savepoint %s: %w", name, err)
+	}
+
+	return runTx(
+		func() error { return fn(ctx, state.tx) },
+		func() error {
+			_, err := state.tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name)
+			return err
+		},
+		func() error {
+			_, err := state.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name)
+			return err
+		},
+	)
+}
+
+func runTx(fn, commit, rollback func() error) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			_ = rollback()
+			panic(p)
+		}
+	}()
+
+	if err = fn(); err != nil {
+		if rbErr := rollback(); rbErr != nil {
+			return errors.Join(err, fmt.Errorf("rollback transaction: %w", rbErr))
+		}
+		return err
+	}
+
+	if err = commit(); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+
+	return nil
+}
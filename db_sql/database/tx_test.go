@@ -0,0 +1,150 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func openTestDB(t *testing.T, dialect Dialect) *DB {
+	t.Helper()
+
+	sqlDB, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	if _, err := sqlDB.Exec("CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT NOT NULL)"); err != nil {
+		t.Fatal(err)
+	}
+	return &DB{DB: sqlDB, dialect: dialect}
+}
+
+func countItems(t *testing.T, db *DB) int {
+	t.Helper()
+
+	var n int
+	if err := db.QueryRowContext(context.Background(), "SELECT COUNT(*) FROM items").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestWithTx(t *testing.T) {
+	errFail := errors.New("fail")
+
+	tests := []struct {
+		name  string
+		fn    func(ctx context.Context, tx DBTX) error
+		err   error
+		items int
+	}{
+		{
+			name: "commits",
+			fn: func(ctx context.Context, tx DBTX) error {
+				_, err := tx.ExecContext(ctx, "INSERT INTO items (name) VALUES (?)", "a")
+				return err
+			},
+			items: 1,
+		},
+		{
+			name: "rolls back on error",
+			fn: func(ctx context.Context, tx DBTX) error {
+				if _, err := tx.ExecContext(ctx, "INSERT INTO items (name) VALUES (?)", "a"); err != nil {
+					return err
+				}
+				return errFail
+			},
+			err: errFail,
+		},
+		{
+			name: "nested call joins the transaction",
+			fn: func(ctx context.Context, tx DBTX) error {
+				if _, err := tx.ExecContext(ctx, "INSERT INTO items (name) VALUES (?)", "a"); err != nil {
+					return err
+				}
+				err := WithTx(ctx, tx, func(ctx context.Context, tx DBTX) error {
+					_, err := tx.ExecContext(ctx, "INSERT INTO items (name) VALUES (?)", "b")
+					return err
+				})
+				if err != nil {
+					return err
+				}
+				return errFail
+			},
+			err: errFail,
+		},
+		{
+			name: "savepoint rolls back only its own work",
+			fn: func(ctx context.Context, tx DBTX) error {
+				if _, err := tx.ExecContext(ctx, "INSERT INTO items (name) VALUES (?)", "a"); err != nil {
+					return err
+				}
+				err := WithTx(ctx, tx, func(ctx context.Context, tx DBTX) error {
+					if _, err := tx.ExecContext(ctx, "INSERT INTO items (name) VALUES (?)", "b"); err != nil {
+						return err
+					}
+					return errFail
+				}, WithSavepoint())
+				if !errors.Is(err, errFail) {
+					t.Errorf("savepoint error = %v, want %v", err, errFail)
+				}
+				return nil
+			},
+			items: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := openTestDB(t, SQLite)
+			if err := WithTx(context.Background(), db, tt.fn); !errors.Is(err, tt.err) {
+				t.Fatalf("WithTx() error = %v, want %v", err, tt.err)
+			}
+			if n := countItems(t, db); n != tt.items {
+				t.Errorf("%d items stored, want %d", n, tt.items)
+			}
+		})
+	}
+}
+
+func TestWithTxKeepsDialect(t *testing.T) {
+	for _, dialect := range []Dialect{MySQL, Postgres, SQLite} {
+		db := openTestDB(t, dialect)
+		err := WithTx(context.Background(), db, func(ctx context.Context, tx DBTX) error {
+			if got := DialectOf(tx); got != dialect {
+				t.Errorf("transaction of a %s DB has dialect %s", dialect, got)
+			}
+			return WithTx(ctx, tx, func(ctx context.Context, tx DBTX) error {
+				if got := DialectOf(tx); got != dialect {
+					t.Errorf("nested transaction of a %s DB has dialect %s", dialect, got)
+				}
+				return nil
+			})
+		})
+		if err != nil {
+			t.Fatalf("WithTx() failed: %v", err)
+		}
+	}
+}
+
+func TestWithTxRejectsBareTx(t *testing.T) {
+	db := openTestDB(t, Postgres)
+	sqlTx, err := db.DB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlTx.Rollback()
+
+	called := false
+	err = WithTx(context.Background(), sqlTx, func(ctx context.Context, tx DBTX) error {
+		called = true
+		return nil
+	})
+	if err == nil || called {
+		t.Errorf("WithTx() on a *sql.Tx = %v and ran fn: %v, want an error", err, called)
+	}
+}
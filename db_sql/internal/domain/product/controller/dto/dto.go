@@ -3,6 +3,7 @@ package dto
 import (
 	"db_blueprints/db_sql/internal/domain/user/controller/dto"
 	"db_blueprints/db_sql/pkgs/paging"
+	"db_blueprints/filter"
 )
 
 type Product struct {
@@ -13,15 +14,52 @@ type Product struct {
 	Owner     *dto.User `json:"user,omitempty"`
 	CreatedAt string    `json:"created_at"`
 	UpdatedAt string    `json:"updated_at"`
+	DeletedAt *string   `json:"deleted_at,omitempty"`
+	Version   int64     `json:"version"`
+}
+
+// ProductFilters are the fields products can be filtered by, e.g.
+// price[gte]=10&owner_id[in]=1,2.
+var ProductFilters = filter.Schema{
+	"id":         {Column: "id", Kind: filter.Int},
+	"name":       {Column: "name", Kind: filter.String},
+	"price":      {Column: "price", Kind: filter.Float},
+	"owner_id":   {Column: "owner_id", Kind: filter.Int},
+	"created_at": {Column: "created_at", Kind: filter.Time},
+	"updated_at": {Column: "updated_at", Kind: filter.Time},
+}
+
+// GetProductRequest holds the query parameters of a single product lookup.
+type GetProductRequest struct {
+	// IncludeDeleted finds a soft-deleted product too. Admins only.
+	IncludeDeleted bool `form:"include_deleted"`
 }
 
 type ListProductRequest struct {
-	Search    string `json:"search,omitempty" form:"search"`
-	Page      int64  `json:"-" form:"page"`
-	Limit     int64  `json:"-" form:"size"`
+	Search    string `json:"search,omitempty" form:"search" binding:"max=255"`
+	Page      int64  `json:"-" form:"page" binding:"omitempty,min=1"`
+	Limit     int64  `json:"-" form:"size" binding:"omitempty,min=1,max=1000"`
+	OrderBy   string `json:"-" form:"order_by"`
+	OrderDesc bool   `json:"-" form:"order_desc"`
+	// Cursor switches listing to keyset pagination. Send it empty to get the
+	// first page, then pass back next_cursor or prev_cursor from the metadata.
+	Cursor *string `json:"-" form:"cursor"`
+	// IncludeDeleted lists soft-deleted rows too. Admins only.
+	IncludeDeleted bool `json:"-" form:"include_deleted"`
+	// Filter is parsed from the field[op]=value parameters by the handler.
+	Filter *filter.Filter `json:"-" form:"-"`
+}
+
+// ExportProductRequest filters and orders an export. It takes the same
+// parameters as listing, without paging.
+type ExportProductRequest struct {
+	Search    string `json:"search,omitempty" form:"search" binding:"max=255"`
 	OrderBy   string `json:"-" form:"order_by"`
 	OrderDesc bool   `json:"-" form:"order_desc"`
-	TakeAll   bool   `json:"-" form:"take_all"`
+	// IncludeDeleted lists soft-deleted rows too. Admins only.
+	IncludeDeleted bool `json:"-" form:"include_deleted"`
+	// Filter is parsed from the field[op]=value parameters by the handler.
+	Filter *filter.Filter `json:"-" form:"-"`
 }
 
 type ListProductResponse struct {
@@ -30,9 +68,9 @@ type ListProductResponse struct {
 }
 
 type CreateProductRequest struct {
-	OwnerID int64   `json:"owner_id"`
-	Name    string  `json:"name"`
-	Price   float64 `json:"price"`
+	OwnerID int64   `json:"owner_id" binding:"required,owner_exists"`
+	Name    string  `json:"name" binding:"required,max=255"`
+	Price   float64 `json:"price" binding:"required,gt=0"`
 }
 
 type CreateProductResponse struct {
@@ -41,11 +79,44 @@ type CreateProductResponse struct {
 
 type UpdateProductRequest struct {
 	ID    int64    `json:"id"`
-	Owner *int64   `json:"owner_id"`
-	Name  *string  `json:"name"`
-	Price *float64 `json:"price"`
+	Owner *int64   `json:"owner_id" binding:"omitnil,owner_exists"`
+	Name  *string  `json:"name" binding:"omitnil,min=1,max=255"`
+	Price *float64 `json:"price" binding:"omitnil,gt=0"`
+	// Version is the version the change is based on. The If-Match header
+	// takes precedence over it.
+	Version *int64 `json:"version" binding:"omitnil,min=1"`
 }
 
 type UpdateProductResponse struct {
 	Product *Product `json:"product"`
 }
+
+// PatchProductRequest holds the members of a JSON merge patch. Absent members
+// stay nil.
+type PatchProductRequest struct {
+	OwnerID *int64   `json:"owner_id" binding:"omitnil,owner_exists"`
+	Name    *string  `json:"name" binding:"omitnil,min=1,max=255"`
+	Price   *float64 `json:"price" binding:"omitnil,gt=0"`
+	// Version comes from the If-Match header.
+	Version *int64 `json:"-"`
+}
+
+// BatchUpdateProductItem is an item of a bulk update. Like a merge patch, it
+// writes only the fields it sets.
+type BatchUpdateProductItem struct {
+	ID      int64    `json:"id" binding:"required,min=1"`
+	OwnerID *int64   `json:"owner_id" binding:"omitnil,owner_exists"`
+	Name    *string  `json:"name" binding:"omitnil,min=1,max=255"`
+	Price   *float64 `json:"price" binding:"omitnil,gt=0"`
+	// Version is the version the change is based on.
+	Version *int64 `json:"version" binding:"omitnil,min=1"`
+}
+
+// ProductPatchFields are the members a product merge patch may set.
+var ProductPatchFields = []string{"owner_id", "name", "price"}
+
+type PatchProductResponse struct {
+	Product *Product `json:"product"`
+	// Changed lists the fields whose value the patch changed.
+	Changed []string `json:"changed"`
+}
@@ -1,17 +1,25 @@
 package http
 
 import (
+	"db_blueprints/apperror"
+	"db_blueprints/filter"
+	"db_blueprints/mergepatch"
 	"log"
 	"net/http"
 	"strconv"
 
+	"db_blueprints/batch"
 	"db_blueprints/db_sql/internal/domain/product/controller/dto"
 	"db_blueprints/db_sql/internal/domain/product/service"
 	"db_blueprints/db_sql/internal/model"
-	"db_blueprints/db_sql/pkgs/response"
 	"db_blueprints/db_sql/utils"
+	"db_blueprints/etag"
+	"db_blueprints/export"
+	"db_blueprints/middleware"
+	"db_blueprints/response"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 )
 
 type ProductHandler struct {
@@ -30,6 +38,17 @@ func (h *ProductHandler) GetProducts(c *gin.Context) {
 		return
 	}
 
+	filters, err := filter.Parse(c.Request.URL.Query(), dto.ProductFilters)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err, "Invalid filter")
+		return
+	}
+	req.Filter = filters
+
+	if !allowIncludeDeleted(c, req.IncludeDeleted) {
+		return
+	}
+
 	products, pagination, err := h.service.ListProducts(c, &req)
 	if err != nil {
 		log.Printf("Failed to get products: %v", err)
@@ -44,6 +63,71 @@ func (h *ProductHandler) GetProducts(c *gin.Context) {
 	response.JSON(c, http.StatusOK, res)
 }
 
+// productExportColumns are the CSV columns of an product export.
+var productExportColumns = []export.Column[dto.Product]{
+	{Header: "id", Value: func(p *dto.Product) string { return strconv.FormatInt(p.ID, 10) }},
+	{Header: "name", Value: func(p *dto.Product) string { return p.Name }},
+	{Header: "price", Value: func(p *dto.Product) string { return strconv.FormatFloat(p.Price, 'f', -1, 64) }},
+	{Header: "owner_id", Value: func(p *dto.Product) string { return strconv.FormatInt(p.OwnerID, 10) }},
+	{Header: "created_at", Value: func(p *dto.Product) string { return p.CreatedAt }},
+	{Header: "updated_at", Value: func(p *dto.Product) string { return p.UpdatedAt }},
+}
+
+// ExportProducts streams every matching product as NDJSON or CSV, picked from the
+// Accept header. Rows are written as they are read from the database.
+func (h *ProductHandler) ExportProducts(c *gin.Context) {
+	var req dto.ExportProductRequest
+	if err := c.ShouldBind(&req); err != nil {
+		log.Printf("Failed to bind query parameters: %v", err)
+		response.Error(c, http.StatusBadRequest, err, "Invalid parameters")
+		return
+	}
+
+	filters, err := filter.Parse(c.Request.URL.Query(), dto.ProductFilters)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err, "Invalid filter")
+		return
+	}
+	req.Filter = filters
+
+	if !allowIncludeDeleted(c, req.IncludeDeleted) {
+		return
+	}
+
+	format := c.NegotiateFormat(export.Formats...)
+	if format == "" {
+		response.Error(c, http.StatusNotAcceptable, nil, "Export is available as application/x-ndjson or text/csv")
+		return
+	}
+
+	if err := export.LiftWriteDeadline(c.Writer); err != nil {
+		log.Printf("Failed to lift the write deadline of the export: %v", err)
+	}
+
+	c.Header("Content-Type", format)
+	c.Header("Content-Disposition", export.Disposition("products", format))
+
+	w := export.NewWriter(format, c.Writer, productExportColumns)
+	err = h.service.ExportProducts(c, &req, func(p *model.Product) error {
+		var item dto.Product
+		utils.MapStruct(&item, p)
+		return w.Write(&item)
+	})
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		log.Printf("Failed to export products: %v", err)
+		// Once rows have been sent the status is committed and the client
+		// only sees a truncated body.
+		if !c.Writer.Written() {
+			c.Writer.Header().Del("Content-Type")
+			c.Writer.Header().Del("Content-Disposition")
+			response.Error(c, http.StatusInternalServerError, err, "Failed to export products")
+		}
+	}
+}
+
 func (h *ProductHandler) GetProduct(c *gin.Context) {
 	var res model.Product
 
@@ -54,12 +138,26 @@ func (h *ProductHandler) GetProduct(c *gin.Context) {
 		return
 	}
 
-	product, err := h.service.GetByID(c, productId)
+	var req dto.GetProductRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, err, "Invalid parameters")
+		return
+	}
+	if !allowIncludeDeleted(c, req.IncludeDeleted) {
+		return
+	}
+
+	product, err := h.service.GetByID(c, productId, req.IncludeDeleted)
 	if err != nil {
 		response.Error(c, http.StatusInternalServerError, err, "Failed to get product")
 		return
 	}
 
+	if etag.NotModified(c, product.Version) {
+		return
+	}
+	etag.Set(c, product.Version)
+
 	utils.MapStruct(&res, product)
 	response.JSON(c, http.StatusOK, res)
 }
@@ -85,6 +183,40 @@ func (h *ProductHandler) CreateProduct(c *gin.Context) {
 	response.JSON(c, http.StatusCreated, res)
 }
 
+// BatchCreateProducts creates many products in one transaction and reports
+// the created ID or the validation error of each item.
+func (h *ProductHandler) BatchCreateProducts(c *gin.Context) {
+	var req batch.CreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, err, "Invalid request body")
+		return
+	}
+
+	results := batch.NewResults(len(req.Items))
+	items := batch.Decode[dto.CreateProductRequest](req.Items, results)
+	if results.Abort(req.Mode) || len(items) == 0 {
+		results.Respond(c, http.StatusCreated)
+		return
+	}
+
+	reqs := make([]*dto.CreateProductRequest, 0, len(items))
+	for _, item := range items {
+		reqs = append(reqs, item.Value)
+	}
+
+	products, err := h.service.CreateProducts(c, reqs)
+	if err != nil {
+		log.Printf("Failed to create products: %v", err)
+		response.Error(c, http.StatusInternalServerError, err, "Failed to create products")
+		return
+	}
+
+	for i, product := range products {
+		results.Succeed(items[i].Index, batch.Created, product.ID)
+	}
+	results.Respond(c, http.StatusCreated)
+}
+
 func (h *ProductHandler) UpdateProduct(c *gin.Context) {
 	var req dto.UpdateProductRequest
 
@@ -100,8 +232,20 @@ func (h *ProductHandler) UpdateProduct(c *gin.Context) {
 		return
 	}
 
+	version, ok, err := etag.IfMatch(c)
+	if err != nil {
+		response.Error(c, http.StatusPreconditionFailed, err, "Invalid If-Match header")
+		return
+	}
+	if ok {
+		req.Version = &version
+	}
+
 	product, err := h.service.UpdateProduct(c, productId, &req)
 	if err != nil {
+		if ok {
+			err = etag.Precondition(err)
+		}
 		log.Printf("Failed to update product: %v", err)
 		response.Error(c, http.StatusInternalServerError, err, "Failed to update product")
 		return
@@ -109,10 +253,119 @@ func (h *ProductHandler) UpdateProduct(c *gin.Context) {
 
 	var res dto.UpdateProductResponse
 	utils.MapStruct(&res.Product, product)
+	etag.Set(c, product.Version)
+
+	response.JSON(c, http.StatusOK, res)
+}
+
+// PatchProduct applies a JSON merge patch (RFC 7396) to a product. Only the
+// fields the patch changes are written, and the response lists them.
+func (h *ProductHandler) PatchProduct(c *gin.Context) {
+	productId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err, "Invalid product ID")
+		return
+	}
+
+	if contentType := c.ContentType(); contentType != mergepatch.ContentType && contentType != binding.MIMEJSON {
+		response.Error(c, http.StatusUnsupportedMediaType, nil, "Patch must be sent as "+mergepatch.ContentType)
+		return
+	}
+
+	body, err := c.GetRawData()
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err, "Invalid request body")
+		return
+	}
+
+	var req dto.PatchProductRequest
+	patch, err := mergepatch.Parse(body)
+	if err == nil {
+		err = patch.Check(dto.ProductPatchFields...)
+	}
+	if err == nil {
+		err = patch.Decode(&req)
+	}
+	if err == nil {
+		err = binding.Validator.ValidateStruct(&req)
+	}
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err, "Invalid patch")
+		return
+	}
+
+	version, ok, err := etag.IfMatch(c)
+	if err != nil {
+		response.Error(c, http.StatusPreconditionFailed, err, "Invalid If-Match header")
+		return
+	}
+	if ok {
+		req.Version = &version
+	}
+
+	product, changed, err := h.service.PatchProduct(c, productId, &req)
+	if err != nil {
+		if ok {
+			err = etag.Precondition(err)
+		}
+		log.Println("Failed to patch product", err)
+		response.Error(c, http.StatusInternalServerError, err, "Failed to patch product")
+		return
+	}
+
+	res := dto.PatchProductResponse{Changed: changed}
+	utils.MapStruct(&res.Product, product)
+	etag.Set(c, product.Version)
 
 	response.JSON(c, http.StatusOK, res)
 }
 
+// BatchUpdateProducts updates many products in one transaction and reports
+// the new version or the error of each item.
+func (h *ProductHandler) BatchUpdateProducts(c *gin.Context) {
+	var req batch.UpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, err, "Invalid request body")
+		return
+	}
+
+	results := batch.NewResults(len(req.Items))
+	items := batch.Decode[dto.BatchUpdateProductItem](req.Items, results)
+	if results.Abort(req.Mode) || len(items) == 0 {
+		results.Respond(c, http.StatusOK)
+		return
+	}
+
+	reqs := make([]*dto.BatchUpdateProductItem, 0, len(items))
+	for _, item := range items {
+		reqs = append(reqs, item.Value)
+	}
+
+	products, errs, err := h.service.UpdateProducts(c, reqs, req.Mode != batch.BestEffort)
+	if err != nil {
+		log.Printf("Failed to update products: %v", err)
+		response.Error(c, http.StatusInternalServerError, err, "Failed to update products")
+		return
+	}
+
+	for i, err := range errs {
+		if err != nil {
+			results.Fail(items[i].Index, err, "Failed to update product")
+		}
+	}
+	if results.Abort(req.Mode) {
+		results.Respond(c, http.StatusOK)
+		return
+	}
+
+	for i, product := range products {
+		if errs[i] == nil {
+			results.SucceedUpdate(items[i].Index, product.ID, product.Version)
+		}
+	}
+	results.Respond(c, http.StatusOK)
+}
+
 func (h *ProductHandler) DeleteProduct(c *gin.Context) {
 	productId, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
@@ -124,9 +377,59 @@ func (h *ProductHandler) DeleteProduct(c *gin.Context) {
 	err = h.service.DeleteProduct(c, productId)
 	if err != nil {
 		log.Printf("Failed to delete product: %v", err)
-		response.Error(c, http.StatusNotFound, err, err.Error())
+		response.Error(c, http.StatusInternalServerError, err, "Failed to delete product")
 		return
 	}
 
 	response.JSON(c, http.StatusOK, gin.H{"message": "Delete product successfully"})
 }
+
+// BatchDeleteProducts soft-deletes the products with the given ids in one
+// transaction and reports on each id.
+func (h *ProductHandler) BatchDeleteProducts(c *gin.Context) {
+	var req batch.DeleteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, err, "Invalid request body")
+		return
+	}
+
+	missing, err := h.service.DeleteProducts(c, req.IDs, req.Mode != batch.BestEffort)
+	if err != nil {
+		log.Printf("Failed to delete products: %v", err)
+		response.Error(c, http.StatusInternalServerError, err, "Failed to delete products")
+		return
+	}
+
+	batch.DeleteResults(req.IDs, missing, req.Mode, "product").Respond(c, http.StatusOK)
+}
+
+// RestoreProduct undoes the soft delete of a product.
+func (h *ProductHandler) RestoreProduct(c *gin.Context) {
+	productId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		log.Printf("Failed to parse product ID from path: %v", err)
+		response.Error(c, http.StatusBadRequest, err, "Invalid product ID")
+		return
+	}
+
+	product, err := h.service.RestoreProduct(c, productId)
+	if err != nil {
+		log.Printf("Failed to restore product: %v", err)
+		response.Error(c, http.StatusInternalServerError, err, "Failed to restore product")
+		return
+	}
+
+	var res model.Product
+	utils.MapStruct(&res, product)
+	response.JSON(c, http.StatusOK, res)
+}
+
+// allowIncludeDeleted rejects include_deleted from callers without the admin
+// token and reports whether the request may go on.
+func allowIncludeDeleted(c *gin.Context, includeDeleted bool) bool {
+	if includeDeleted && !middleware.IsAdmin(c) {
+		response.Error(c, http.StatusForbidden, apperror.Forbidden("include_deleted requires an admin token"), "Forbidden")
+		return false
+	}
+	return true
+}
@@ -2,20 +2,38 @@ package repository
 
 import (
 	"context"
-	"database/sql"
+	"db_blueprints/apperror"
+	"db_blueprints/cursor"
 	"db_blueprints/db_sql/database"
 	"db_blueprints/db_sql/internal/domain/product/controller/dto"
 	"db_blueprints/db_sql/internal/model"
+	"db_blueprints/db_sql/pkgs/paging"
+	"db_blueprints/dbschema"
+	"db_blueprints/filter"
+	"db_blueprints/metrics"
 	"fmt"
-	"strings"
+	"time"
 )
 
 type IProductRepository interface {
 	GetByID(ctx context.Context, id int64) (*model.Product, error)
+	GetByIDUnscoped(ctx context.Context, id int64) (*model.Product, error)
 	Create(ctx context.Context, product *model.Product) (*model.Product, error)
+	CreateInBatches(ctx context.Context, products []*model.Product, size int) error
 	Update(ctx context.Context, product *model.Product) (*model.Product, error)
+	UpdateColumns(ctx context.Context, product *model.Product, columns ...string) (*model.Product, error)
 	Delete(ctx context.Context, id int64) error
+	DeleteByIDs(ctx context.Context, ids []int64) (int64, error)
+	DeleteByOwner(ctx context.Context, ownerID int64) (int64, error)
+	DeleteByOwners(ctx context.Context, ownerIDs []int64) (int64, error)
+	Restore(ctx context.Context, id int64) error
+	RestoreByOwner(ctx context.Context, ownerID int64, since time.Time) (int64, error)
+	Purge(ctx context.Context, before time.Time) (int64, error)
 	List(ctx context.Context, req *dto.ListProductRequest) ([]*model.Product, int64, error)
+	ListByCursor(ctx context.Context, req *dto.ListProductRequest) ([]*model.Product, *paging.Pagination, error)
+	Export(ctx context.Context, req *dto.ExportProductRequest, fn func(*model.Product) error) error
+	ExistingIDs(ctx context.Context, ids []int64) ([]int64, error)
+	WithTx(tx database.DBTX) IProductRepository
 }
 
 type ProductRepository struct {
@@ -26,134 +44,263 @@ func NewProductRepository(db database.DBTX) IProductRepository {
 	return &ProductRepository{db: db}
 }
 
+// WithTx returns a copy of the repository bound to tx, typically the one
+// handed to a database.WithTx callback.
+func (r *ProductRepository) WithTx(tx database.DBTX) IProductRepository {
+	return &ProductRepository{db: tx}
+}
+
 func (r *ProductRepository) GetByID(ctx context.Context, id int64) (*model.Product, error) {
-	query := "SELECT id, name, price, owner_id, created_at, updated_at FROM products WHERE id = ?"
-	row := r.db.QueryRowContext(ctx, query, id)
-	var p model.Product
-	err := row.Scan(&p.ID, &p.Name, &p.Price, &p.OwnerID, &p.CreatedAt, &p.UpdatedAt)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil
-		}
-		return nil, fmt.Errorf("get product by id: %w", err)
-	}
-	return &p, nil
+	ctx = metrics.WithOperation(ctx, "ProductRepository.GetByID")
+	return productTable.Get(ctx, r.db, id)
+}
+
+// GetByIDUnscoped returns the product even when it is soft-deleted.
+func (r *ProductRepository) GetByIDUnscoped(ctx context.Context, id int64) (*model.Product, error) {
+	ctx = metrics.WithOperation(ctx, "ProductRepository.GetByIDUnscoped")
+	return productTable.Unscoped().Get(ctx, r.db, id)
 }
 
 func (r *ProductRepository) Create(ctx context.Context, product *model.Product) (*model.Product, error) {
-	query := "INSERT INTO products (name, price, owner_id, created_at, updated_at) VALUES (?, ?, ?, NOW(), NOW())"
-	result, err := r.db.ExecContext(ctx, query, product.Name, product.Price, product.OwnerID)
-	if err != nil {
-		return nil, fmt.Errorf("create product: %w", err)
-	}
-	id, err := result.LastInsertId()
-	if err != nil {
-		return nil, fmt.Errorf("get last insert id for product: %w", err)
+	ctx = metrics.WithOperation(ctx, "ProductRepository.Create")
+	if err := productTable.Insert(ctx, r.db, product); err != nil {
+		return nil, err
 	}
-	product.ID = id
 	return product, nil
 }
 
+// CreateInBatches inserts the products with multi-row INSERTs of up to size
+// rows and sets their IDs.
+func (r *ProductRepository) CreateInBatches(ctx context.Context, products []*model.Product, size int) error {
+	ctx = metrics.WithOperation(ctx, "ProductRepository.CreateInBatches")
+	return productTable.InsertInBatches(ctx, r.db, products, size)
+}
+
 func (r *ProductRepository) Update(ctx context.Context, product *model.Product) (*model.Product, error) {
-	query := "UPDATE products SET name = ?, price = ?, updated_at = NOW() WHERE id = ?"
-	result, err := r.db.ExecContext(ctx, query, product.Name, product.Price, product.ID)
+	ctx = metrics.WithOperation(ctx, "ProductRepository.Update")
+	if err := productTable.Update(ctx, r.db, product); err != nil {
+		return nil, err
+	}
+	return product, nil
+}
 
-	if err != nil {
-		return nil, fmt.Errorf("update product: %w", err)
+// UpdateColumns writes only the named columns of the product.
+func (r *ProductRepository) UpdateColumns(ctx context.Context, product *model.Product, columns ...string) (*model.Product, error) {
+	ctx = metrics.WithOperation(ctx, "ProductRepository.UpdateColumns")
+	if err := productTable.UpdateColumns(ctx, r.db, product, columns...); err != nil {
+		return nil, err
 	}
+	return product, nil
+}
 
-	rowsAffected, err := result.RowsAffected()
+func (r *ProductRepository) Delete(ctx context.Context, id int64) error {
+	ctx = metrics.WithOperation(ctx, "ProductRepository.Delete")
+	return productTable.Delete(ctx, r.db, id)
+}
+
+// DeleteByIDs soft-deletes the live products among ids.
+func (r *ProductRepository) DeleteByIDs(ctx context.Context, ids []int64) (int64, error) {
+	ctx = metrics.WithOperation(ctx, "ProductRepository.DeleteByIDs")
+	cond, args := database.In("id", ids)
+	return productTable.DeleteWhere(ctx, r.db, cond, args...)
+}
+
+// DeleteByOwner soft-deletes the live products of an owner.
+func (r *ProductRepository) DeleteByOwner(ctx context.Context, ownerID int64) (int64, error) {
+	ctx = metrics.WithOperation(ctx, "ProductRepository.DeleteByOwner")
+	return productTable.DeleteWhere(ctx, r.db, "owner_id = ?", ownerID)
+}
+
+// DeleteByOwners soft-deletes the live products of several owners.
+func (r *ProductRepository) DeleteByOwners(ctx context.Context, ownerIDs []int64) (int64, error) {
+	ctx = metrics.WithOperation(ctx, "ProductRepository.DeleteByOwners")
+	cond, args := database.In("owner_id", ownerIDs)
+	return productTable.DeleteWhere(ctx, r.db, cond, args...)
+}
+
+func (r *ProductRepository) Restore(ctx context.Context, id int64) error {
+	ctx = metrics.WithOperation(ctx, "ProductRepository.Restore")
+	return productTable.Restore(ctx, r.db, id)
+}
+
+// RestoreByOwner restores the products of an owner that were deleted at or
+// after since, i.e. together with the owner rather than before it.
+func (r *ProductRepository) RestoreByOwner(ctx context.Context, ownerID int64, since time.Time) (int64, error) {
+	ctx = metrics.WithOperation(ctx, "ProductRepository.RestoreByOwner")
+	return productTable.RestoreWhere(ctx, r.db, "owner_id = ? AND deleted_at >= ?", ownerID, since)
+}
+
+// Purge removes products soft-deleted before the given time for good.
+func (r *ProductRepository) Purge(ctx context.Context, before time.Time) (int64, error) {
+	ctx = metrics.WithOperation(ctx, "ProductRepository.Purge")
+	return productTable.Purge(ctx, r.db, before)
+}
+
+func (r *ProductRepository) List(ctx context.Context, req *dto.ListProductRequest) ([]*model.Product, int64, error) {
+	ctx = metrics.WithOperation(ctx, "ProductRepository.List")
+	key, err := productSortKey(req.OrderBy)
 	if err != nil {
-		return nil, fmt.Errorf("get rows affected for product update: %w", err)
+		return nil, 0, err
 	}
 
-	if rowsAffected == 0 {
-		return nil, sql.ErrNoRows
-	}
+	q := productQuery(req.Search, req.Filter)
+	q.OrderBy = key.Column + direction(req.OrderDesc)
+	q.Limit = req.Limit
+	q.Offset = (req.Page - 1) * req.Limit
 
-	return product, nil
+	return productTableFor(req.IncludeDeleted).List(ctx, r.db, q)
 }
 
-func (r *ProductRepository) Delete(ctx context.Context, id int64) error {
-	query := "DELETE FROM products WHERE id = ?"
-	result, err := r.db.ExecContext(ctx, query, id)
+// ListByCursor lists products with keyset pagination. It never runs a COUNT
+// query, so the pagination it returns only carries the cursors.
+func (r *ProductRepository) ListByCursor(ctx context.Context, req *dto.ListProductRequest) ([]*model.Product, *paging.Pagination, error) {
+	ctx = metrics.WithOperation(ctx, "ProductRepository.ListByCursor")
+	key, err := productSortKey(req.OrderBy)
 	if err != nil {
-		return fmt.Errorf("delete product: %w", err)
+		return nil, nil, err
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	keyset, err := cursor.NewKeyset(key, req.OrderDesc, *req.Cursor, paging.PageSize(req.Limit), productID)
 	if err != nil {
-		return fmt.Errorf("get rows affected for product delete: %w", err)
+		return nil, nil, err
 	}
 
-	if rowsAffected == 0 {
-		return sql.ErrNoRows
+	q := productQuery(req.Search, req.Filter)
+	where, args, err := keyset.Where()
+	if err != nil {
+		return nil, nil, err
 	}
-	return nil
-}
-
-func (r *ProductRepository) List(ctx context.Context, req *dto.ListProductRequest) ([]*model.Product, int64, error) {
-	var total int64
-	countQueryBuilder := strings.Builder{}
-	countQueryBuilder.WriteString("SELECT COUNT(id) FROM products WHERE 1=1")
-	args := []interface{}{}
-
-	if req.Search != "" {
-		countQueryBuilder.WriteString(" AND name LIKE ?")
-		searchPattern := "%" + req.Search + "%"
-		args = append(args, searchPattern)
+	if where != "" {
+		q.And(where, args...)
 	}
-	err := r.db.QueryRowContext(ctx, countQueryBuilder.String(), args...).Scan(&total)
+	q.OrderBy = keyset.OrderBy()
+	q.Limit = keyset.Limit()
+
+	products, err := productTableFor(req.IncludeDeleted).Select(ctx, r.db, q)
 	if err != nil {
-		return nil, 0, fmt.Errorf("count products: %w", err)
+		return nil, nil, err
 	}
-	if total == 0 {
-		return []*model.Product{}, 0, nil
+
+	products, page := keyset.Page(products)
+	return products, paging.NewCursorPagination(page), nil
+}
+
+// Export streams every product matching req to fn straight from the result
+// set, so memory use does not grow with the table.
+func (r *ProductRepository) Export(ctx context.Context, req *dto.ExportProductRequest, fn func(*model.Product) error) error {
+	ctx = metrics.WithOperation(ctx, "ProductRepository.Export")
+	key, err := productSortKey(req.OrderBy)
+	if err != nil {
+		return err
 	}
 
-	queryBuilder := strings.Builder{}
-	queryBuilder.WriteString("SELECT id, name, price, owner_id, created_at, updated_at FROM products WHERE 1=1")
-	if req.Search != "" {
-		queryBuilder.WriteString(" AND name LIKE ?")
+	q := productQuery(req.Search, req.Filter)
+	q.OrderBy = "id" + direction(req.OrderDesc)
+	if key.Column != "id" {
+		q.OrderBy = key.Column + direction(req.OrderDesc) + ", " + q.OrderBy
 	}
 
-	orderBy := "id"
-	allowedOrderBys := map[string]string{
-		"name":       "name",
-		"price":      "price",
-		"created_at": "created_at",
+	if err := productTableFor(req.IncludeDeleted).Stream(ctx, r.db, q, fn); err != nil {
+		return fmt.Errorf("export products: %w", err)
 	}
-	if col, ok := allowedOrderBys[req.OrderBy]; ok {
-		orderBy = col
+	return nil
+}
+
+// ExistingIDs returns the ids among ids that belong to live products.
+func (r *ProductRepository) ExistingIDs(ctx context.Context, ids []int64) ([]int64, error) {
+	ctx = metrics.WithOperation(ctx, "ProductRepository.ExistingIDs")
+	products, err := productTable.ListByIDs(ctx, r.db, ids)
+	if err != nil {
+		return nil, err
 	}
-	queryBuilder.WriteString(fmt.Sprintf(" ORDER BY %s", orderBy))
-	if req.OrderDesc {
-		queryBuilder.WriteString(" DESC")
-	} else {
-		queryBuilder.WriteString(" ASC")
+
+	existing := make([]int64, 0, len(products))
+	for _, p := range products {
+		existing = append(existing, p.ID)
 	}
+	return existing, nil
+}
+
+// Schema describes the products table as the repository reads it.
+func Schema() dbschema.Table {
+	return productTable.Schema()
+}
+
+var productTable = &database.Table[model.Product]{
+	Name:       "products",
+	Entity:     "product",
+	Columns:    []string{"id", "name", "price", "owner_id", "created_at", "updated_at", "deleted_at", "version"},
+	Writable:   []string{"name", "price", "owner_id"},
+	Timestamps: true,
+	SoftDelete: true,
+	Scan:       scanProduct,
+	Values: func(p *model.Product) []any {
+		return []any{p.Name, p.Price, p.OwnerID}
+	},
+	ID:    productID,
+	SetID: func(p *model.Product, id int64) { p.ID = id },
 
-	if !req.TakeAll {
-		queryBuilder.WriteString(" LIMIT ? OFFSET ?")
-		offset := (req.Page - 1) * req.Limit
-		args = append(args, req.Limit, offset)
+	Version:    func(p *model.Product) int64 { return p.Version },
+	SetVersion: func(p *model.Product, version int64) { p.Version = version },
+}
+
+// productTableFor returns the table to list from, which sees soft-deleted
+// products when includeDeleted is set.
+func productTableFor(includeDeleted bool) *database.Table[model.Product] {
+	if includeDeleted {
+		return productTable.Unscoped()
 	}
+	return productTable
+}
 
-	rows, err := r.db.QueryContext(ctx, queryBuilder.String(), args...)
-	if err != nil {
-		return nil, 0, fmt.Errorf("list products: %w", err)
+// productQuery starts a listing from the search term and the parsed filters.
+func productQuery(search string, f *filter.Filter) database.ListQuery {
+	var q database.ListQuery
+	for _, clause := range f.Clauses() {
+		q.And(clause.SQL, clause.Args...)
+	}
+	if search != "" {
+		q.And("name LIKE ?", "%"+search+"%")
 	}
+	return q
+}
+
+// productSortKeys are the columns products can be ordered by, keyed by the
+// order_by query value.
+var productSortKeys = map[string]cursor.SortKey[model.Product]{
+	"id":         cursor.IntKey("id", productID),
+	"name":       cursor.StringKey("name", func(p *model.Product) string { return p.Name }),
+	"price":      cursor.FloatKey("price", func(p *model.Product) float64 { return p.Price }),
+	"created_at": cursor.TimeKey("created_at", func(p *model.Product) time.Time { return p.CreatedAt }),
+}
 
-	scanProduct := func(rows *sql.Rows) (*model.Product, error) {
-		var p model.Product
-		err := rows.Scan(&p.ID, &p.Name, &p.Price, &p.OwnerID, &p.CreatedAt, &p.UpdatedAt)
-		return &p, err
+// productSortKey returns the key of order_by, id when it is empty. Other
+// fields are rejected, as gorm does.
+func productSortKey(orderBy string) (cursor.SortKey[model.Product], error) {
+	if orderBy == "" {
+		return productSortKeys["id"], nil
 	}
 
-	products, err := database.ScanRows(rows, scanProduct)
-	if err != nil {
-		return nil, 0, fmt.Errorf("scan products: %w", err)
+	key, ok := productSortKeys[orderBy]
+	if !ok {
+		return key, apperror.Validation("sorting by %q is not supported", orderBy)
 	}
+	return key, nil
+}
+
+func productID(p *model.Product) int64 {
+	return p.ID
+}
 
-	return products, total, nil
+func scanProduct(row database.Scanner) (*model.Product, error) {
+	var p model.Product
+	err := row.Scan(&p.ID, &p.Name, &p.Price, &p.OwnerID, &p.CreatedAt, &p.UpdatedAt, &p.DeletedAt, &p.Version)
+	return &p, err
+}
+
+func direction(desc bool) string {
+	if desc {
+		return " DESC"
+	}
+	return " ASC"
 }
@@ -2,9 +2,13 @@ package service
 
 import (
 	"context"
-	"database/sql"
+	"errors"
 	"fmt"
+	"time"
 
+	"db_blueprints/apperror"
+	"db_blueprints/batch"
+	"db_blueprints/db_sql/database"
 	"db_blueprints/db_sql/internal/domain/product/controller/dto"
 	"db_blueprints/db_sql/internal/domain/product/repository"
 	user_repo "db_blueprints/db_sql/internal/domain/user/repository"
@@ -14,22 +18,32 @@ import (
 
 type IProductService interface {
 	ListProducts(ctx context.Context, req *dto.ListProductRequest) ([]*model.Product, *paging.Pagination, error)
-	GetByID(ctx context.Context, id int64) (*model.Product, error)
+	ExportProducts(ctx context.Context, req *dto.ExportProductRequest, fn func(*model.Product) error) error
+	GetByID(ctx context.Context, id int64, includeDeleted bool) (*model.Product, error)
 	CreateProduct(ctx context.Context, req *dto.CreateProductRequest) (*model.Product, error)
+	CreateProducts(ctx context.Context, reqs []*dto.CreateProductRequest) ([]*model.Product, error)
 	UpdateProduct(ctx context.Context, id int64, req *dto.UpdateProductRequest) (*model.Product, error)
+	PatchProduct(ctx context.Context, id int64, req *dto.PatchProductRequest) (*model.Product, []string, error)
+	UpdateProducts(ctx context.Context, items []*dto.BatchUpdateProductItem, allOrNothing bool) ([]*model.Product, []error, error)
 	DeleteProduct(ctx context.Context, id int64) error
+	DeleteProducts(ctx context.Context, ids []int64, allOrNothing bool) ([]int64, error)
+	RestoreProduct(ctx context.Context, id int64) (*model.Product, error)
+	PurgeDeleted(ctx context.Context, before time.Time) (int64, error)
 }
 
 type ProductService struct {
+	db        database.DBTX
 	repo      repository.IProductRepository
 	user_repo user_repo.IUserRepository
 }
 
 func NewProductService(
+	db database.DBTX,
 	repo repository.IProductRepository,
 	user_repo user_repo.IUserRepository,
 ) IProductService {
 	return &ProductService{
+		db:        db,
 		repo:      repo,
 		user_repo: user_repo,
 	}
@@ -43,6 +57,20 @@ func (s *ProductService) ListProducts(ctx context.Context, req *dto.ListProductR
 		req.Limit = paging.DefaultPageSize
 	}
 
+	if req.Cursor != nil {
+		products, pagination, err := s.repo.ListByCursor(ctx, req)
+		if err != nil {
+			return nil, nil, fmt.Errorf("service: failed to list products: %w", err)
+		}
+
+		productResponses, err := s.attachOwners(ctx, products)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return productResponses, pagination, nil
+	}
+
 	products, total, err := s.repo.List(ctx, req)
 	if err != nil {
 		return nil, nil, fmt.Errorf("service: failed to list products: %w", err)
@@ -51,6 +79,23 @@ func (s *ProductService) ListProducts(ctx context.Context, req *dto.ListProductR
 		return nil, nil, nil
 	}
 
+	productResponses, err := s.attachOwners(ctx, products)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pagination := paging.NewPagination(req.Page, req.Limit, total)
+
+	return productResponses, pagination, nil
+}
+
+// attachOwners loads the owners of products with a single query and returns
+// copies of the products with Owner set.
+func (s *ProductService) attachOwners(ctx context.Context, products []*model.Product) ([]*model.Product, error) {
+	if len(products) == 0 {
+		return products, nil
+	}
+
 	// 2. Thu thập các owner_id
 	ownerIDs := make([]int64, 0, len(products))
 	for _, p := range products {
@@ -59,7 +104,7 @@ func (s *ProductService) ListProducts(ctx context.Context, req *dto.ListProductR
 
 	owners, err := s.user_repo.ListByIDs(ctx, ownerIDs)
 	if err != nil {
-		return nil, nil, fmt.Errorf("service: failed to get owners for products: %w", err)
+		return nil, fmt.Errorf("service: failed to get owners for products: %w", err)
 	}
 
 	ownerMap := make(map[int64]*model.User, len(owners))
@@ -76,6 +121,8 @@ func (s *ProductService) ListProducts(ctx context.Context, req *dto.ListProductR
 			OwnerID:   p.OwnerID,
 			CreatedAt: p.CreatedAt,
 			UpdatedAt: p.UpdatedAt,
+			DeletedAt: p.DeletedAt,
+			Version:   p.Version,
 		}
 
 		if owner, ok := ownerMap[p.OwnerID]; ok {
@@ -88,28 +135,34 @@ func (s *ProductService) ListProducts(ctx context.Context, req *dto.ListProductR
 		productResponses = append(productResponses, productResp)
 	}
 
-	pagination := paging.NewPagination(req.Page, req.Limit, total)
-	pagination.TakeAll = req.TakeAll
+	return productResponses, nil
+}
 
-	return productResponses, pagination, nil
+// ExportProducts streams every product matching req to fn in order.
+func (s *ProductService) ExportProducts(ctx context.Context, req *dto.ExportProductRequest, fn func(*model.Product) error) error {
+	if err := s.repo.Export(ctx, req, fn); err != nil {
+		return fmt.Errorf("service: failed to export products: %w", err)
+	}
+	return nil
 }
 
-func (s *ProductService) GetByID(ctx context.Context, id int64) (*model.Product, error) {
-	product, err := s.repo.GetByID(ctx, id)
+// GetByID returns the product and its owner. With includeDeleted, a
+// soft-deleted product, and its possibly deleted owner, are found as well.
+func (s *ProductService) GetByID(ctx context.Context, id int64, includeDeleted bool) (*model.Product, error) {
+	getProduct, getUser := s.repo.GetByID, s.user_repo.GetByID
+	if includeDeleted {
+		getProduct, getUser = s.repo.GetByIDUnscoped, s.user_repo.GetByIDUnscoped
+	}
+
+	product, err := getProduct(ctx, id)
 	if err != nil {
 		return nil, fmt.Errorf("service: failed to get product by id: %w", err)
 	}
-	if product == nil {
-		return nil, fmt.Errorf("service: product with id %d not found", id)
-	}
 
-	user, err := s.user_repo.GetByID(ctx, product.OwnerID)
+	user, err := getUser(ctx, product.OwnerID)
 	if err != nil {
 		return nil, fmt.Errorf("service: failed to get user by id: %w", err)
 	}
-	if user == nil {
-		return nil, fmt.Errorf("service: user with id %d not found", id)
-	}
 
 	product.Owner = user
 
@@ -131,13 +184,35 @@ func (s *ProductService) CreateProduct(ctx context.Context, req *dto.CreateProdu
 	return createdProduct, nil
 }
 
+// CreateProducts inserts the products in one transaction, with multi-row
+// INSERTs of batch.InsertSize rows, and returns them in request order.
+func (s *ProductService) CreateProducts(ctx context.Context, reqs []*dto.CreateProductRequest) ([]*model.Product, error) {
+	products := make([]*model.Product, 0, len(reqs))
+	for _, req := range reqs {
+		products = append(products, &model.Product{
+			Name:    req.Name,
+			Price:   req.Price,
+			OwnerID: req.OwnerID,
+		})
+	}
+
+	err := database.WithTx(ctx, s.db, func(ctx context.Context, tx database.DBTX) error {
+		return s.repo.WithTx(tx).CreateInBatches(ctx, products, batch.InsertSize)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("service: failed to create products: %w", err)
+	}
+
+	return products, nil
+}
+
 func (s *ProductService) UpdateProduct(ctx context.Context, id int64, req *dto.UpdateProductRequest) (*model.Product, error) {
 	productToUpdate, err := s.repo.GetByID(ctx, id)
 	if err != nil {
 		return nil, fmt.Errorf("service: failed to get product for update: %w", err)
 	}
-	if productToUpdate == nil {
-		return nil, fmt.Errorf("service: product with id %d not found for update", id)
+	if req.Version != nil && *req.Version != productToUpdate.Version {
+		return nil, apperror.VersionConflict("product with id %d is at version %d, not %d", id, productToUpdate.Version, *req.Version)
 	}
 
 	if req.Name != nil {
@@ -149,15 +224,81 @@ func (s *ProductService) UpdateProduct(ctx context.Context, id int64, req *dto.U
 
 	updatedProduct, err := s.repo.Update(ctx, productToUpdate)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("service: product with id %d not found on update attempt", id)
-		}
 		return nil, fmt.Errorf("service: failed to update product: %w", err)
 	}
 
 	return updatedProduct, nil
 }
 
+// PatchProduct applies the members of a merge patch that differ from the stored
+// product and writes only those columns. It returns the product together with
+// the fields that changed.
+func (s *ProductService) PatchProduct(ctx context.Context, id int64, req *dto.PatchProductRequest) (*model.Product, []string, error) {
+	return s.patchProduct(ctx, s.repo, id, req)
+}
+
+func (s *ProductService) patchProduct(ctx context.Context, repo repository.IProductRepository, id int64, req *dto.PatchProductRequest) (*model.Product, []string, error) {
+	product, err := repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, nil, fmt.Errorf("service: failed to get product for patch: %w", err)
+	}
+	if req.Version != nil && *req.Version != product.Version {
+		return nil, nil, apperror.VersionConflict("product with id %d is at version %d, not %d", id, product.Version, *req.Version)
+	}
+
+	changed := []string{}
+	if req.Name != nil && *req.Name != product.Name {
+		product.Name = *req.Name
+		changed = append(changed, "name")
+	}
+	if req.Price != nil && *req.Price != product.Price {
+		product.Price = *req.Price
+		changed = append(changed, "price")
+	}
+	if req.OwnerID != nil && *req.OwnerID != product.OwnerID {
+		product.OwnerID = *req.OwnerID
+		changed = append(changed, "owner_id")
+	}
+
+	if _, err := repo.UpdateColumns(ctx, product, changed...); err != nil {
+		return nil, nil, fmt.Errorf("service: failed to patch product: %w", err)
+	}
+
+	return product, changed, nil
+}
+
+// UpdateProducts patches the products of items in one transaction, each item
+// in a savepoint of its own, and returns the updated products and the error
+// of each item in request order. With allOrNothing, nothing is written when
+// any item fails.
+func (s *ProductService) UpdateProducts(ctx context.Context, items []*dto.BatchUpdateProductItem, allOrNothing bool) ([]*model.Product, []error, error) {
+	products := make([]*model.Product, len(items))
+	errs := make([]error, len(items))
+	err := database.WithTx(ctx, s.db, func(ctx context.Context, tx database.DBTX) error {
+		repo := s.repo.WithTx(tx)
+
+		failed := false
+		for i, item := range items {
+			req := &dto.PatchProductRequest{OwnerID: item.OwnerID, Name: item.Name, Price: item.Price, Version: item.Version}
+			errs[i] = database.WithTx(ctx, tx, func(ctx context.Context, _ database.DBTX) error {
+				var err error
+				products[i], _, err = s.patchProduct(ctx, repo, item.ID, req)
+				return err
+			}, database.WithSavepoint())
+			failed = failed || errs[i] != nil
+		}
+		if allOrNothing && failed {
+			return batch.ErrAborted
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, batch.ErrAborted) {
+		return nil, nil, fmt.Errorf("service: failed to update products: %w", err)
+	}
+
+	return products, errs, nil
+}
+
 func (s *ProductService) DeleteProduct(ctx context.Context, id int64) error {
 	_, err := s.repo.GetByID(ctx, id)
 	if err != nil {
@@ -166,11 +307,83 @@ func (s *ProductService) DeleteProduct(ctx context.Context, id int64) error {
 
 	err = s.repo.Delete(ctx, id)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return fmt.Errorf("service: product with id %d not found for deletion", id)
-		}
 		return fmt.Errorf("service: failed to delete product: %w", err)
 	}
 
 	return nil
 }
+
+// DeleteProducts soft-deletes the products with the given ids in one
+// transaction and returns the ids that matched no live product. With
+// allOrNothing, nothing is deleted when any id is missing.
+func (s *ProductService) DeleteProducts(ctx context.Context, ids []int64, allOrNothing bool) ([]int64, error) {
+	var missing []int64
+	err := database.WithTx(ctx, s.db, func(ctx context.Context, tx database.DBTX) error {
+		repo := s.repo.WithTx(tx)
+
+		existing, err := repo.ExistingIDs(ctx, ids)
+		if err != nil {
+			return err
+		}
+		missing = missingIDs(ids, existing)
+		if len(existing) == 0 || (allOrNothing && len(missing) > 0) {
+			return nil
+		}
+
+		_, err = repo.DeleteByIDs(ctx, existing)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("service: failed to delete products: %w", err)
+	}
+
+	return missing, nil
+}
+
+// RestoreProduct undoes a soft delete. A product cannot come back while its
+// owner is deleted, since purging the owner would remove it again.
+func (s *ProductService) RestoreProduct(ctx context.Context, id int64) (*model.Product, error) {
+	product, err := s.repo.GetByIDUnscoped(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("service: failed to get product for restore: %w", err)
+	}
+
+	ownerExists, err := s.user_repo.ExistsByID(ctx, product.OwnerID)
+	if err != nil {
+		return nil, fmt.Errorf("service: failed to check product owner: %w", err)
+	}
+	if !ownerExists {
+		return nil, apperror.Conflict("product %d cannot be restored while its owner %d is deleted", id, product.OwnerID)
+	}
+
+	if err := s.repo.Restore(ctx, id); err != nil {
+		return nil, fmt.Errorf("service: failed to restore product: %w", err)
+	}
+
+	return s.GetByID(ctx, id, false)
+}
+
+// PurgeDeleted removes products soft-deleted before the given time for good.
+func (s *ProductService) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	purged, err := s.repo.Purge(ctx, before)
+	if err != nil {
+		return 0, fmt.Errorf("service: failed to purge products: %w", err)
+	}
+	return purged, nil
+}
+
+// missingIDs returns the ids that are not in existing, in order.
+func missingIDs(ids, existing []int64) []int64 {
+	found := make(map[int64]bool, len(existing))
+	for _, id := range existing {
+		found[id] = true
+	}
+
+	var missing []int64
+	for _, id := range ids {
+		if !found[id] {
+			missing = append(missing, id)
+		}
+	}
+	return missing
+}
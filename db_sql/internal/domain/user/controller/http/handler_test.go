@@ -0,0 +1,84 @@
+package http
+
+import (
+	"context"
+	"db_blueprints/apperror"
+	"db_blueprints/db_sql/internal/domain/user/controller/dto"
+	"db_blueprints/db_sql/internal/domain/user/service"
+	"db_blueprints/db_sql/internal/model"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fakeUserService stores a single user at version 2. Only the methods the
+// tests call are implemented; the others panic through the nil interface.
+type fakeUserService struct {
+	service.IUserService
+}
+
+func (fakeUserService) UpdateUser(ctx context.Context, id int64, req *dto.UpdateUserRequest) (*model.User, error) {
+	if req.Version != nil && *req.Version != 2 {
+		return nil, apperror.VersionConflict("user with id %d is at version 2, not %d", id, *req.Version)
+	}
+	return &model.User{ID: id, Name: *req.Name, Version: 3}, nil
+}
+
+func TestUpdateUserPreconditions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.PUT("/users/:id", NewUserHandler(fakeUserService{}).UpdateUser)
+
+	tests := []struct {
+		name    string
+		ifMatch string
+		body    string
+		status  int
+		code    apperror.Code
+	}{
+		{name: "current If-Match", ifMatch: `"2"`, body: `{"id": 1, "name": "B"}`, status: http.StatusOK},
+		{name: "stale If-Match", ifMatch: `"1"`, body: `{"id": 1, "name": "B"}`, status: http.StatusPreconditionFailed, code: apperror.CodePreconditionFailed},
+		{name: "foreign If-Match", ifMatch: `"abc"`, body: `{"id": 1, "name": "B"}`, status: http.StatusPreconditionFailed, code: apperror.CodePreconditionFailed},
+		{name: "several If-Match tags", ifMatch: `"1", "2"`, body: `{"id": 1, "name": "B"}`, status: http.StatusBadRequest, code: apperror.CodeValidation},
+		{name: "stale body version", body: `{"id": 1, "name": "B", "version": 1}`, status: http.StatusConflict, code: apperror.CodeVersionConflict},
+		{name: "If-Match wins over the body", ifMatch: `"2"`, body: `{"id": 1, "name": "B", "version": 1}`, status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPut, "/users/1", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status == http.StatusOK {
+				if tag := w.Header().Get("ETag"); tag != `"3"` {
+					t.Errorf("ETag = %s, want \"3\"", tag)
+				}
+				return
+			}
+
+			var body struct {
+				Error struct {
+					Code apperror.Code `json:"code"`
+				} `json:"error"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Error.Code != tt.code {
+				t.Errorf("code = %s, want %s", body.Error.Code, tt.code)
+			}
+		})
+	}
+}
@@ -2,21 +2,39 @@ package repository
 
 import (
 	"context"
-	"database/sql"
+	"db_blueprints/apperror"
+	"db_blueprints/cursor"
 	"db_blueprints/db_sql/database"
 	"db_blueprints/db_sql/internal/domain/user/controller/dto"
 	"db_blueprints/db_sql/internal/model"
+	"db_blueprints/db_sql/pkgs/paging"
+	"db_blueprints/dbschema"
+	"db_blueprints/filter"
+	"db_blueprints/metrics"
 	"fmt"
-	"strings"
+	"time"
 )
 
 type IUserRepository interface {
 	GetByID(ctx context.Context, id int64) (*model.User, error)
+	GetByIDUnscoped(ctx context.Context, id int64) (*model.User, error)
 	Create(ctx context.Context, user *model.User) (*model.User, error)
+	CreateInBatches(ctx context.Context, users []*model.User, size int) error
 	Update(ctx context.Context, user *model.User) (*model.User, error)
+	UpdateColumns(ctx context.Context, user *model.User, columns ...string) (*model.User, error)
 	Delete(ctx context.Context, id int64) error
+	DeleteByIDs(ctx context.Context, ids []int64) (int64, error)
+	Restore(ctx context.Context, id int64) error
+	Purge(ctx context.Context, before time.Time) (int64, error)
 	List(ctx context.Context, req *dto.ListUserRequest) ([]*model.User, int64, error)
+	ListByCursor(ctx context.Context, req *dto.ListUserRequest) ([]*model.User, *paging.Pagination, error)
+	Export(ctx context.Context, req *dto.ExportUserRequest, fn func(*model.User) error) error
 	ListByIDs(ctx context.Context, ids []int64) ([]*model.User, error)
+	ExistsByID(ctx context.Context, id int64) (bool, error)
+	ExistsByEmail(ctx context.Context, email string) (bool, error)
+	DeletedIDByEmail(ctx context.Context, email string) (int64, error)
+	ExistingIDs(ctx context.Context, ids []int64) ([]int64, error)
+	WithTx(tx database.DBTX) IUserRepository
 }
 
 type UserRepository struct {
@@ -27,158 +45,277 @@ func NewUserRepository(db database.DBTX) IUserRepository {
 	return &UserRepository{db: db}
 }
 
+// WithTx returns a copy of the repository bound to tx, typically the one
+// handed to a database.WithTx callback.
+func (r *UserRepository) WithTx(tx database.DBTX) IUserRepository {
+	return &UserRepository{db: tx}
+}
+
 func (r *UserRepository) GetByID(ctx context.Context, id int64) (*model.User, error) {
-	query := "SELECT id, name, email, created_at, updated_at FROM users WHERE id = ?"
-	row := r.db.QueryRowContext(ctx, query, id)
-	var user model.User
-	err := row.Scan(&user.ID, &user.Name, &user.Email, &user.CreatedAt, &user.UpdatedAt)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil
-		}
-		return nil, fmt.Errorf("get user by id: %w", err)
-	}
-	return &user, nil
+	ctx = metrics.WithOperation(ctx, "UserRepository.GetByID")
+	return userTable.Get(ctx, r.db, id)
+}
+
+// GetByIDUnscoped returns the user even when it is soft-deleted.
+func (r *UserRepository) GetByIDUnscoped(ctx context.Context, id int64) (*model.User, error) {
+	ctx = metrics.WithOperation(ctx, "UserRepository.GetByIDUnscoped")
+	return userTable.Unscoped().Get(ctx, r.db, id)
 }
 
 func (r *UserRepository) Create(ctx context.Context, user *model.User) (*model.User, error) {
-	query := "INSERT INTO users (name, email, created_at, updated_at) VALUES (?, ?, NOW(), NOW())"
-	result, err := r.db.ExecContext(ctx, query, user.Name, user.Email)
-	if err != nil {
-		return nil, fmt.Errorf("create user: %w", err)
-	}
-	id, err := result.LastInsertId()
-	if err != nil {
-		return nil, fmt.Errorf("get last insert id: %w", err)
+	ctx = metrics.WithOperation(ctx, "UserRepository.Create")
+	if err := userTable.Insert(ctx, r.db, user); err != nil {
+		return nil, err
 	}
-	user.ID = id
 	return user, nil
 }
 
+// CreateInBatches inserts the users with multi-row INSERTs of up to size rows
+// and sets their IDs. Their products are not inserted.
+func (r *UserRepository) CreateInBatches(ctx context.Context, users []*model.User, size int) error {
+	ctx = metrics.WithOperation(ctx, "UserRepository.CreateInBatches")
+	return userTable.InsertInBatches(ctx, r.db, users, size)
+}
+
 func (r *UserRepository) Update(ctx context.Context, user *model.User) (*model.User, error) {
-	query := "UPDATE users SET name = ?, email = ?, updated_at = NOW() WHERE id = ?"
-	result, err := r.db.ExecContext(ctx, query, user.Name, user.Email, user.ID)
+	ctx = metrics.WithOperation(ctx, "UserRepository.Update")
+	if err := userTable.Update(ctx, r.db, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
 
-	if err != nil {
-		return nil, fmt.Errorf("update user: %w", err)
+// UpdateColumns writes only the named columns of the user.
+func (r *UserRepository) UpdateColumns(ctx context.Context, user *model.User, columns ...string) (*model.User, error) {
+	ctx = metrics.WithOperation(ctx, "UserRepository.UpdateColumns")
+	if err := userTable.UpdateColumns(ctx, r.db, user, columns...); err != nil {
+		return nil, err
 	}
+	return user, nil
+}
 
-	rowsAffected, err := result.RowsAffected()
+func (r *UserRepository) Delete(ctx context.Context, id int64) error {
+	ctx = metrics.WithOperation(ctx, "UserRepository.Delete")
+	return userTable.Delete(ctx, r.db, id)
+}
+
+// DeleteByIDs soft-deletes the live users among ids.
+func (r *UserRepository) DeleteByIDs(ctx context.Context, ids []int64) (int64, error) {
+	ctx = metrics.WithOperation(ctx, "UserRepository.DeleteByIDs")
+	cond, args := database.In("id", ids)
+	return userTable.DeleteWhere(ctx, r.db, cond, args...)
+}
+
+func (r *UserRepository) Restore(ctx context.Context, id int64) error {
+	ctx = metrics.WithOperation(ctx, "UserRepository.Restore")
+	return userTable.Restore(ctx, r.db, id)
+}
+
+// Purge removes users soft-deleted before the given time for good. The
+// foreign key cascades to their products.
+func (r *UserRepository) Purge(ctx context.Context, before time.Time) (int64, error) {
+	ctx = metrics.WithOperation(ctx, "UserRepository.Purge")
+	return userTable.Purge(ctx, r.db, before)
+}
+
+func (r *UserRepository) List(ctx context.Context, req *dto.ListUserRequest) ([]*model.User, int64, error) {
+	ctx = metrics.WithOperation(ctx, "UserRepository.List")
+	key, err := userSortKey(req.OrderBy)
 	if err != nil {
-		return nil, fmt.Errorf("get rows affected: %w", err)
+		return nil, 0, err
 	}
 
-	if rowsAffected == 0 {
-		return nil, sql.ErrNoRows
-	}
+	q := userQuery(req.Search, req.Filter)
+	q.OrderBy = key.Column + direction(req.OrderDesc)
+	q.Limit = req.Limit
+	q.Offset = (req.Page - 1) * req.Limit
 
-	return user, nil
+	return userTableFor(req.IncludeDeleted).List(ctx, r.db, q)
 }
 
-func (r *UserRepository) Delete(ctx context.Context, id int64) error {
-	query := "DELETE FROM users WHERE id = ?"
-	result, err := r.db.ExecContext(ctx, query, id)
+// ListByCursor lists users with keyset pagination. It never runs a COUNT
+// query, so the pagination it returns only carries the cursors.
+func (r *UserRepository) ListByCursor(ctx context.Context, req *dto.ListUserRequest) ([]*model.User, *paging.Pagination, error) {
+	ctx = metrics.WithOperation(ctx, "UserRepository.ListByCursor")
+	key, err := userSortKey(req.OrderBy)
 	if err != nil {
-		return fmt.Errorf("delete user: %w", err)
+		return nil, nil, err
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	keyset, err := cursor.NewKeyset(key, req.OrderDesc, *req.Cursor, paging.PageSize(req.Limit), userID)
 	if err != nil {
-		return fmt.Errorf("get rows affected: %w", err)
+		return nil, nil, err
 	}
 
-	if rowsAffected == 0 {
-		return sql.ErrNoRows
+	q := userQuery(req.Search, req.Filter)
+	where, args, err := keyset.Where()
+	if err != nil {
+		return nil, nil, err
 	}
-	return nil
-}
+	if where != "" {
+		q.And(where, args...)
+	}
+	q.OrderBy = keyset.OrderBy()
+	q.Limit = keyset.Limit()
 
-func (r *UserRepository) List(ctx context.Context, req *dto.ListUserRequest) ([]*model.User, int64, error) {
-	var total int64
-	countQueryBuilder := strings.Builder{}
-	countQueryBuilder.WriteString("SELECT COUNT(id) FROM users WHERE 1=1")
-	args := []interface{}{}
-	if req.Search != "" {
-		countQueryBuilder.WriteString(" AND (name LIKE ? OR email LIKE ?)")
-		searchPattern := "%" + req.Search + "%"
-		args = append(args, searchPattern, searchPattern)
-	}
-	err := r.db.QueryRowContext(ctx, countQueryBuilder.String(), args...).Scan(&total)
+	users, err := userTableFor(req.IncludeDeleted).Select(ctx, r.db, q)
 	if err != nil {
-		return nil, 0, fmt.Errorf("count users: %w", err)
-	}
-	if total == 0 {
-		return []*model.User{}, 0, nil
+		return nil, nil, err
 	}
 
-	queryBuilder := strings.Builder{}
-	queryBuilder.WriteString("SELECT id, name, email, created_at, updated_at FROM users WHERE 1=1")
-	if req.Search != "" {
-		queryBuilder.WriteString(" AND (name LIKE ? OR email LIKE ?)")
+	users, page := keyset.Page(users)
+	return users, paging.NewCursorPagination(page), nil
+}
+
+// Export streams every user matching req to fn straight from the result set,
+// so memory use does not grow with the table.
+func (r *UserRepository) Export(ctx context.Context, req *dto.ExportUserRequest, fn func(*model.User) error) error {
+	ctx = metrics.WithOperation(ctx, "UserRepository.Export")
+	key, err := userSortKey(req.OrderBy)
+	if err != nil {
+		return err
 	}
 
-	orderBy := "id"
-	allowedOrderBys := map[string]string{"name": "name", "email": "email", "created_at": "created_at"}
-	if col, ok := allowedOrderBys[req.OrderBy]; ok {
-		orderBy = col
+	q := userQuery(req.Search, req.Filter)
+	q.OrderBy = "id" + direction(req.OrderDesc)
+	if key.Column != "id" {
+		q.OrderBy = key.Column + direction(req.OrderDesc) + ", " + q.OrderBy
 	}
-	queryBuilder.WriteString(fmt.Sprintf(" ORDER BY %s", orderBy))
-	if req.OrderDesc {
-		queryBuilder.WriteString(" DESC")
-	} else {
-		queryBuilder.WriteString(" ASC")
+
+	if err := userTableFor(req.IncludeDeleted).Stream(ctx, r.db, q, fn); err != nil {
+		return fmt.Errorf("export users: %w", err)
 	}
+	return nil
+}
+
+// ListByIDs includes soft-deleted users, so products listed with
+// include_deleted keep their owner.
+func (r *UserRepository) ListByIDs(ctx context.Context, ids []int64) ([]*model.User, error) {
+	ctx = metrics.WithOperation(ctx, "UserRepository.ListByIDs")
+	return userTable.Unscoped().ListByIDs(ctx, r.db, ids)
+}
+
+func (r *UserRepository) ExistsByID(ctx context.Context, id int64) (bool, error) {
+	ctx = metrics.WithOperation(ctx, "UserRepository.ExistsByID")
+	return userTable.Exists(ctx, r.db, "id = ?", id)
+}
+
+// ExistsByEmail reports whether a live user has email. Soft-deleted users
+// keep theirs until they are purged, see DeletedIDByEmail.
+func (r *UserRepository) ExistsByEmail(ctx context.Context, email string) (bool, error) {
+	ctx = metrics.WithOperation(ctx, "UserRepository.ExistsByEmail")
+	return userTable.Exists(ctx, r.db, "email = ?", email)
+}
 
-	if !req.TakeAll {
-		queryBuilder.WriteString(" LIMIT ? OFFSET ?")
-		offset := (req.Page - 1) * req.Limit
-		args = append(args, req.Limit, offset)
+// DeletedIDByEmail returns the id of the soft-deleted user with email, or 0
+// when there is none.
+func (r *UserRepository) DeletedIDByEmail(ctx context.Context, email string) (int64, error) {
+	ctx = metrics.WithOperation(ctx, "UserRepository.DeletedIDByEmail")
+	var q database.ListQuery
+	q.And("email = ? AND deleted_at IS NOT NULL", email)
+	users, err := userTable.Unscoped().Select(ctx, r.db, q)
+	if err != nil || len(users) == 0 {
+		return 0, err
 	}
+	return users[0].ID, nil
+}
 
-	rows, err := r.db.QueryContext(ctx, queryBuilder.String(), args...)
+// ExistingIDs returns the ids among ids that belong to live users.
+func (r *UserRepository) ExistingIDs(ctx context.Context, ids []int64) ([]int64, error) {
+	ctx = metrics.WithOperation(ctx, "UserRepository.ExistingIDs")
+	users, err := userTable.ListByIDs(ctx, r.db, ids)
 	if err != nil {
-		return nil, 0, fmt.Errorf("list users: %w", err)
+		return nil, err
 	}
 
-	scanUser := func(rows *sql.Rows) (*model.User, error) {
-		var u model.User
-		err := rows.Scan(&u.ID, &u.Name, &u.Email, &u.CreatedAt, &u.UpdatedAt)
-		return &u, err
-	}
-	users, err := database.ScanRows(rows, scanUser)
-	if err != nil {
-		return nil, 0, fmt.Errorf("scan users: %w", err)
+	existing := make([]int64, 0, len(users))
+	for _, u := range users {
+		existing = append(existing, u.ID)
 	}
-	return users, total, nil
+	return existing, nil
 }
 
-func (r *UserRepository) ListByIDs(ctx context.Context, ids []int64) ([]*model.User, error) {
-	if len(ids) == 0 {
-		return []*model.User{}, nil
-	}
+// Schema describes the users table as the repository reads it.
+func Schema() dbschema.Table {
+	return userTable.Schema()
+}
 
-	query := "SELECT id, name, email, created_at, updated_at FROM users WHERE id IN (?" + strings.Repeat(",?", len(ids)-1) + ")"
+var userTable = &database.Table[model.User]{
+	Name:       "users",
+	Entity:     "user",
+	Columns:    []string{"id", "name", "email", "created_at", "updated_at", "deleted_at", "version"},
+	Writable:   []string{"name", "email"},
+	Timestamps: true,
+	SoftDelete: true,
+	Scan:       scanUser,
+	Values: func(u *model.User) []any {
+		return []any{u.Name, u.Email}
+	},
+	ID:    userID,
+	SetID: func(u *model.User, id int64) { u.ID = id },
 
-	args := make([]interface{}, len(ids))
-	for i, id := range ids {
-		args[i] = id
+	Version:    func(u *model.User) int64 { return u.Version },
+	SetVersion: func(u *model.User, version int64) { u.Version = version },
+}
+
+// userTableFor returns the table to list from, which sees soft-deleted users
+// when includeDeleted is set.
+func userTableFor(includeDeleted bool) *database.Table[model.User] {
+	if includeDeleted {
+		return userTable.Unscoped()
 	}
+	return userTable
+}
 
-	rows, err := r.db.QueryContext(ctx, query, args...)
-	if err != nil {
-		return nil, fmt.Errorf("list users by ids: %w", err)
+// userQuery starts a listing from the search term and the parsed filters.
+func userQuery(search string, f *filter.Filter) database.ListQuery {
+	var q database.ListQuery
+	for _, clause := range f.Clauses() {
+		q.And(clause.SQL, clause.Args...)
+	}
+	if search != "" {
+		searchPattern := "%" + search + "%"
+		q.And("(name LIKE ? OR email LIKE ?)", searchPattern, searchPattern)
 	}
+	return q
+}
 
-	scanUser := func(rows *sql.Rows) (*model.User, error) {
-		var u model.User
-		err := rows.Scan(&u.ID, &u.Name, &u.Email, &u.CreatedAt, &u.UpdatedAt)
-		return &u, err
+// userSortKeys are the columns users can be ordered by, keyed by the
+// order_by query value.
+var userSortKeys = map[string]cursor.SortKey[model.User]{
+	"id":         cursor.IntKey("id", userID),
+	"name":       cursor.StringKey("name", func(u *model.User) string { return u.Name }),
+	"email":      cursor.StringKey("email", func(u *model.User) string { return u.Email }),
+	"created_at": cursor.TimeKey("created_at", func(u *model.User) time.Time { return u.CreatedAt }),
+}
+
+// userSortKey returns the key of order_by, id when it is empty. Other
+// fields are rejected, as gorm does.
+func userSortKey(orderBy string) (cursor.SortKey[model.User], error) {
+	if orderBy == "" {
+		return userSortKeys["id"], nil
 	}
 
-	users, err := database.ScanRows(rows, scanUser)
-	if err != nil {
-		return nil, fmt.Errorf("scan users by ids: %w", err)
+	key, ok := userSortKeys[orderBy]
+	if !ok {
+		return key, apperror.Validation("sorting by %q is not supported", orderBy)
 	}
+	return key, nil
+}
+
+func userID(u *model.User) int64 {
+	return u.ID
+}
+
+func scanUser(row database.Scanner) (*model.User, error) {
+	var u model.User
+	err := row.Scan(&u.ID, &u.Name, &u.Email, &u.CreatedAt, &u.UpdatedAt, &u.DeletedAt, &u.Version)
+	return &u, err
+}
 
-	return users, nil
+func direction(desc bool) string {
+	if desc {
+		return " DESC"
+	}
+	return " ASC"
 }
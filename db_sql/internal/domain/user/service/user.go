@@ -2,9 +2,14 @@ package service
 
 import (
 	"context"
-	"database/sql"
+	"errors"
 	"fmt"
+	"time"
 
+	"db_blueprints/apperror"
+	"db_blueprints/batch"
+	"db_blueprints/db_sql/database"
+	product_repo "db_blueprints/db_sql/internal/domain/product/repository"
 	"db_blueprints/db_sql/internal/domain/user/controller/dto"
 	"db_blueprints/db_sql/internal/domain/user/repository"
 	"db_blueprints/db_sql/internal/model"
@@ -13,19 +18,35 @@ import (
 
 type IUserService interface {
 	ListUsers(ctx context.Context, req *dto.ListUserRequest) ([]*model.User, *paging.Pagination, error)
-	GetByID(ctx context.Context, id int64) (*model.User, error)
+	ExportUsers(ctx context.Context, req *dto.ExportUserRequest, fn func(*model.User) error) error
+	GetByID(ctx context.Context, id int64, includeDeleted bool) (*model.User, error)
 	CreateUser(ctx context.Context, req *dto.CreateUserRequest) (*model.User, error)
+	CreateUsers(ctx context.Context, reqs []*dto.CreateUserRequest) ([]*model.User, error)
+	CheckEmail(ctx context.Context, email string) error
 	UpdateUser(ctx context.Context, id int64, req *dto.UpdateUserRequest) (*model.User, error)
+	PatchUser(ctx context.Context, id int64, req *dto.PatchUserRequest) (*model.User, []string, error)
+	UpdateUsers(ctx context.Context, items []*dto.BatchUpdateUserItem, allOrNothing bool) ([]*model.User, []error, error)
 	DeleteUser(ctx context.Context, id int64) error
+	DeleteUsers(ctx context.Context, ids []int64, allOrNothing bool) ([]int64, error)
+	RestoreUser(ctx context.Context, id int64) (*model.User, error)
+	PurgeDeleted(ctx context.Context, before time.Time) (int64, error)
 }
 
 type UserService struct {
-	repo repository.IUserRepository
+	db           database.DBTX
+	repo         repository.IUserRepository
+	product_repo product_repo.IProductRepository
 }
 
-func NewUserService(repo repository.IUserRepository) IUserService {
+func NewUserService(
+	db database.DBTX,
+	repo repository.IUserRepository,
+	product_repo product_repo.IProductRepository,
+) IUserService {
 	return &UserService{
-		repo: repo,
+		db:           db,
+		repo:         repo,
+		product_repo: product_repo,
 	}
 }
 
@@ -37,40 +58,138 @@ func (s *UserService) ListUsers(ctx context.Context, req *dto.ListUserRequest) (
 		req.Limit = paging.DefaultPageSize
 	}
 
+	if req.Cursor != nil {
+		users, pagination, err := s.repo.ListByCursor(ctx, req)
+		if err != nil {
+			return nil, nil, fmt.Errorf("service: failed to list users: %w", err)
+		}
+		return users, pagination, nil
+	}
+
 	users, total, err := s.repo.List(ctx, req)
 	if err != nil {
 		return nil, nil, fmt.Errorf("service: failed to list users: %w", err)
 	}
 
 	pagination := paging.NewPagination(req.Page, req.Limit, total)
-	pagination.TakeAll = req.TakeAll
 
 	return users, pagination, nil
 }
 
-func (s *UserService) GetByID(ctx context.Context, id int64) (*model.User, error) {
-	user, err := s.repo.GetByID(ctx, id)
+// ExportUsers streams every user matching req to fn in order.
+func (s *UserService) ExportUsers(ctx context.Context, req *dto.ExportUserRequest, fn func(*model.User) error) error {
+	if err := s.repo.Export(ctx, req, fn); err != nil {
+		return fmt.Errorf("service: failed to export users: %w", err)
+	}
+	return nil
+}
+
+// GetByID returns the user; with includeDeleted a soft-deleted one too.
+func (s *UserService) GetByID(ctx context.Context, id int64, includeDeleted bool) (*model.User, error) {
+	getUser := s.repo.GetByID
+	if includeDeleted {
+		getUser = s.repo.GetByIDUnscoped
+	}
+
+	user, err := getUser(ctx, id)
 	if err != nil {
 		return nil, fmt.Errorf("service: failed to get user by id: %w", err)
 	}
-	if user == nil {
-		return nil, fmt.Errorf("service: user with id %d not found", id)
-	}
 	return user, nil
 }
 
+// CreateUser inserts the user and its initial products in one transaction, so
+// a failing product never leaves an orphaned user behind.
 func (s *UserService) CreateUser(ctx context.Context, req *dto.CreateUserRequest) (*model.User, error) {
+	if err := s.CheckEmail(ctx, req.Email); err != nil {
+		return nil, err
+	}
+
 	user := &model.User{
 		Name:  req.Name,
 		Email: req.Email,
 	}
 
-	createdUser, err := s.repo.Create(ctx, user)
+	err := database.WithTx(ctx, s.db, func(ctx context.Context, tx database.DBTX) error {
+		createdUser, err := s.repo.WithTx(tx).Create(ctx, user)
+		if err != nil {
+			return fmt.Errorf("service: failed to create user: %w", err)
+		}
+
+		productRepo := s.product_repo.WithTx(tx)
+		for _, item := range req.Products {
+			product := &model.Product{
+				Name:    item.Name,
+				Price:   item.Price,
+				OwnerID: createdUser.ID,
+			}
+
+			createdProduct, err := productRepo.Create(ctx, product)
+			if err != nil {
+				return fmt.Errorf("service: failed to create initial product %q: %w", item.Name, err)
+			}
+			createdUser.Products = append(createdUser.Products, createdProduct)
+		}
+
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("service: failed to create user: %w", err)
+		return nil, err
 	}
 
-	return createdUser, nil
+	return user, nil
+}
+
+// CreateUsers inserts the users, then their initial products, in one
+// transaction, with multi-row INSERTs of batch.InsertSize rows. The users are
+// returned in request order.
+func (s *UserService) CreateUsers(ctx context.Context, reqs []*dto.CreateUserRequest) ([]*model.User, error) {
+	users := make([]*model.User, 0, len(reqs))
+	for _, req := range reqs {
+		users = append(users, &model.User{
+			Name:  req.Name,
+			Email: req.Email,
+		})
+	}
+
+	err := database.WithTx(ctx, s.db, func(ctx context.Context, tx database.DBTX) error {
+		if err := s.repo.WithTx(tx).CreateInBatches(ctx, users, batch.InsertSize); err != nil {
+			return err
+		}
+
+		var products []*model.Product
+		for i, req := range reqs {
+			for _, item := range req.Products {
+				product := &model.Product{
+					Name:    item.Name,
+					Price:   item.Price,
+					OwnerID: users[i].ID,
+				}
+				products = append(products, product)
+				users[i].Products = append(users[i].Products, product)
+			}
+		}
+		return s.product_repo.WithTx(tx).CreateInBatches(ctx, products, batch.InsertSize)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("service: failed to create users: %w", err)
+	}
+
+	return users, nil
+}
+
+// CheckEmail fails with a conflict when email belongs to a soft-deleted user.
+// The email stays taken until the user is purged, so the way to use it again
+// is to restore that user. Live owners are rejected by email_unique.
+func (s *UserService) CheckEmail(ctx context.Context, email string) error {
+	id, err := s.repo.DeletedIDByEmail(ctx, email)
+	if err != nil {
+		return fmt.Errorf("service: failed to check email: %w", err)
+	}
+	if id != 0 {
+		return apperror.Conflict("email %q belongs to deleted user %d, restore it with POST /api/users/%d/restore", email, id, id)
+	}
+	return nil
 }
 
 func (s *UserService) UpdateUser(ctx context.Context, id int64, req *dto.UpdateUserRequest) (*model.User, error) {
@@ -78,8 +197,8 @@ func (s *UserService) UpdateUser(ctx context.Context, id int64, req *dto.UpdateU
 	if err != nil {
 		return nil, fmt.Errorf("service: failed to get user for update: %w", err)
 	}
-	if userToUpdate == nil {
-		return nil, fmt.Errorf("service: user with id %d not found for update", id)
+	if req.Version != nil && *req.Version != userToUpdate.Version {
+		return nil, apperror.VersionConflict("user with id %d is at version %d, not %d", id, userToUpdate.Version, *req.Version)
 	}
 
 	if req.Name != nil {
@@ -97,19 +216,171 @@ func (s *UserService) UpdateUser(ctx context.Context, id int64, req *dto.UpdateU
 	return updatedUser, nil
 }
 
+// PatchUser applies the members of a merge patch that differ from the stored
+// user and writes only those columns. It returns the user together with
+// the fields that changed.
+func (s *UserService) PatchUser(ctx context.Context, id int64, req *dto.PatchUserRequest) (*model.User, []string, error) {
+	return s.patchUser(ctx, s.repo, id, req)
+}
+
+func (s *UserService) patchUser(ctx context.Context, repo repository.IUserRepository, id int64, req *dto.PatchUserRequest) (*model.User, []string, error) {
+	user, err := repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, nil, fmt.Errorf("service: failed to get user for patch: %w", err)
+	}
+	if req.Version != nil && *req.Version != user.Version {
+		return nil, nil, apperror.VersionConflict("user with id %d is at version %d, not %d", id, user.Version, *req.Version)
+	}
+
+	changed := []string{}
+	if req.Name != nil && *req.Name != user.Name {
+		user.Name = *req.Name
+		changed = append(changed, "name")
+	}
+	if req.Email != nil && *req.Email != user.Email {
+		user.Email = *req.Email
+		changed = append(changed, "email")
+	}
+
+	if _, err := repo.UpdateColumns(ctx, user, changed...); err != nil {
+		return nil, nil, fmt.Errorf("service: failed to patch user: %w", err)
+	}
+
+	return user, changed, nil
+}
+
+// UpdateUsers patches the users of items in one transaction, each item in a
+// savepoint of its own, and returns the updated users and the error of each
+// item in request order. With allOrNothing, nothing is written when any item
+// fails.
+func (s *UserService) UpdateUsers(ctx context.Context, items []*dto.BatchUpdateUserItem, allOrNothing bool) ([]*model.User, []error, error) {
+	users := make([]*model.User, len(items))
+	errs := make([]error, len(items))
+	err := database.WithTx(ctx, s.db, func(ctx context.Context, tx database.DBTX) error {
+		repo := s.repo.WithTx(tx)
+
+		failed := false
+		for i, item := range items {
+			req := &dto.PatchUserRequest{Email: item.Email, Name: item.Name, Version: item.Version}
+			errs[i] = database.WithTx(ctx, tx, func(ctx context.Context, _ database.DBTX) error {
+				var err error
+				users[i], _, err = s.patchUser(ctx, repo, item.ID, req)
+				return err
+			}, database.WithSavepoint())
+			failed = failed || errs[i] != nil
+		}
+		if allOrNothing && failed {
+			return batch.ErrAborted
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, batch.ErrAborted) {
+		return nil, nil, fmt.Errorf("service: failed to update users: %w", err)
+	}
+
+	return users, errs, nil
+}
+
+// DeleteUser soft-deletes the user together with its products, in one
+// transaction. The rows stay in the database until the purge job removes
+// them, so RestoreUser can bring both back.
 func (s *UserService) DeleteUser(ctx context.Context, id int64) error {
 	_, err := s.repo.GetByID(ctx, id)
 	if err != nil {
 		return fmt.Errorf("service: user with id %d cannot be deleted: %w", id, err)
 	}
 
-	err = s.repo.Delete(ctx, id)
+	return database.WithTx(ctx, s.db, func(ctx context.Context, tx database.DBTX) error {
+		if err := s.repo.WithTx(tx).Delete(ctx, id); err != nil {
+			return fmt.Errorf("service: failed to delete user: %w", err)
+		}
+		if _, err := s.product_repo.WithTx(tx).DeleteByOwner(ctx, id); err != nil {
+			return fmt.Errorf("service: failed to delete products of user: %w", err)
+		}
+		return nil
+	})
+}
+
+// DeleteUsers soft-deletes the users with the given ids, together with their
+// products, in one transaction and returns the ids that matched no live user.
+// With allOrNothing, nothing is deleted when any id is missing.
+func (s *UserService) DeleteUsers(ctx context.Context, ids []int64, allOrNothing bool) ([]int64, error) {
+	var missing []int64
+	err := database.WithTx(ctx, s.db, func(ctx context.Context, tx database.DBTX) error {
+		repo := s.repo.WithTx(tx)
+
+		existing, err := repo.ExistingIDs(ctx, ids)
+		if err != nil {
+			return err
+		}
+		missing = missingIDs(ids, existing)
+		if len(existing) == 0 || (allOrNothing && len(missing) > 0) {
+			return nil
+		}
+
+		if _, err := repo.DeleteByIDs(ctx, existing); err != nil {
+			return err
+		}
+		_, err = s.product_repo.WithTx(tx).DeleteByOwners(ctx, existing)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("service: failed to delete users: %w", err)
+	}
+
+	return missing, nil
+}
+
+// RestoreUser undoes a soft delete, together with the products deleted along
+// with the user. Products deleted earlier on their own stay deleted.
+func (s *UserService) RestoreUser(ctx context.Context, id int64) (*model.User, error) {
+	user, err := s.repo.GetByIDUnscoped(ctx, id)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return fmt.Errorf("service: user with id %d not found for deletion", id)
+		return nil, fmt.Errorf("service: failed to get user for restore: %w", err)
+	}
+	if user.DeletedAt == nil {
+		return nil, apperror.NotFound("deleted user with id %d not found", id)
+	}
+
+	err = database.WithTx(ctx, s.db, func(ctx context.Context, tx database.DBTX) error {
+		if err := s.repo.WithTx(tx).Restore(ctx, id); err != nil {
+			return fmt.Errorf("service: failed to restore user: %w", err)
+		}
+		if _, err := s.product_repo.WithTx(tx).RestoreByOwner(ctx, id, *user.DeletedAt); err != nil {
+			return fmt.Errorf("service: failed to restore products of user: %w", err)
 		}
-		return fmt.Errorf("service: failed to delete user: %w", err)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	user.DeletedAt = nil
+	return user, nil
+}
+
+// PurgeDeleted removes users soft-deleted before the given time for good,
+// and with them their products.
+func (s *UserService) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	purged, err := s.repo.Purge(ctx, before)
+	if err != nil {
+		return 0, fmt.Errorf("service: failed to purge users: %w", err)
+	}
+	return purged, nil
+}
+
+// missingIDs returns the ids that are not in existing, in order.
+func missingIDs(ids, existing []int64) []int64 {
+	found := make(map[int64]bool, len(existing))
+	for _, id := range existing {
+		found[id] = true
+	}
+
+	var missing []int64
+	for _, id := range ids {
+		if !found[id] {
+			missing = append(missing, id)
+		}
+	}
+	return missing
 }
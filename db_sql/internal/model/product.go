@@ -3,11 +3,13 @@ package model
 import "time"
 
 type Product struct {
-	ID        int64     `json:"id"`
-	Name      string    `json:"name"`
-	Price     float64   `json:"price"`
-	OwnerID   int64     `json:"owner_id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Owner     *User     `json:"user"`
+	ID        int64      `json:"id"`
+	Name      string     `json:"name"`
+	Price     float64    `json:"price"`
+	OwnerID   int64      `json:"owner_id"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	Version   int64      `json:"version"`
+	Owner     *User      `json:"user"`
 }
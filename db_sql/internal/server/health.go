@@ -0,0 +1,33 @@
+package server
+
+import (
+	"net/http"
+
+	"db_blueprints/config"
+	"db_blueprints/middleware"
+	"db_blueprints/response"
+
+	"github.com/gin-gonic/gin"
+)
+
+// healthz answers as long as the process can serve requests. It checks no
+// dependency, so a database outage does not get the process restarted.
+func (s Server) healthz(c *gin.Context) {
+	response.JSON(c, http.StatusOK, gin.H{"status": "ok"})
+}
+
+// readyz reports the database and migration checks, and fails with 503 while
+// the server is starting, draining or missing a dependency. The error text of
+// the driver is only shown in development and to admins.
+func (s Server) readyz(c *gin.Context) {
+	report := s.health.Check(c.Request.Context())
+	if config.GetConfig().IsDevelopment() || middleware.IsAdmin(c) {
+		report = report.WithDetails()
+	}
+
+	status := http.StatusOK
+	if !report.Ready() {
+		status = http.StatusServiceUnavailable
+	}
+	response.JSON(c, status, report)
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"db_blueprints/health"
+	"db_blueprints/migration"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestReadyzWhileDraining(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	defer db.Close()
+
+	m, err := migration.New(db, "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	s := Server{engine: gin.New(), health: health.New(db, "sqlite", nil)}
+	s.engine.GET("/healthz", s.healthz)
+	s.engine.GET("/readyz", s.readyz)
+
+	steps := []struct {
+		state   health.State
+		healthz int
+		readyz  int
+	}{
+		{state: health.Starting, healthz: http.StatusOK, readyz: http.StatusServiceUnavailable},
+		{state: health.Serving, healthz: http.StatusOK, readyz: http.StatusOK},
+		{state: health.Draining, healthz: http.StatusOK, readyz: http.StatusServiceUnavailable},
+	}
+
+	for _, step := range steps {
+		s.health.SetState(step.state)
+		for path, want := range map[string]int{"/healthz": step.healthz, "/readyz": step.readyz} {
+			w := httptest.NewRecorder()
+			s.engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+			if w.Code != want {
+				t.Errorf("state %d: %s = %d, want %d: %s", step.state, path, w.Code, want, w.Body)
+			}
+		}
+	}
+}
@@ -1,17 +1,23 @@
 package paging
 
-import "math"
+import (
+	"db_blueprints/cursor"
+	"math"
+)
 
 const DefaultPageSize int64 = 10
 
 type Pagination struct {
 	Page        int64 `json:"page"`
 	Size        int64 `json:"size"`
-	TakeAll     bool  `json:"take_all"`
 	TotalCount  int64 `json:"total_count"`
 	TotalPages  int64 `json:"total_pages"`
 	HasPrevious bool  `json:"has_previous"`
 	HasNext     bool  `json:"has_next"`
+
+	// Set in cursor mode only, where the total counts are not computed.
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
 }
 
 func NewPagination(page, size, total int64) *Pagination {
@@ -35,3 +41,23 @@ func NewPagination(page, size, total int64) *Pagination {
 	}
 	return p
 }
+
+// NewCursorPagination returns the metadata of a page of keyset pagination,
+// which has no page numbers or total counts.
+func NewCursorPagination(page cursor.Page) *Pagination {
+	return &Pagination{
+		Size:        page.Size,
+		NextCursor:  page.NextCursor,
+		PrevCursor:  page.PrevCursor,
+		HasNext:     page.NextCursor != "",
+		HasPrevious: page.PrevCursor != "",
+	}
+}
+
+// PageSize returns size, or DefaultPageSize when size is not set.
+func PageSize(size int64) int64 {
+	if size <= 0 {
+		return DefaultPageSize
+	}
+	return size
+}
@@ -0,0 +1,50 @@
+// Package dbpool sizes connection pools and connects them at startup the
+// same way for every blueprint, from the DB_* settings of config.Config.
+package dbpool
+
+import (
+	"context"
+	"database/sql"
+	"db_blueprints/config"
+	"fmt"
+	"log"
+	"time"
+)
+
+// MaxBackoff caps the wait between two connection attempts.
+const MaxBackoff = time.Second * 30
+
+// Configure applies the pool settings of cfg to db.
+func Configure(db *sql.DB, cfg *config.Config) {
+	db.SetMaxOpenConns(cfg.DB_MAX_OPEN_CONNS)
+	db.SetMaxIdleConns(cfg.DB_MAX_IDLE_CONNS)
+	db.SetConnMaxLifetime(cfg.DB_CONN_MAX_LIFETIME)
+	db.SetConnMaxIdleTime(cfg.DB_CONN_MAX_IDLE_TIME)
+	if cfg.Driver() == config.DriverSQLite {
+		// SQLite allows a single writer; one connection avoids "database is
+		// locked" errors under concurrent requests.
+		db.SetMaxOpenConns(1)
+	}
+}
+
+// Connect calls connect until it succeeds, retrying up to DB_CONNECT_RETRIES
+// times with an exponential backoff that starts at DB_CONNECT_BACKOFF. It
+// returns the last error once the retries run out or ctx is done.
+func Connect(ctx context.Context, cfg *config.Config, connect func(ctx context.Context) error) error {
+	backoff := cfg.DB_CONNECT_BACKOFF
+	for attempt := 0; ; attempt++ {
+		err := connect(ctx)
+		if err == nil || attempt >= cfg.DB_CONNECT_RETRIES {
+			return err
+		}
+
+		log.Printf("Cannot connect to database (attempt %d of %d), retrying in %s: %v",
+			attempt+1, cfg.DB_CONNECT_RETRIES+1, backoff, err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w (%w)", err, ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, MaxBackoff)
+	}
+}
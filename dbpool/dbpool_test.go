@@ -0,0 +1,90 @@
+package dbpool
+
+import (
+	"context"
+	"database/sql"
+	"db_blueprints/config"
+	"errors"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestConnect(t *testing.T) {
+	errDown := errors.New("connection refused")
+
+	tests := []struct {
+		name     string
+		retries  int
+		failures int
+		err      error
+		attempts int
+	}{
+		{name: "first attempt", retries: 3, attempts: 1},
+		{name: "after retries", retries: 3, failures: 2, attempts: 3},
+		{name: "retries run out", retries: 2, failures: 5, err: errDown, attempts: 3},
+		{name: "no retries", failures: 1, err: errDown, attempts: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{DB_CONNECT_RETRIES: tt.retries, DB_CONNECT_BACKOFF: time.Millisecond}
+
+			attempts := 0
+			err := Connect(context.Background(), cfg, func(ctx context.Context) error {
+				attempts++
+				if attempts <= tt.failures {
+					return errDown
+				}
+				return nil
+			})
+			if !errors.Is(err, tt.err) {
+				t.Errorf("Connect() error = %v, want %v", err, tt.err)
+			}
+			if attempts != tt.attempts {
+				t.Errorf("connect called %d times, want %d", attempts, tt.attempts)
+			}
+		})
+	}
+}
+
+func TestConnectStopsWhenCancelled(t *testing.T) {
+	cfg := &config.Config{DB_CONNECT_RETRIES: 10, DB_CONNECT_BACKOFF: time.Hour}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	errDown := errors.New("connection refused")
+	err := Connect(ctx, cfg, func(ctx context.Context) error {
+		cancel()
+		return errDown
+	})
+	if !errors.Is(err, errDown) || !errors.Is(err, context.Canceled) {
+		t.Errorf("Connect() error = %v, want the last error and context.Canceled", err)
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	tests := []struct {
+		driver  string
+		maxOpen int
+	}{
+		{driver: config.DriverMySQL, maxOpen: 20},
+		{driver: config.DriverPostgres, maxOpen: 20},
+		{driver: config.DriverSQLite, maxOpen: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.driver, func(t *testing.T) {
+			db, err := sql.Open("sqlite3", ":memory:")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			Configure(db, &config.Config{DB_DRIVER: tt.driver, DB_MAX_OPEN_CONNS: 20, DB_MAX_IDLE_CONNS: 5})
+			if got := db.Stats().MaxOpenConnections; got != tt.maxOpen {
+				t.Errorf("max open connections = %d, want %d", got, tt.maxOpen)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package dbpool
+
+import (
+	"context"
+	"database/sql"
+	"db_blueprints/config"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CheckTimeout bounds the ping of one replica.
+const CheckTimeout = time.Second * 2
+
+type primaryKey struct{}
+
+// WithPrimary returns a copy of ctx whose reads go to the primary, so they
+// see the writes made before them even when the replicas lag behind.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// UsesPrimary reports whether ctx was returned by WithPrimary.
+func UsesPrimary(ctx context.Context) bool {
+	primary, _ := ctx.Value(primaryKey{}).(bool)
+	return primary
+}
+
+// Replica is the state of a read replica.
+type Replica struct {
+	Addr    string `json:"addr"`
+	Healthy bool   `json:"healthy"`
+	// Error is why the last check failed.
+	Error string `json:"error,omitempty"`
+}
+
+// Replicas balances reads over the read replicas that answer their health
+// checks.
+type Replicas struct {
+	addrs   []string
+	dbs     []*sql.DB
+	healthy []atomic.Bool
+	next    atomic.Uint64
+
+	mu     sync.Mutex
+	errors []error
+
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+// OpenReplicas opens a pool with driverName for every DB_REPLICAS entry,
+// checks them once and keeps checking them every DB_REPLICA_CHECK_INTERVAL
+// until Close. It returns nil when there is no replica. A replica that is
+// down does not fail OpenReplicas; it only gets no reads.
+func OpenReplicas(cfg *config.Config, driverName string) (*Replicas, error) {
+	addrs := cfg.ReplicaAddrs()
+	if len(addrs) == 0 {
+		return nil, nil
+	}
+	if cfg.Driver() == config.DriverSQLite {
+		return nil, errors.New("DB_REPLICAS is not supported with sqlite")
+	}
+
+	r := &Replicas{
+		addrs:   addrs,
+		healthy: make([]atomic.Bool, len(addrs)),
+		errors:  make([]error, len(addrs)),
+		done:    make(chan struct{}),
+	}
+	for _, addr := range addrs {
+		db, err := sql.Open(driverName, cfg.ReplicaDSN(addr))
+		if err != nil {
+			r.closeDBs()
+			return nil, fmt.Errorf("open replica %s: %w", addr, err)
+		}
+		Configure(db, cfg)
+		r.dbs = append(r.dbs, db)
+	}
+
+	// Assumed up until checked, so only the replicas that are down get
+	// logged.
+	for i := range r.healthy {
+		r.healthy[i].Store(true)
+	}
+	r.check(context.Background())
+
+	ctx, stop := context.WithCancel(context.Background())
+	r.stop = stop
+	go r.watch(ctx, cfg.DB_REPLICA_CHECK_INTERVAL)
+	return r, nil
+}
+
+// Len returns the number of replicas, healthy or not.
+func (r *Replicas) Len() int {
+	return len(r.dbs)
+}
+
+// DB returns the pool of replica i.
+func (r *Replicas) DB(i int) *sql.DB {
+	return r.dbs[i]
+}
+
+// Pick returns the index of the next healthy replica, in turn, or -1 when
+// none is healthy and reads should fail over to the primary.
+func (r *Replicas) Pick() int {
+	start := r.next.Add(1)
+	for n := range uint64(len(r.dbs)) {
+		i := int((start + n) % uint64(len(r.dbs)))
+		if r.healthy[i].Load() {
+			return i
+		}
+	}
+	return -1
+}
+
+// State returns the state of every replica as of its last check.
+func (r *Replicas) State() []Replica {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	state := make([]Replica, len(r.dbs))
+	for i, addr := range r.addrs {
+		state[i] = Replica{Addr: addr, Healthy: r.healthy[i].Load()}
+		if r.errors[i] != nil {
+			state[i].Error = r.errors[i].Error()
+		}
+	}
+	return state
+}
+
+// Close stops the health checks and closes the replica pools.
+func (r *Replicas) Close() error {
+	r.stop()
+	<-r.done
+	return r.closeDBs()
+}
+
+func (r *Replicas) closeDBs() error {
+	var errs []error
+	for _, db := range r.dbs {
+		errs = append(errs, db.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func (r *Replicas) watch(ctx context.Context, interval time.Duration) {
+	defer close(r.done)
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.check(ctx)
+		}
+	}
+}
+
+// check pings every replica at once and logs the ones that went down or came
+// back up.
+func (r *Replicas) check(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i, db := range r.dbs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			pingCtx, cancel := context.WithTimeout(ctx, CheckTimeout)
+			err := db.PingContext(pingCtx)
+			cancel()
+			if ctx.Err() != nil {
+				return
+			}
+
+			r.mu.Lock()
+			r.errors[i] = err
+			r.mu.Unlock()
+
+			switch was := r.healthy[i].Swap(err == nil); {
+			case err != nil && was:
+				log.Printf("Replica %s is down, reads fail over: %v", r.addrs[i], err)
+			case err == nil && !was:
+				log.Printf("Replica %s is up", r.addrs[i])
+			}
+		}()
+	}
+	wg.Wait()
+}
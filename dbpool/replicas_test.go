@@ -0,0 +1,103 @@
+package dbpool
+
+import (
+	"context"
+	"database/sql"
+	"db_blueprints/config"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+// newReplicas returns Replicas over n in-memory databases, all healthy and
+// not watched.
+func newReplicas(t *testing.T, n int) *Replicas {
+	t.Helper()
+
+	r := &Replicas{
+		healthy: make([]atomic.Bool, n),
+		errors:  make([]error, n),
+	}
+	for i := range n {
+		db, err := sql.Open("sqlite3", ":memory:")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		r.addrs = append(r.addrs, fmt.Sprintf("replica-%d:3306", i))
+		r.dbs = append(r.dbs, db)
+		r.healthy[i].Store(true)
+	}
+	return r
+}
+
+// picks returns the replicas n calls to Pick return.
+func picks(r *Replicas, n int) []int {
+	var got []int
+	for range n {
+		got = append(got, r.Pick())
+	}
+	return got
+}
+
+func TestReplicasFailOver(t *testing.T) {
+	ctx := context.Background()
+	r := newReplicas(t, 3)
+
+	r.check(ctx)
+	if got := picks(r, 6); !sameCounts(got, map[int]int{0: 2, 1: 2, 2: 2}) {
+		t.Errorf("Pick() over healthy replicas = %v, want each twice", got)
+	}
+
+	r.dbs[1].Close()
+	r.check(ctx)
+	for _, i := range picks(r, 6) {
+		if i != 0 && i != 2 {
+			t.Fatalf("Pick() with replica 1 down = %d, want 0 or 2", i)
+		}
+	}
+	state := r.State()
+	if state[1].Healthy || state[1].Error == "" {
+		t.Errorf("state of the closed replica = %+v, want unhealthy with an error", state[1])
+	}
+	if !state[0].Healthy || state[0].Error != "" {
+		t.Errorf("state of an open replica = %+v, want healthy", state[0])
+	}
+
+	r.dbs[0].Close()
+	r.dbs[2].Close()
+	r.check(ctx)
+	if got := r.Pick(); got != -1 {
+		t.Errorf("Pick() with every replica down = %d, want -1", got)
+	}
+}
+
+func sameCounts(picks []int, want map[int]int) bool {
+	got := map[int]int{}
+	for _, i := range picks {
+		got[i]++
+	}
+	return reflect.DeepEqual(got, want)
+}
+
+func TestOpenReplicas(t *testing.T) {
+	r, err := OpenReplicas(&config.Config{DB_DRIVER: config.DriverMySQL}, "mysql")
+	if r != nil || err != nil {
+		t.Errorf("OpenReplicas() without DB_REPLICAS = %v, %v, want nil, nil", r, err)
+	}
+
+	r, err = OpenReplicas(&config.Config{DB_DRIVER: config.DriverSQLite, DB_REPLICAS: "a,b"}, "sqlite3")
+	if r != nil || err == nil {
+		t.Errorf("OpenReplicas() on sqlite = %v, %v, want an error", r, err)
+	}
+}
+
+func TestWithPrimary(t *testing.T) {
+	if UsesPrimary(context.Background()) {
+		t.Error("a plain context uses the primary")
+	}
+	if !UsesPrimary(WithPrimary(context.Background())) {
+		t.Error("WithPrimary() does not use the primary")
+	}
+}
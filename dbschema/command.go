@@ -0,0 +1,96 @@
+package dbschema
+
+import (
+	"context"
+	"database/sql"
+	"db_blueprints/migration"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Usage documents the arguments accepted by Command.
+const Usage = `usage: schema diff [-stand-in]
+
+  diff         compare the database schema with the models
+  -stand-in    check an in-memory SQLite database built from the embedded
+               migrations instead of the configured database`
+
+// ErrDrift is returned by Command when the schema differs from the models,
+// so callers can exit non-zero in CI.
+var ErrDrift = errors.New("schema drift detected")
+
+// Command runs the schema subcommand described by args against db, whose
+// dialect is one of the config.Driver values. db may be nil when -stand-in
+// is given.
+func Command(ctx context.Context, db *sql.DB, dialect string, expected []Table, args []string, out io.Writer) error {
+	if len(args) == 0 || args[0] != "diff" {
+		return fmt.Errorf("unknown or missing command\n%s", Usage)
+	}
+
+	flags := flag.NewFlagSet("schema diff", flag.ContinueOnError)
+	flags.SetOutput(out)
+	standIn := flags.Bool("stand-in", false, "check an in-memory SQLite database built from the migrations")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	if *standIn {
+		standInDB, err := StandIn(ctx)
+		if err != nil {
+			return err
+		}
+		defer standInDB.Close()
+		db, dialect = standInDB, "sqlite"
+	}
+	if db == nil {
+		return fmt.Errorf("no database connection, use -stand-in to check the migrations alone")
+	}
+
+	names := make([]string, len(expected))
+	for i, table := range expected {
+		names[i] = table.Name
+	}
+
+	actual, err := Inspect(ctx, db, dialect, names...)
+	if err != nil {
+		return err
+	}
+
+	diffs := Diff(expected, actual)
+	for _, d := range diffs {
+		fmt.Fprintln(out, d)
+	}
+	if len(diffs) > 0 {
+		return fmt.Errorf("%w: %d difference(s)", ErrDrift, len(diffs))
+	}
+
+	fmt.Fprintln(out, "schema matches the models")
+	return nil
+}
+
+// StandIn opens an in-memory SQLite database and applies the embedded
+// SQLite migrations to it. It needs cgo.
+func StandIn(ctx context.Context) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", "file:dbschema?mode=memory&cache=shared&_foreign_keys=on")
+	if err != nil {
+		return nil, fmt.Errorf("open stand-in database: %w", err)
+	}
+	// The in-memory database lives as long as its only connection.
+	db.SetMaxOpenConns(1)
+	db.SetConnMaxLifetime(0)
+
+	migrator, err := migration.New(db, "sqlite")
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := migrator.Up(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrate stand-in database: %w", err)
+	}
+	return db, nil
+}
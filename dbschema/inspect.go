@@ -0,0 +1,137 @@
+package dbschema
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Inspect reads the columns and indexes of the named tables from db. Tables
+// that do not exist are left out of the result. dialect is one of the
+// config.Driver values.
+func Inspect(ctx context.Context, db *sql.DB, dialect string, tables ...string) (map[string]*Table, error) {
+	var inspector interface {
+		columns(ctx context.Context, db *sql.DB, table string) ([]Column, error)
+		indexes(ctx context.Context, db *sql.DB, table string) ([]Index, error)
+	}
+	switch dialect {
+	case "mysql":
+		inspector = mysqlInspector{}
+	case "postgres":
+		inspector = postgresInspector{}
+	case "sqlite":
+		inspector = sqliteInspector{}
+	default:
+		return nil, fmt.Errorf("cannot inspect dialect %q", dialect)
+	}
+
+	result := make(map[string]*Table, len(tables))
+	for _, name := range tables {
+		columns, err := inspector.columns(ctx, db, name)
+		if err != nil {
+			return nil, fmt.Errorf("inspect columns of %s: %w", name, err)
+		}
+		if len(columns) == 0 {
+			continue
+		}
+
+		indexes, err := inspector.indexes(ctx, db, name)
+		if err != nil {
+			return nil, fmt.Errorf("inspect indexes of %s: %w", name, err)
+		}
+		result[name] = &Table{Name: name, Columns: columns, Indexes: indexes}
+	}
+	return result, nil
+}
+
+type mysqlInspector struct{}
+
+func (mysqlInspector) columns(ctx context.Context, db *sql.DB, table string) ([]Column, error) {
+	return queryColumns(ctx, db, `SELECT column_name, column_type FROM information_schema.columns
+WHERE table_schema = DATABASE() AND table_name = ? ORDER BY ordinal_position`, table)
+}
+
+func (mysqlInspector) indexes(ctx context.Context, db *sql.DB, table string) ([]Index, error) {
+	return queryIndexes(ctx, db, `SELECT index_name, column_name, non_unique = 0 FROM information_schema.statistics
+WHERE table_schema = DATABASE() AND table_name = ? ORDER BY index_name, seq_in_index`, table)
+}
+
+type postgresInspector struct{}
+
+func (postgresInspector) columns(ctx context.Context, db *sql.DB, table string) ([]Column, error) {
+	return queryColumns(ctx, db, `SELECT column_name, data_type FROM information_schema.columns
+WHERE table_schema = current_schema() AND table_name = $1 ORDER BY ordinal_position`, table)
+}
+
+// indexes lists plain column indexes. Expression indexes, such as the
+// full-text ones, have no columns and are skipped.
+func (postgresInspector) indexes(ctx context.Context, db *sql.DB, table string) ([]Index, error) {
+	return queryIndexes(ctx, db, `SELECT i.relname, a.attname, ix.indisunique
+FROM pg_index ix
+JOIN pg_class t ON t.oid = ix.indrelid
+JOIN pg_class i ON i.oid = ix.indexrelid
+JOIN pg_namespace n ON n.oid = t.relnamespace
+JOIN pg_attribute a ON a.attrelid = t.oid AND a.attnum = ANY(ix.indkey)
+WHERE n.nspname = current_schema() AND t.relname = $1
+ORDER BY i.relname, array_position(ix.indkey::int2[], a.attnum)`, table)
+}
+
+type sqliteInspector struct{}
+
+func (sqliteInspector) columns(ctx context.Context, db *sql.DB, table string) ([]Column, error) {
+	return queryColumns(ctx, db, `SELECT name, type FROM pragma_table_info(?) ORDER BY cid`, table)
+}
+
+// indexes includes the automatic indexes SQLite creates for UNIQUE and
+// PRIMARY KEY constraints.
+func (sqliteInspector) indexes(ctx context.Context, db *sql.DB, table string) ([]Index, error) {
+	return queryIndexes(ctx, db, `SELECT il.name, ii.name, il."unique"
+FROM pragma_index_list(?) il, pragma_index_info(il.name) ii
+ORDER BY il.name, ii.seqno`, table)
+}
+
+func queryColumns(ctx context.Context, db *sql.DB, query string, table string) ([]Column, error) {
+	rows, err := db.QueryContext(ctx, query, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []Column
+	for rows.Next() {
+		var name, dbType string
+		if err := rows.Scan(&name, &dbType); err != nil {
+			return nil, err
+		}
+		columns = append(columns, Column{Name: name, Type: Normalize(dbType)})
+	}
+	return columns, rows.Err()
+}
+
+// queryIndexes groups (index name, column name, unique) rows, ordered by
+// index and column position, into indexes.
+func queryIndexes(ctx context.Context, db *sql.DB, query string, table string) ([]Index, error) {
+	rows, err := db.QueryContext(ctx, query, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var indexes []Index
+	for rows.Next() {
+		var (
+			name, column string
+			unique       bool
+		)
+		if err := rows.Scan(&name, &column, &unique); err != nil {
+			return nil, err
+		}
+
+		if n := len(indexes); n > 0 && indexes[n-1].Name == name {
+			indexes[n-1].Columns = append(indexes[n-1].Columns, column)
+			continue
+		}
+		indexes = append(indexes, Index{Name: name, Columns: []string{column}, Unique: unique})
+	}
+	return indexes, rows.Err()
+}
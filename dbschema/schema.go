@@ -0,0 +1,169 @@
+// Package dbschema compares the tables the code expects with the schema of
+// a live database, so drift between the models and the SQL migrations is
+// caught before it shows up as a runtime error.
+package dbschema
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Type is the portable kind of a column. Dialect types are normalised onto
+// it, see Normalize.
+type Type string
+
+const (
+	TypeInt    Type = "int"
+	TypeFloat  Type = "float"
+	TypeString Type = "string"
+	TypeTime   Type = "time"
+	TypeBool   Type = "bool"
+	TypeBytes  Type = "bytes"
+	// TypeAny is used when the expected type is not known, as with the
+	// db_sql column lists. It never causes a type mismatch.
+	TypeAny Type = ""
+)
+
+type Column struct {
+	Name string
+	Type Type
+}
+
+type Index struct {
+	Name    string
+	Columns []string
+	Unique  bool
+}
+
+type Table struct {
+	Name    string
+	Columns []Column
+	Indexes []Index
+}
+
+func (t *Table) column(name string) (Column, bool) {
+	for _, c := range t.Columns {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Column{}, false
+}
+
+// covers reports whether t has an index over exactly columns, in order, that
+// is unique when unique is set.
+func (t *Table) covers(columns []string, unique bool) bool {
+	for _, idx := range t.Indexes {
+		if slices.Equal(idx.Columns, columns) && (idx.Unique || !unique) {
+			return true
+		}
+	}
+	return false
+}
+
+// Normalize maps a column type as reported by MySQL, PostgreSQL or SQLite
+// onto a Type. Unknown types map to TypeAny.
+func Normalize(dbType string) Type {
+	t := strings.ToUpper(dbType)
+	switch {
+	case strings.Contains(t, "BOOL"):
+		return TypeBool
+	case strings.Contains(t, "TIME"), strings.Contains(t, "DATE"):
+		return TypeTime
+	case strings.Contains(t, "INT"), strings.Contains(t, "SERIAL"):
+		return TypeInt
+	case strings.Contains(t, "CHAR"), strings.Contains(t, "TEXT"), strings.Contains(t, "CLOB"):
+		return TypeString
+	case strings.Contains(t, "REAL"), strings.Contains(t, "FLOA"), strings.Contains(t, "DOUB"),
+		strings.Contains(t, "DEC"), strings.Contains(t, "NUMERIC"):
+		return TypeFloat
+	case strings.Contains(t, "BLOB"), strings.Contains(t, "BYTEA"), strings.Contains(t, "BINARY"):
+		return TypeBytes
+	default:
+		return TypeAny
+	}
+}
+
+// compatible reports whether a column of type actual can hold expected.
+// MySQL and SQLite store booleans as integers.
+func compatible(expected, actual Type) bool {
+	switch {
+	case expected == TypeAny, actual == TypeAny, expected == actual:
+		return true
+	case expected == TypeBool && actual == TypeInt:
+		return true
+	default:
+		return false
+	}
+}
+
+// Kind classifies a Difference.
+type Kind string
+
+const (
+	MissingTable  Kind = "missing table"
+	MissingColumn Kind = "missing column"
+	ExtraColumn   Kind = "extra column"
+	TypeMismatch  Kind = "type mismatch"
+	MissingIndex  Kind = "missing index"
+)
+
+// Difference is one way the database does not match what the code expects.
+type Difference struct {
+	Table  string
+	Kind   Kind
+	Detail string
+}
+
+func (d Difference) String() string {
+	return fmt.Sprintf("%s: %s: %s", d.Table, d.Kind, d.Detail)
+}
+
+// Diff compares the expected tables with the actual schema, keyed by table
+// name. Indexes are matched by their columns rather than their names, which
+// differ between dialects and tools.
+func Diff(expected []Table, actual map[string]*Table) []Difference {
+	var diffs []Difference
+	for _, want := range expected {
+		have, ok := actual[want.Name]
+		if !ok {
+			diffs = append(diffs, Difference{Table: want.Name, Kind: MissingTable, Detail: want.Name})
+			continue
+		}
+
+		for _, col := range want.Columns {
+			got, ok := have.column(col.Name)
+			switch {
+			case !ok:
+				diffs = append(diffs, Difference{Table: want.Name, Kind: MissingColumn, Detail: col.Name})
+			case !compatible(col.Type, got.Type):
+				diffs = append(diffs, Difference{
+					Table:  want.Name,
+					Kind:   TypeMismatch,
+					Detail: fmt.Sprintf("%s is %s, expected %s", col.Name, got.Type, col.Type),
+				})
+			}
+		}
+
+		for _, col := range have.Columns {
+			if _, ok := want.column(col.Name); !ok {
+				diffs = append(diffs, Difference{Table: want.Name, Kind: ExtraColumn, Detail: col.Name})
+			}
+		}
+
+		for _, idx := range want.Indexes {
+			if !have.covers(idx.Columns, idx.Unique) {
+				detail := fmt.Sprintf("%s (%s)", idx.Name, strings.Join(idx.Columns, ", "))
+				if idx.Unique {
+					detail = "unique " + detail
+				}
+				diffs = append(diffs, Difference{Table: want.Name, Kind: MissingIndex, Detail: detail})
+			}
+		}
+	}
+
+	sort.SliceStable(diffs, func(i, j int) bool { return diffs[i].Table < diffs[j].Table })
+	return diffs
+}
@@ -0,0 +1,76 @@
+// Package etag maps row versions to HTTP entity tags, for conditional GET and
+// PUT requests.
+package etag
+
+import (
+	"db_blueprints/apperror"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Format returns the strong entity tag of a row version, e.g. "3".
+func Format(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// Set sends version as the ETag of the response.
+func Set(c *gin.Context, version int64) {
+	c.Header("ETag", Format(version))
+}
+
+// NotModified answers a conditional GET whose If-None-Match already names
+// version with 304 and reports whether it did.
+func NotModified(c *gin.Context, version int64) bool {
+	header := c.GetHeader("If-None-Match")
+	if header == "" {
+		return false
+	}
+
+	tag := Format(version)
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == tag {
+			Set(c, version)
+			c.Status(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// IfMatch returns the version named by the If-Match header. ok is false when
+// the header is missing or "*", which any current version satisfies. A tag
+// that is not one of ours can never match and is reported as a failed
+// precondition.
+func IfMatch(c *gin.Context) (version int64, ok bool, err error) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" || header == "*" {
+		return 0, false, nil
+	}
+	if strings.Contains(header, ",") {
+		return 0, false, apperror.Validation("If-Match must name a single entity tag")
+	}
+
+	value, quoted := strings.CutPrefix(header, `"`)
+	value, closed := strings.CutSuffix(value, `"`)
+	if quoted && closed {
+		if version, err := strconv.ParseInt(value, 10, 64); err == nil && version > 0 {
+			return version, true, nil
+		}
+	}
+	return 0, false, apperror.PreconditionFailed("If-Match %s does not match the current version", header)
+}
+
+// Precondition turns the version conflict of a write made with If-Match into
+// a failed precondition, answered with 412 instead of 409. Other errors are
+// returned unchanged.
+func Precondition(err error) error {
+	if !errors.Is(err, apperror.ErrVersionConflict) {
+		return err
+	}
+	return apperror.Wrap(apperror.CodePreconditionFailed, err, apperror.MessageOf(err))
+}
@@ -0,0 +1,107 @@
+package etag
+
+import (
+	"db_blueprints/apperror"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func testContext(header, value string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	if value != "" {
+		c.Request.Header.Set(header, value)
+	}
+	return c
+}
+
+func TestNotModified(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{header: "", want: false},
+		{header: `"3"`, want: true},
+		{header: `W/"3"`, want: true},
+		{header: `"1", "3"`, want: true},
+		{header: "*", want: true},
+		{header: `"2"`, want: false},
+		{header: `3`, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			c := testContext("If-None-Match", tt.header)
+			if got := NotModified(c, 3); got != tt.want {
+				t.Fatalf("NotModified() = %v, want %v", got, tt.want)
+			}
+			if tag := c.Writer.Header().Get("ETag"); tt.want && (c.Writer.Status() != http.StatusNotModified || tag != `"3"`) {
+				t.Errorf("got status %d and ETag %s, want 304 and \"3\"", c.Writer.Status(), tag)
+			}
+		})
+	}
+}
+
+func TestIfMatch(t *testing.T) {
+	tests := []struct {
+		header  string
+		version int64
+		ok      bool
+		err     error
+	}{
+		{header: ""},
+		{header: "*"},
+		{header: `"3"`, version: 3, ok: true},
+		{header: ` "3" `, version: 3, ok: true},
+		{header: `"1", "3"`, err: apperror.ErrValidation},
+		{header: `W/"3"`, err: apperror.ErrPreconditionFailed},
+		{header: `3`, err: apperror.ErrPreconditionFailed},
+		{header: `"0"`, err: apperror.ErrPreconditionFailed},
+		{header: `"abc"`, err: apperror.ErrPreconditionFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			c := testContext("If-Match", tt.header)
+			version, ok, err := IfMatch(c)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("IfMatch() error = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil || version != tt.version || ok != tt.ok {
+				t.Errorf("IfMatch() = %d, %v, %v, want %d, %v", version, ok, err, tt.version, tt.ok)
+			}
+		})
+	}
+}
+
+func TestPrecondition(t *testing.T) {
+	stale := fmt.Errorf("service: %w", apperror.VersionConflict("user with id 1 was modified since version 2"))
+
+	err := Precondition(stale)
+	if code := apperror.CodeOf(err); code != apperror.CodePreconditionFailed {
+		t.Errorf("code = %s, want %s", code, apperror.CodePreconditionFailed)
+	}
+	if code := apperror.CodeOf(err); code.HTTPStatus() != http.StatusPreconditionFailed {
+		t.Errorf("status = %d, want 412", code.HTTPStatus())
+	}
+	if msg := apperror.MessageOf(err); msg != "user with id 1 was modified since version 2" {
+		t.Errorf("message = %q", msg)
+	}
+
+	notFound := apperror.NotFound("user with id 1 not found")
+	if err := Precondition(notFound); err != notFound {
+		t.Errorf("Precondition(%v) = %v, want it unchanged", notFound, err)
+	}
+}
@@ -0,0 +1,151 @@
+// Package export writes rows to an HTTP response one at a time, so exports
+// run in constant memory regardless of the table size.
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	MIMENDJSON = "application/x-ndjson"
+	MIMECSV    = "text/csv"
+)
+
+// Formats are the content types an export can be negotiated to. The first
+// one is used when the client accepts anything.
+var Formats = []string{MIMENDJSON, MIMECSV}
+
+// flushEvery is how many rows are buffered before the response is flushed
+// to the client.
+const flushEvery = 100
+
+// Column is a CSV column. NDJSON rows are encoded from the item itself.
+type Column[T any] struct {
+	Header string
+	Value  func(*T) string
+}
+
+type Writer[T any] interface {
+	Write(item *T) error
+	// Close flushes buffered rows. For CSV it also writes the header when
+	// no row was written.
+	Close() error
+}
+
+// Disposition returns the Content-Disposition header for an export of name,
+// e.g. `attachment; filename="products.csv"`.
+func Disposition(name, format string) string {
+	ext := "ndjson"
+	if format == MIMECSV {
+		ext = "csv"
+	}
+	return fmt.Sprintf(`attachment; filename="%s.%s"`, name, ext)
+}
+
+// LiftWriteDeadline removes the server's write timeout from the response
+// being written to w. The timeout is sized for ordinary requests, while an
+// export takes as long as reading the table does.
+func LiftWriteDeadline(w http.ResponseWriter) error {
+	return http.NewResponseController(w).SetWriteDeadline(time.Time{})
+}
+
+// NewWriter returns a Writer for format, which must be one of Formats.
+func NewWriter[T any](format string, w io.Writer, columns []Column[T]) Writer[T] {
+	if format == MIMECSV {
+		return &csvWriter[T]{w: w, csv: csv.NewWriter(w), columns: columns}
+	}
+	return &ndjsonWriter[T]{w: w, enc: json.NewEncoder(w)}
+}
+
+type ndjsonWriter[T any] struct {
+	w     io.Writer
+	enc   *json.Encoder
+	count int
+}
+
+func (n *ndjsonWriter[T]) Write(item *T) error {
+	if err := n.enc.Encode(item); err != nil {
+		return err
+	}
+
+	n.count++
+	if n.count%flushEvery == 0 {
+		flush(n.w)
+	}
+	return nil
+}
+
+func (n *ndjsonWriter[T]) Close() error {
+	flush(n.w)
+	return nil
+}
+
+type csvWriter[T any] struct {
+	w       io.Writer
+	csv     *csv.Writer
+	columns []Column[T]
+	count   int
+	header  bool
+}
+
+func (c *csvWriter[T]) Write(item *T) error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+
+	record := make([]string, len(c.columns))
+	for i, col := range c.columns {
+		record[i] = col.Value(item)
+	}
+	if err := c.csv.Write(record); err != nil {
+		return err
+	}
+
+	c.count++
+	if c.count%flushEvery == 0 {
+		return c.flush()
+	}
+	return nil
+}
+
+func (c *csvWriter[T]) Close() error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	return c.flush()
+}
+
+// writeHeader is deferred to the first row so that a query failing before
+// any row is read can still be answered with an error status.
+func (c *csvWriter[T]) writeHeader() error {
+	if c.header {
+		return nil
+	}
+	c.header = true
+
+	header := make([]string, len(c.columns))
+	for i, col := range c.columns {
+		header[i] = col.Header
+	}
+	return c.csv.Write(header)
+}
+
+func (c *csvWriter[T]) flush() error {
+	c.csv.Flush()
+	if err := c.csv.Error(); err != nil {
+		return err
+	}
+	flush(c.w)
+	return nil
+}
+
+func flush(w io.Writer) {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,236 @@
+// Package filter parses list filters such as
+//
+//	price[gte]=10&price[lt]=50&owner_id[in]=1,2&created_at[after]=2024-01-01
+//
+// into a typed AST and compiles it into parameterised SQL conditions. Only
+// fields declared in a Schema are accepted, and their column names come from
+// the schema, never from the request.
+package filter
+
+import (
+	"db_blueprints/apperror"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Op string
+
+const (
+	OpEq     Op = "eq"
+	OpNe     Op = "ne"
+	OpGt     Op = "gt"
+	OpGte    Op = "gte"
+	OpLt     Op = "lt"
+	OpLte    Op = "lte"
+	OpIn     Op = "in"
+	OpLike   Op = "like"
+	OpBefore Op = "before"
+	OpAfter  Op = "after"
+)
+
+type Kind int
+
+const (
+	Int Kind = iota
+	Float
+	String
+	Time
+)
+
+// MaxInValues caps the number of values in an [in] list.
+const MaxInValues = 100
+
+// defaultOps are the operators allowed for a Kind when a Field does not list
+// its own.
+var defaultOps = map[Kind][]Op{
+	Int:    {OpEq, OpNe, OpGt, OpGte, OpLt, OpLte, OpIn},
+	Float:  {OpEq, OpNe, OpGt, OpGte, OpLt, OpLte},
+	String: {OpEq, OpNe, OpIn, OpLike},
+	Time:   {OpBefore, OpAfter, OpGte, OpLte},
+}
+
+var sqlOps = map[Op]string{
+	OpEq:     "=",
+	OpNe:     "<>",
+	OpGt:     ">",
+	OpGte:    ">=",
+	OpLt:     "<",
+	OpLte:    "<=",
+	OpBefore: "<",
+	OpAfter:  ">",
+}
+
+// Field is a filterable column.
+type Field struct {
+	Column string
+	Kind   Kind
+	Ops    []Op
+}
+
+func (f Field) allows(op Op) bool {
+	ops := f.Ops
+	if len(ops) == 0 {
+		ops = defaultOps[f.Kind]
+	}
+	return slices.Contains(ops, op)
+}
+
+// Schema whitelists the fields of an entity, keyed by query parameter name.
+type Schema map[string]Field
+
+// Condition is a single comparison. Values are already converted to the
+// field's Kind; only OpIn carries more than one.
+type Condition struct {
+	Field  string
+	Column string
+	Op     Op
+	Values []any
+}
+
+// Filter is a conjunction of conditions.
+type Filter struct {
+	Conditions []Condition
+}
+
+// Clause is a compiled condition, ready to be appended to a WHERE with AND.
+type Clause struct {
+	SQL  string
+	Args []any
+}
+
+var keyPattern = regexp.MustCompile(`^([a-z_][a-z0-9_]*)\[([a-z]+)\]$`)
+
+// Parse builds a Filter from query parameters. Keys of the form field[op]
+// must name a schema field and an operator it allows; a bare key that names
+// a schema field means field[eq]. Other bare keys (page, size, search, ...)
+// are ignored.
+func Parse(query url.Values, schema Schema) (*Filter, error) {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	f := &Filter{}
+	for _, key := range keys {
+		name, op := key, OpEq
+		if m := keyPattern.FindStringSubmatch(key); m != nil {
+			name, op = m[1], Op(m[2])
+		} else if strings.ContainsAny(key, "[]") {
+			return nil, apperror.Validation("invalid filter %q", key)
+		}
+
+		field, ok := schema[name]
+		if !ok {
+			if name == key {
+				continue
+			}
+			return nil, apperror.Validation("filtering by %q is not supported", name)
+		}
+		if !field.allows(op) {
+			return nil, apperror.Validation("operator %q is not supported for %q", op, name)
+		}
+
+		for _, raw := range query[key] {
+			values, err := parseValues(field.Kind, op, raw)
+			if err != nil {
+				return nil, apperror.Validation("invalid value for %s: %v", key, err)
+			}
+			f.Conditions = append(f.Conditions, Condition{Field: name, Column: field.Column, Op: op, Values: values})
+		}
+	}
+
+	return f, nil
+}
+
+// likeEscape is the escape character of like patterns. A backslash would need
+// different quoting on MySQL and PostgreSQL, and is the default escape of both,
+// so a neutral character is declared instead and backslashes match literally.
+const likeEscape = "!"
+
+var likeReplacer = strings.NewReplacer(likeEscape, likeEscape+likeEscape, "%", likeEscape+"%", "_", likeEscape+"_")
+
+// Clauses compiles f into SQL conditions using ? placeholders. The value of a
+// like condition is matched literally: its wildcards are escaped.
+func (f *Filter) Clauses() []Clause {
+	if f == nil {
+		return nil
+	}
+
+	clauses := make([]Clause, 0, len(f.Conditions))
+	for _, c := range f.Conditions {
+		switch c.Op {
+		case OpIn:
+			placeholders := strings.TrimSuffix(strings.Repeat("?,", len(c.Values)), ",")
+			clauses = append(clauses, Clause{SQL: fmt.Sprintf("%s IN (%s)", c.Column, placeholders), Args: c.Values})
+		case OpLike:
+			pattern := "%" + likeReplacer.Replace(c.Values[0].(string)) + "%"
+			clauses = append(clauses, Clause{SQL: c.Column + " LIKE ? ESCAPE '" + likeEscape + "'", Args: []any{pattern}})
+		default:
+			clauses = append(clauses, Clause{SQL: fmt.Sprintf("%s %s ?", c.Column, sqlOps[c.Op]), Args: c.Values})
+		}
+	}
+	return clauses
+}
+
+func parseValues(kind Kind, op Op, raw string) ([]any, error) {
+	if op != OpIn {
+		v, err := parseValue(kind, raw)
+		if err != nil {
+			return nil, err
+		}
+		return []any{v}, nil
+	}
+
+	parts := strings.Split(raw, ",")
+	if len(parts) > MaxInValues {
+		return nil, fmt.Errorf("at most %d values are allowed", MaxInValues)
+	}
+
+	values := make([]any, 0, len(parts))
+	for _, part := range parts {
+		v, err := parseValue(kind, strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func parseValue(kind Kind, raw string) (any, error) {
+	switch kind {
+	case Int:
+		v, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("expected an integer")
+		}
+		return v, nil
+	case Float:
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("expected a number")
+		}
+		return v, nil
+	case Time:
+		if t, err := time.Parse(time.RFC3339, raw); err == nil {
+			return t, nil
+		}
+		t, err := time.Parse(time.DateOnly, raw)
+		if err != nil {
+			return nil, fmt.Errorf("expected an RFC 3339 time or a YYYY-MM-DD date")
+		}
+		return t, nil
+	default:
+		if raw == "" {
+			return nil, fmt.Errorf("value must not be empty")
+		}
+		return raw, nil
+	}
+}
@@ -0,0 +1,249 @@
+package filter
+
+import (
+	"database/sql"
+	"db_blueprints/apperror"
+	"errors"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var testSchema = Schema{
+	"id":         {Column: "id", Kind: Int},
+	"price":      {Column: "price", Kind: Float},
+	"name":       {Column: "name", Kind: String},
+	"code":       {Column: "code", Kind: String, Ops: []Op{OpEq}},
+	"created_at": {Column: "created_at", Kind: Time},
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  []Condition
+	}{
+		{
+			name:  "bare key means eq",
+			query: "id=3",
+			want:  []Condition{{Field: "id", Column: "id", Op: OpEq, Values: []any{int64(3)}}},
+		},
+		{
+			name:  "unknown bare keys are ignored",
+			query: "page=2&size=10&search=pen",
+		},
+		{
+			name:  "conditions are sorted by key",
+			query: "price[lt]=50&price[gte]=10.5",
+			want: []Condition{
+				{Field: "price", Column: "price", Op: OpGte, Values: []any{10.5}},
+				{Field: "price", Column: "price", Op: OpLt, Values: []any{50.0}},
+			},
+		},
+		{
+			name:  "in list is trimmed",
+			query: "id[in]=1, 2,3",
+			want:  []Condition{{Field: "id", Column: "id", Op: OpIn, Values: []any{int64(1), int64(2), int64(3)}}},
+		},
+		{
+			name:  "repeated key gives one condition per value",
+			query: "name[ne]=a&name[ne]=b",
+			want: []Condition{
+				{Field: "name", Column: "name", Op: OpNe, Values: []any{"a"}},
+				{Field: "name", Column: "name", Op: OpNe, Values: []any{"b"}},
+			},
+		},
+		{
+			name:  "date",
+			query: "created_at[after]=2024-01-02",
+			want: []Condition{{Field: "created_at", Column: "created_at", Op: OpAfter,
+				Values: []any{time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)}}},
+		},
+		{
+			name:  "RFC 3339 time",
+			query: "created_at[before]=2024-01-02T03:04:05%2B02:00",
+			want: []Condition{{Field: "created_at", Column: "created_at", Op: OpBefore,
+				Values: []any{time.Date(2024, 1, 2, 3, 4, 5, 0, time.FixedZone("", 2*60*60))}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f, err := Parse(query, testSchema)
+			if err != nil {
+				t.Fatalf("Parse(%q) failed: %v", tt.query, err)
+			}
+			if len(f.Conditions) != len(tt.want) {
+				t.Fatalf("Parse(%q) = %+v, want %+v", tt.query, f.Conditions, tt.want)
+			}
+			for i, c := range f.Conditions {
+				if !equalCondition(c, tt.want[i]) {
+					t.Errorf("condition %d = %+v, want %+v", i, c, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	tooMany := make([]string, MaxInValues+1)
+	for i := range tooMany {
+		tooMany[i] = strconv.Itoa(i)
+	}
+
+	tests := []struct {
+		name  string
+		query url.Values
+	}{
+		{"unknown field", url.Values{"owner[eq]": {"1"}}},
+		{"unknown operator", url.Values{"id[between]": {"1"}}},
+		{"operator not allowed for kind", url.Values{"price[in]": {"1,2"}}},
+		{"like on a number", url.Values{"id[like]": {"1"}}},
+		{"operator not listed by field", url.Values{"code[ne]": {"a"}}},
+		{"malformed key", url.Values{"id[eq": {"1"}}},
+		{"nested key", url.Values{"id[eq][x]": {"1"}}},
+		{"upper case operator", url.Values{"id[EQ]": {"1"}}},
+		{"not an integer", url.Values{"id": {"one"}}},
+		{"not a number", url.Values{"price[gt]": {"cheap"}}},
+		{"empty string", url.Values{"name": {""}}},
+		{"bad value in list", url.Values{"id[in]": {"1,x"}}},
+		{"empty value in list", url.Values{"name[in]": {"a,,b"}}},
+		{"too many values in list", url.Values{"id[in]": {strings.Join(tooMany, ",")}}},
+		{"bad date", url.Values{"created_at[after]": {"2024-13-01"}}},
+		{"date without zero padding", url.Values{"created_at[after]": {"2024-1-2"}}},
+		{"time without zone", url.Values{"created_at[before]": {"2024-01-02T03:04:05"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := Parse(tt.query, testSchema)
+			if err == nil {
+				t.Fatalf("Parse(%v) = %+v, want an error", tt.query, f.Conditions)
+			}
+			if !errors.Is(err, apperror.ErrValidation) {
+				t.Errorf("Parse(%v) error = %v, want a validation error", tt.query, err)
+			}
+		})
+	}
+}
+
+func TestParseInLimit(t *testing.T) {
+	values := make([]string, MaxInValues)
+	for i := range values {
+		values[i] = strconv.Itoa(i)
+	}
+
+	f, err := Parse(url.Values{"id[in]": {strings.Join(values, ",")}}, testSchema)
+	if err != nil {
+		t.Fatalf("Parse with %d values failed: %v", MaxInValues, err)
+	}
+	if got := len(f.Conditions[0].Values); got != MaxInValues {
+		t.Errorf("got %d values, want %d", got, MaxInValues)
+	}
+}
+
+func TestClauses(t *testing.T) {
+	f := &Filter{Conditions: []Condition{
+		{Column: "id", Op: OpIn, Values: []any{int64(1), int64(2)}},
+		{Column: "name", Op: OpLike, Values: []any{"pen"}},
+		{Column: "name", Op: OpLike, Values: []any{`50%_off!\`}},
+		{Column: "price", Op: OpNe, Values: []any{2.5}},
+		{Column: "created_at", Op: OpBefore, Values: []any{"t"}},
+		{Column: "created_at", Op: OpAfter, Values: []any{"t"}},
+	}}
+	want := []Clause{
+		{SQL: "id IN (?,?)", Args: []any{int64(1), int64(2)}},
+		{SQL: "name LIKE ? ESCAPE '!'", Args: []any{"%pen%"}},
+		{SQL: "name LIKE ? ESCAPE '!'", Args: []any{`%50!%!_off!!\%`}},
+		{SQL: "price <> ?", Args: []any{2.5}},
+		{SQL: "created_at < ?", Args: []any{"t"}},
+		{SQL: "created_at > ?", Args: []any{"t"}},
+	}
+
+	if got := f.Clauses(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Clauses() = %+v, want %+v", got, want)
+	}
+	if got := (*Filter)(nil).Clauses(); got != nil {
+		t.Errorf("nil Filter gave %+v, want nil", got)
+	}
+}
+
+func TestLikeMatchesLiterally(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("CREATE TABLE items (name TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"50% off", "500 off", "a_b", "axb", `c\d`, "e!f"} {
+		if _, err := db.Exec("INSERT INTO items (name) VALUES (?)", name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{value: "0%", want: []string{"50% off"}},
+		{value: "_", want: []string{"a_b"}},
+		{value: `\`, want: []string{`c\d`}},
+		{value: "!", want: []string{"e!f"}},
+		{value: "off", want: []string{"50% off", "500 off"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			f := &Filter{Conditions: []Condition{{Column: "name", Op: OpLike, Values: []any{tt.value}}}}
+			clause := f.Clauses()[0]
+
+			rows, err := db.Query("SELECT name FROM items WHERE "+clause.SQL+" ORDER BY name", clause.Args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rows.Close()
+
+			var got []string
+			for rows.Next() {
+				var name string
+				if err := rows.Scan(&name); err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("like %q matched %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func equalCondition(a, b Condition) bool {
+	if a.Field != b.Field || a.Column != b.Column || a.Op != b.Op || len(a.Values) != len(b.Values) {
+		return false
+	}
+	for i := range a.Values {
+		at, aok := a.Values[i].(time.Time)
+		bt, bok := b.Values[i].(time.Time)
+		if aok && bok {
+			if !at.Equal(bt) {
+				return false
+			}
+		} else if a.Values[i] != b.Values[i] {
+			return false
+		}
+	}
+	return true
+}
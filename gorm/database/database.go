@@ -2,48 +2,85 @@ package database
 
 import (
 	"context"
+	"database/sql"
+	"db_blueprints/apperror"
 	"db_blueprints/config"
+	"db_blueprints/dbpool"
+	"errors"
 	"fmt"
+	"reflect"
+	"slices"
+	"strings"
 	"time"
 
 	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
 )
 
 const (
 	DatabaseTimeout = time.Second * 5
+	// VersionColumn is the column Update uses for optimistic locking.
+	VersionColumn = "version"
+	// StreamTimeout bounds a whole Stream call, which reads a table end to
+	// end and is expected to outlive DatabaseTimeout.
+	StreamTimeout = time.Minute * 10
 )
 
 type IDatabase interface {
 	GetDB() *gorm.DB
+	Replicas() *dbpool.Replicas
+	Observe(observe QueryObserver) error
 	AutoMigrate(models ...any) error
-	WithTransaction(function func() error) error
-	Create(ctx context.Context, doc any) error
-	CreateInBatches(ctx context.Context, docs any, batchSize int) error
-	Update(ctx context.Context, doc any) error
+	WithTransaction(ctx context.Context, function func(txDB IDatabase) error, opts ...*sql.TxOptions) error
+	Create(ctx context.Context, doc any, opts ...FindOption) error
+	CreateInBatches(ctx context.Context, docs any, batchSize int, opts ...FindOption) error
+	Update(ctx context.Context, doc any, opts ...FindOption) error
+	UpdateColumns(ctx context.Context, doc any, columns []string, opts ...FindOption) error
 	Delete(ctx context.Context, value any, opts ...FindOption) error
-	FindById(ctx context.Context, id int64, result any) error
+	DeleteWhere(ctx context.Context, model any, opts ...FindOption) (int64, error)
+	Restore(ctx context.Context, model any, opts ...FindOption) (int64, error)
+	FindById(ctx context.Context, id int64, result any, opts ...FindOption) error
 	FindOne(ctx context.Context, result any, opts ...FindOption) error
 	Find(ctx context.Context, result any, opts ...FindOption) error
 	Count(ctx context.Context, model any, total *int64, opts ...FindOption) error
+	Stream(ctx context.Context, dest any, fn func() error, opts ...FindOption) error
 }
 
 type Database struct {
-	db *gorm.DB
+	db   *gorm.DB
+	inTx bool
+
+	// replicas are the read replicas, and readers the sessions that run on
+	// them, in the same order.
+	replicas *dbpool.Replicas
+	readers  []*gorm.DB
 }
 
 func NewDatabase(config *config.Config) (*Database, error) {
-	// 1. Construct the Data Source Name (DSN) string from the config
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local",
-		config.DB_USER,
-		config.DB_PASSWORD,
-		config.DB_HOST,
-		config.DB_PORT,
-		config.DB_NAME,
-	)
-
-	// 2. Open the database connection
-	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
+	// 1. Pick the dialector for DB_DRIVER
+	var dialector gorm.Dialector
+	switch config.Driver() {
+	case "mysql":
+		dialector = mysql.Open(config.DSN())
+	case "postgres":
+		dialector = postgres.Open(config.DSN())
+	case "sqlite":
+		dialector = sqlite.Open(config.DSN())
+	default:
+		return nil, fmt.Errorf("unsupported DB_DRIVER %q", config.DB_DRIVER)
+	}
+
+	// 2. Open the database connection, retrying DB_CONNECT_RETRIES times
+	var db *gorm.DB
+	err := dbpool.Connect(context.Background(), config, func(ctx context.Context) error {
+		var err error
+		db, err = open(ctx, dialector, config)
+		return err
+	})
 	if err != nil {
 		// 3. If connection fails, return the error
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
@@ -54,30 +91,74 @@ func NewDatabase(config *config.Config) (*Database, error) {
 		db: db,
 	}
 
+	// 5. Reads go to the DB_REPLICAS, if any
+	if err := gormDB.openReplicas(config); err != nil {
+		gormDB.Close()
+		return nil, err
+	}
+
 	fmt.Println("Successfully connected to the database!")
 	return gormDB, nil
 }
 
-func (d *Database) AutoMigrate(models ...any) error {
-	return d.db.AutoMigrate(models...)
-}
+// open opens a gorm.DB on dialector, sizes its pool from the DB_* settings
+// and pings it. The pool is closed again when the ping fails.
+func open(ctx context.Context, dialector gorm.Dialector, config *config.Config) (*gorm.DB, error) {
+	db, err := gorm.Open(dialector, &gorm.Config{
+		// Lets wrapError recognise duplicate keys and foreign key violations.
+		TranslateError: true,
+		// Timestamps gorm fills in itself, such as deleted_at, are stored in
+		// UTC like the ones the models set.
+		NowFunc: func() time.Time { return time.Now().UTC() },
+		// Pinged below, once the pool is configured.
+		DisableAutomaticPing: true,
+	})
+	if err != nil {
+		return nil, err
+	}
 
-func (d *Database) WithTransaction(function func() error) error {
-	callback := func(db *gorm.DB) error {
-		return function()
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
 	}
+	dbpool.Configure(sqlDB, config)
 
-	tx := d.db.Begin()
-	if err := callback(tx); err != nil {
-		tx.Rollback()
-		return err
+	if err := sqlDB.PingContext(ctx); err != nil {
+		sqlDB.Close()
+		return nil, err
 	}
+	return db, nil
+}
 
-	if err := tx.Commit().Error; err != nil {
-		return err
+// Close closes the connection pools of the replicas and the primary. No new
+// query can start, and queries already running are waited for.
+func (d *Database) Close() error {
+	var replicaErr error
+	if d.replicas != nil {
+		replicaErr = d.replicas.Close()
 	}
 
-	return nil
+	sqlDB, err := d.db.DB()
+	if err != nil {
+		return errors.Join(replicaErr, err)
+	}
+	return errors.Join(replicaErr, sqlDB.Close())
+}
+
+func (d *Database) AutoMigrate(models ...any) error {
+	return d.db.AutoMigrate(models...)
+}
+
+// WithTransaction runs function inside a transaction and hands it a
+// transaction-scoped IDatabase. The transaction is committed when function
+// returns nil and rolled back when it returns an error or panics.
+//
+// When d is already transaction-scoped, or ctx carries a transaction (see
+// ContextWithTx), the call is nested and runs inside a SAVEPOINT instead.
+func (d *Database) WithTransaction(ctx context.Context, function func(txDB IDatabase) error, opts ...*sql.TxOptions) error {
+	return d.conn(ctx).WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return function(&Database{db: tx, inTx: true})
+	}, opts...)
 }
 
 func (d *Database) Preload(query string, args ...interface{}) IDatabase {
@@ -85,90 +166,318 @@ func (d *Database) Preload(query string, args ...interface{}) IDatabase {
 	return d
 }
 
-func (d *Database) Create(ctx context.Context, doc any) error {
-	ctx, cancel := context.WithTimeout(ctx, DatabaseTimeout)
+func (d *Database) Create(ctx context.Context, doc any, opts ...FindOption) error {
+	opt := getOption(opts...)
+	ctx, cancel := context.WithTimeout(ctx, opt.timeout)
 	defer cancel()
 
-	return d.db.Create(doc).Error
+	return wrapError(ctx, "create", opt.timeout, d.conn(ctx).WithContext(ctx).Create(doc).Error)
 }
 
-func (d *Database) CreateInBatches(ctx context.Context, docs any, batchSize int) error {
-	ctx, cancel := context.WithTimeout(ctx, DatabaseTimeout)
+// CreateInBatches inserts docs, a slice of models, with multi-row INSERTs of
+// up to batchSize rows and sets their primary keys.
+//
+// MySQL only reports the first key of a statement and gorm derives the
+// others from it, which innodb_autoinc_lock_mode = 2, the default since MySQL
+// 8.0, does not guarantee. There the rows are inserted one at a time.
+func (d *Database) CreateInBatches(ctx context.Context, docs any, batchSize int, opts ...FindOption) error {
+	opt := getOption(opts...)
+	ctx, cancel := context.WithTimeout(ctx, opt.timeout)
 	defer cancel()
 
-	return d.db.CreateInBatches(docs, batchSize).Error
+	conn := d.conn(ctx).WithContext(ctx)
+	if conn.Dialector.Name() == "mysql" {
+		batchSize = 1
+	}
+	return wrapError(ctx, "create in batches", opt.timeout, conn.CreateInBatches(docs, batchSize).Error)
+}
+
+// Update writes every column of doc, a pointer to a model, to its row.
+//
+// Models with a VersionColumn are updated optimistically: the row is only
+// written while its version still equals doc's, and the version is bumped in
+// the same statement and in doc. When another write got there first, Update
+// fails with apperror.ErrVersionConflict. Other models are saved as they are.
+func (d *Database) Update(ctx context.Context, doc any, opts ...FindOption) error {
+	return d.update(ctx, doc, nil, opts...)
 }
 
-func (d *Database) Update(ctx context.Context, doc any) error {
-	ctx, cancel := context.WithTimeout(ctx, DatabaseTimeout)
+// UpdateColumns is Update restricted to the named columns of doc, plus the
+// version and updated_at bookkeeping. It writes nothing when columns is
+// empty.
+func (d *Database) UpdateColumns(ctx context.Context, doc any, columns []string, opts ...FindOption) error {
+	if len(columns) == 0 {
+		return nil
+	}
+	return d.update(ctx, doc, columns, opts...)
+}
+
+// update writes the given columns of doc, or all of them when columns is nil.
+func (d *Database) update(ctx context.Context, doc any, columns []string, opts ...FindOption) error {
+	opt := getOption(opts...)
+	ctx, cancel := context.WithTimeout(ctx, opt.timeout)
 	defer cancel()
 
-	return d.db.Save(doc).Error
+	conn := d.conn(ctx).WithContext(ctx)
+	if err := conn.Statement.Parse(doc); err != nil {
+		return wrapError(ctx, "update", opt.timeout, err)
+	}
+	s := conn.Statement.Schema
+
+	field := s.LookUpField(VersionColumn)
+	if field == nil {
+		if columns == nil {
+			return wrapError(ctx, "update", opt.timeout, conn.Save(doc).Error)
+		}
+		return wrapError(ctx, "update", opt.timeout, conn.Model(doc).Select(selectColumns(s, columns)).Updates(doc).Error)
+	}
+
+	row := reflect.ValueOf(doc).Elem()
+	value, _ := field.ValueOf(ctx, row)
+	version, ok := value.(int64)
+	if !ok {
+		return fmt.Errorf("update: %s.%s must be an int64", s.Name, field.Name)
+	}
+	if err := field.Set(ctx, row, version+1); err != nil {
+		return err
+	}
+
+	query := conn.Model(doc)
+	if columns == nil {
+		query = query.Select("*").Omit(clause.Associations)
+	} else {
+		query = query.Select(selectColumns(s, append(columns[:len(columns):len(columns)], VersionColumn)))
+	}
+	result := query.Where(VersionColumn+" = ?", version).Updates(doc)
+	if result.Error == nil && result.RowsAffected == 1 {
+		return nil
+	}
+
+	_ = field.Set(ctx, row, version)
+	if result.Error != nil {
+		return wrapError(ctx, "update", opt.timeout, result.Error)
+	}
+	return d.versionConflict(ctx, s, row, version)
+}
+
+// selectColumns adds the model's auto-update timestamp to columns, which gorm
+// only maintains for selected columns.
+func selectColumns(s *schema.Schema, columns []string) []string {
+	for _, field := range s.Fields {
+		if field.AutoUpdateTime > 0 && !slices.Contains(columns, field.DBName) {
+			columns = append(columns[:len(columns):len(columns)], field.DBName)
+		}
+	}
+	return columns
+}
+
+// versionConflict explains why an optimistic update matched no row: either
+// the row is gone or another write got there first.
+func (d *Database) versionConflict(ctx context.Context, s *schema.Schema, row reflect.Value, version int64) error {
+	pk := s.PrioritizedPrimaryField
+	id, _ := pk.ValueOf(ctx, row)
+
+	var total int64
+	err := d.conn(ctx).WithContext(ctx).Model(reflect.New(s.ModelType).Interface()).Where(pk.DBName+" = ?", id).Count(&total).Error
+	if err != nil {
+		return wrapError(ctx, "update", DatabaseTimeout, err)
+	}
+	if total == 0 {
+		return apperror.NotFound("%s with id %v not found", strings.ToLower(s.Name), id)
+	}
+	return apperror.VersionConflict("%s with id %v was modified since version %d", strings.ToLower(s.Name), id, version)
 }
 
 func (d *Database) Delete(ctx context.Context, value any, opts ...FindOption) error {
-	ctx, cancel := context.WithTimeout(ctx, DatabaseTimeout)
+	opt := getOption(opts...)
+	ctx, cancel := context.WithTimeout(ctx, opt.timeout)
 	defer cancel()
 
-	query := d.applyOptions(opts...)
-	return query.Delete(value).Error
+	query := d.applyOptions(ctx, d.conn(ctx), opt)
+	return wrapError(ctx, "delete", opt.timeout, query.Delete(value).Error)
 }
 
-func (d *Database) FindById(ctx context.Context, id int64, result any) error {
-	ctx, cancel := context.WithTimeout(ctx, DatabaseTimeout)
+// DeleteWhere deletes the rows of model matching the WithQuery conditions and
+// returns how many were deleted. Soft-deletable models get deleted_at set
+// unless WithUnscoped is given.
+func (d *Database) DeleteWhere(ctx context.Context, model any, opts ...FindOption) (int64, error) {
+	opt := getOption(opts...)
+	ctx, cancel := context.WithTimeout(ctx, opt.timeout)
 	defer cancel()
 
-	if err := d.db.Where("id = ? ", id).First(result).Error; err != nil {
-		return err
+	result := d.applyOptions(ctx, d.conn(ctx), opt).Delete(model)
+	return result.RowsAffected, wrapError(ctx, "delete", opt.timeout, result.Error)
+}
+
+// Restore clears deleted_at on the soft-deleted rows of model matching the
+// WithQuery conditions and returns how many were restored.
+func (d *Database) Restore(ctx context.Context, model any, opts ...FindOption) (int64, error) {
+	opt := getOption(opts...)
+	ctx, cancel := context.WithTimeout(ctx, opt.timeout)
+	defer cancel()
+
+	result := d.applyOptions(ctx, d.conn(ctx), opt).Unscoped().Model(model).
+		Where("deleted_at IS NOT NULL").
+		Update("deleted_at", nil)
+	return result.RowsAffected, wrapError(ctx, "restore", opt.timeout, result.Error)
+}
+
+func (d *Database) FindById(ctx context.Context, id int64, result any, opts ...FindOption) error {
+	opt := getOption(opts...)
+	ctx, cancel := context.WithTimeout(ctx, opt.timeout)
+	defer cancel()
+
+	query := d.reader(ctx).WithContext(ctx)
+	if opt.unscoped {
+		query = query.Unscoped()
+	}
+
+	if err := query.Where("id = ? ", id).First(result).Error; err != nil {
+		return wrapError(ctx, "find by id", opt.timeout, err)
 	}
 
 	return nil
 }
 
 func (d *Database) FindOne(ctx context.Context, result any, opts ...FindOption) error {
-	ctx, cancel := context.WithTimeout(ctx, DatabaseTimeout)
+	opt := getOption(opts...)
+	ctx, cancel := context.WithTimeout(ctx, opt.timeout)
 	defer cancel()
 
-	query := d.applyOptions(opts...)
+	query := d.applyOptions(ctx, d.reader(ctx), opt)
 	if err := query.First(result).Error; err != nil {
-		return err
+		return wrapError(ctx, "find one", opt.timeout, err)
 	}
 
 	return nil
 }
 
 func (d *Database) Find(ctx context.Context, result any, opts ...FindOption) error {
-	ctx, cancel := context.WithTimeout(ctx, DatabaseTimeout)
+	opt := getOption(opts...)
+	ctx, cancel := context.WithTimeout(ctx, opt.timeout)
 	defer cancel()
 
-	query := d.applyOptions(opts...)
+	query := d.applyOptions(ctx, d.reader(ctx), opt)
 	if err := query.Find(result).Error; err != nil {
-		return err
+		return wrapError(ctx, "find", opt.timeout, err)
 	}
 
 	return nil
 }
 
 func (d *Database) Count(ctx context.Context, model any, total *int64, opts ...FindOption) error {
-	ctx, cancel := context.WithTimeout(ctx, DatabaseTimeout)
+	opt := getOption(opts...)
+	ctx, cancel := context.WithTimeout(ctx, opt.timeout)
 	defer cancel()
 
-	query := d.applyOptions(opts...)
+	query := d.applyOptions(ctx, d.reader(ctx), opt)
 	if err := query.Model(model).Count(total).Error; err != nil {
-		return err
+		return wrapError(ctx, "count", opt.timeout, err)
 	}
 
 	return nil
 }
 
+// Stream runs the query described by opts and scans the rows into dest one at
+// a time, calling fn after each row. Rows are read straight from the driver,
+// so memory use does not depend on the size of the result. dest must point to
+// a zero value (gorm turns a set primary key into a condition) and is reused
+// between rows; fn must copy it if it keeps a reference.
+//
+// The default timeout is StreamTimeout rather than DatabaseTimeout.
+func (d *Database) Stream(ctx context.Context, dest any, fn func() error, opts ...FindOption) error {
+	opt := getOption(append([]FindOption{WithTimeout(StreamTimeout)}, opts...)...)
+	ctx, cancel := context.WithTimeout(ctx, opt.timeout)
+	defer cancel()
+
+	query := d.applyOptions(ctx, d.reader(ctx), opt).Model(dest)
+	rows, err := query.Rows()
+	if err != nil {
+		return wrapError(ctx, "stream", opt.timeout, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := query.ScanRows(rows, dest); err != nil {
+			return wrapError(ctx, "stream", opt.timeout, err)
+		}
+		if err := fn(); err != nil {
+			return err
+		}
+	}
+
+	return wrapError(ctx, "stream", opt.timeout, rows.Err())
+}
+
 func (d *Database) GetDB() *gorm.DB {
 	return d.db
 }
 
-func (d *Database) applyOptions(opts ...FindOption) *gorm.DB {
-	query := d.db
+// conn returns the connection a call should run on: the transaction d is
+// bound to, the transaction propagated through ctx, or the pool.
+func (d *Database) conn(ctx context.Context) *gorm.DB {
+	if d.inTx {
+		return d.db
+	}
 
-	opt := getOption(opts...)
+	if txDB, ok := ctx.Value(txKey{}).(*Database); ok && txDB.inTx {
+		return txDB.db
+	}
+
+	return d.db
+}
+
+// reader returns the connection a read should run on: a healthy replica,
+// unless the call is part of a transaction or ctx asks for the primary (see
+// dbpool.WithPrimary). Reads fail over to the primary while no replica is
+// healthy.
+func (d *Database) reader(ctx context.Context) *gorm.DB {
+	conn := d.conn(ctx)
+	if d.replicas == nil || conn != d.db || dbpool.UsesPrimary(ctx) {
+		return conn
+	}
+	if i := d.replicas.Pick(); i >= 0 {
+		return d.readers[i]
+	}
+	return conn
+}
+
+// Replicas returns the read replicas, nil when there are none.
+func (d *Database) Replicas() *dbpool.Replicas {
+	return d.replicas
+}
+
+// openReplicas opens the DB_REPLICAS and a session on each of them. The
+// sessions share the dialect and callbacks of the primary.
+func (d *Database) openReplicas(config *config.Config) error {
+	driverName := "mysql"
+	if config.Driver() == "postgres" {
+		driverName = "pgx"
+	}
+	replicas, err := dbpool.OpenReplicas(config, driverName)
+	if err != nil || replicas == nil {
+		return err
+	}
+
+	d.replicas = replicas
+	for i := range replicas.Len() {
+		// A new Context gives the session its own Statement, whose pool
+		// can then be swapped without touching the primary's.
+		reader := d.db.Session(&gorm.Session{Context: context.Background()})
+		reader.Config.ConnPool = replicas.DB(i)
+		reader.Statement.ConnPool = replicas.DB(i)
+		d.readers = append(d.readers, reader)
+	}
+	return nil
+}
+
+// applyOptions builds the query described by opt on conn.
+func (d *Database) applyOptions(ctx context.Context, conn *gorm.DB, opt option) *gorm.DB {
+	query := conn.WithContext(ctx)
+
+	if opt.unscoped {
+		query = query.Unscoped()
+	}
 
 	if len(opt.preloads) != 0 {
 		for _, preload := range opt.preloads {
@@ -178,12 +487,25 @@ func (d *Database) applyOptions(opts ...FindOption) *gorm.DB {
 
 	if opt.query != nil {
 		for _, q := range opt.query {
-			query = query.Where(q.Query, q.Args)
+			query = query.Where(q.Query, q.Args...)
 		}
 	}
 
-	if opt.order != "" {
-		query = query.Order(opt.order)
+	// The ORDER BY is built as one expression: gorm drops the expression of
+	// an earlier Order call when merging, which would lose the relevance rank.
+	var order []clause.Expression
+	if opt.search != nil {
+		var rank clause.Expression
+		query, rank = opt.search.apply(query)
+		if rank != nil {
+			order = append(order, rank)
+		}
+	}
+	for _, spec := range opt.order {
+		order = append(order, spec.expr())
+	}
+	if len(order) != 0 {
+		query = query.Order(clause.OrderBy{Expression: clause.CommaExpression{Exprs: order}})
 	}
 
 	if opt.offset != 0 {
@@ -0,0 +1,64 @@
+package database
+
+import (
+	"context"
+	"db_blueprints/apperror"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrTimeout is matched by every *TimeoutError, so callers can test for it
+// with errors.Is without caring about the operation that timed out.
+var ErrTimeout = errors.New("database: operation timed out")
+
+// TimeoutError is returned when a call exceeds its deadline, either the
+// DatabaseTimeout default, a WithTimeout override or the caller's own ctx.
+type TimeoutError struct {
+	Op      string
+	Timeout time.Duration
+	Err     error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("database: %s timed out after %s: %v", e.Op, e.Timeout, e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+func (e *TimeoutError) Is(target error) bool {
+	return target == ErrTimeout || target == apperror.ErrTimeout || target == context.DeadlineExceeded
+}
+
+// wrapError turns driver errors caused by an expired ctx into a *TimeoutError
+// and translates gorm errors into apperror codes. Cancellation by the caller
+// and unrecognised errors are passed through unchanged.
+func wrapError(ctx context.Context, op string, timeout time.Duration, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return &TimeoutError{Op: op, Timeout: timeout, Err: err}
+	}
+
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return apperror.Wrap(apperror.CodeNotFound, err, "record not found")
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		return apperror.Wrap(apperror.CodeConflict, err, "record already exists")
+	case errors.Is(err, gorm.ErrForeignKeyViolated):
+		// The dialect reports both directions of a foreign key failure with
+		// the same error, so tell them apart by what the caller was doing.
+		if op == "delete" {
+			return apperror.Wrap(apperror.CodeConflict, err, "record is still referenced by other records")
+		}
+		return apperror.Wrap(apperror.CodeValidation, err, "referenced record does not exist")
+	}
+
+	return err
+}
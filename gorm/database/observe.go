@@ -0,0 +1,60 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const observeStartKey = "db_blueprints:observe_start"
+
+// QueryObserver is called after every statement once the database has
+// answered it, with the context of the statement, the time it started and its
+// error. A record that is not found is not an error.
+type QueryObserver func(ctx context.Context, start time.Time, err error)
+
+// Observe registers gorm callbacks that call observe after every statement,
+// on the primary and the replicas alike. It must be called before d is used.
+func (d *Database) Observe(observe QueryObserver) error {
+	before := func(db *gorm.DB) {
+		db.InstanceSet(observeStartKey, time.Now())
+	}
+	after := func(db *gorm.DB) {
+		start, ok := db.InstanceGet(observeStartKey)
+		if !ok {
+			return
+		}
+		err := db.Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			err = nil
+		}
+		observe(db.Statement.Context, start.(time.Time), err)
+	}
+
+	type registerer interface {
+		Register(name string, fn func(*gorm.DB)) error
+	}
+	cb := d.db.Callback()
+	hooks := []struct {
+		name          string
+		before, after registerer
+	}{
+		{"create", cb.Create().Before("*"), cb.Create().After("*")},
+		{"query", cb.Query().Before("*"), cb.Query().After("*")},
+		{"update", cb.Update().Before("*"), cb.Update().After("*")},
+		{"delete", cb.Delete().Before("*"), cb.Delete().After("*")},
+		{"row", cb.Row().Before("*"), cb.Row().After("*")},
+		{"raw", cb.Raw().Before("*"), cb.Raw().After("*")},
+	}
+	for _, hook := range hooks {
+		if err := hook.before.Register("observe:before_"+hook.name, before); err != nil {
+			return err
+		}
+		if err := hook.after.Register("observe:after_"+hook.name, after); err != nil {
+			return err
+		}
+	}
+	return nil
+}
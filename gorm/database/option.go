@@ -1,5 +1,10 @@
 package database
 
+import (
+	"db_blueprints/filter"
+	"time"
+)
+
 type Query struct {
 	Query string
 	Args  []any
@@ -12,16 +17,29 @@ func NewQuery(query string, args ...any) Query {
 	}
 }
 
+// NewFilterQueries compiles a parsed filter into queries for WithQuery.
+func NewFilterQueries(f *filter.Filter) []Query {
+	clauses := f.Clauses()
+	queries := make([]Query, 0, len(clauses))
+	for _, clause := range clauses {
+		queries = append(queries, NewQuery(clause.SQL, clause.Args...))
+	}
+	return queries
+}
+
 type FindOption interface {
 	apply(*option)
 }
 
 type option struct {
 	query    []Query
-	order    any
+	order    []SortSpec
 	offset   int
 	limit    int
 	preloads []string
+	search   *Search
+	timeout  time.Duration
+	unscoped bool
 }
 
 type optionFn func(*option)
@@ -48,9 +66,19 @@ func WithLimit(limit int) FindOption {
 	})
 }
 
-func WithOrder(order interface{}) FindOption {
+// WithOrder sorts by specs, in order. Specs come from a Sortable, so only
+// registered columns can reach the query.
+func WithOrder(specs ...SortSpec) FindOption {
+	return optionFn(func(opt *option) {
+		opt.order = specs
+	})
+}
+
+// WithSearch adds a text search, compiled for the dialect the query runs on.
+// Full-text searches are ordered by relevance ahead of WithOrder.
+func WithSearch(search Search) FindOption {
 	return optionFn(func(opt *option) {
-		opt.order = order
+		opt.search = &search
 	})
 }
 
@@ -60,12 +88,31 @@ func WithPreload(preloads []string) FindOption {
 	})
 }
 
+// WithTimeout overrides DatabaseTimeout for a single call. The deadline of
+// the caller's ctx still applies if it is shorter.
+func WithTimeout(timeout time.Duration) FindOption {
+	return optionFn(func(opt *option) {
+		if timeout > 0 {
+			opt.timeout = timeout
+		}
+	})
+}
+
+// WithUnscoped includes soft-deleted rows. On Delete it removes rows for good
+// instead of setting deleted_at.
+func WithUnscoped() FindOption {
+	return optionFn(func(opt *option) {
+		opt.unscoped = true
+	})
+}
+
 func getOption(opts ...FindOption) option {
 	opt := option{
-		query:  []Query{},
-		offset: 0,
-		limit:  1000,
-		order:  "id",
+		query:   []Query{},
+		offset:  0,
+		limit:   0,
+		order:   []SortSpec{{column: "id"}},
+		timeout: DatabaseTimeout,
 	}
 
 	for _, o := range opts {
@@ -0,0 +1,69 @@
+package database
+
+import (
+	"db_blueprints/dbschema"
+	"fmt"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm/schema"
+)
+
+// ModelTables describes the tables models map to, as gorm sees them, for
+// comparison with a live schema. Relationship fields have no column and are
+// skipped, as are full-text indexes, which are not plain column indexes on
+// every dialect.
+func ModelTables(models ...any) ([]dbschema.Table, error) {
+	cache := &sync.Map{}
+	tables := make([]dbschema.Table, 0, len(models))
+	for _, model := range models {
+		sch, err := schema.Parse(model, cache, schema.NamingStrategy{})
+		if err != nil {
+			return nil, fmt.Errorf("parse model %T: %w", model, err)
+		}
+
+		table := dbschema.Table{Name: sch.Table}
+		for _, field := range sch.Fields {
+			if field.DBName == "" {
+				continue
+			}
+			table.Columns = append(table.Columns, dbschema.Column{Name: field.DBName, Type: fieldType(field)})
+			if field.Unique {
+				table.Indexes = append(table.Indexes, dbschema.Index{Name: field.DBName, Columns: []string{field.DBName}, Unique: true})
+			}
+		}
+
+		for _, idx := range sch.ParseIndexes() {
+			if strings.EqualFold(idx.Class, "FULLTEXT") {
+				continue
+			}
+			index := dbschema.Index{Name: idx.Name, Unique: strings.EqualFold(idx.Class, "UNIQUE")}
+			for _, opt := range idx.Fields {
+				index.Columns = append(index.Columns, opt.DBName)
+			}
+			table.Indexes = append(table.Indexes, index)
+		}
+
+		tables = append(tables, table)
+	}
+	return tables, nil
+}
+
+func fieldType(field *schema.Field) dbschema.Type {
+	switch field.DataType {
+	case schema.Int, schema.Uint:
+		return dbschema.TypeInt
+	case schema.Float:
+		return dbschema.TypeFloat
+	case schema.String:
+		return dbschema.TypeString
+	case schema.Time:
+		return dbschema.TypeTime
+	case schema.Bool:
+		return dbschema.TypeBool
+	case schema.Bytes:
+		return dbschema.TypeBytes
+	default:
+		return dbschema.TypeAny
+	}
+}
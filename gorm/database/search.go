@@ -0,0 +1,99 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type SearchMode string
+
+const (
+	// SearchContains matches rows where any column contains the term.
+	SearchContains SearchMode = "contains"
+	// SearchPrefix matches rows where any column starts with the term.
+	SearchPrefix SearchMode = "prefix"
+	// SearchFullText uses the full-text index over the columns and orders
+	// results by relevance. Dialects without one fall back to contains.
+	SearchFullText SearchMode = "fulltext"
+)
+
+// Search is a case-insensitive text search over a fixed set of columns. It
+// is compiled for the dialect of the connection it runs on, see WithSearch.
+type Search struct {
+	Term    string
+	Mode    SearchMode
+	Columns []string
+}
+
+// likeEscape is the LIKE escape character. A backslash would need different
+// quoting on MySQL and PostgreSQL, so a neutral character is used instead.
+const likeEscape = "!"
+
+var likeReplacer = strings.NewReplacer(likeEscape, likeEscape+likeEscape, "%", likeEscape+"%", "_", likeEscape+"_")
+
+// apply adds the search condition to query. For full-text searches it also
+// returns the relevance ordering, which must lead the ORDER BY.
+func (s Search) apply(query *gorm.DB) (*gorm.DB, clause.Expression) {
+	if s.Term == "" || len(s.Columns) == 0 {
+		return query, nil
+	}
+
+	dialect := query.Dialector.Name()
+	if s.Mode == SearchFullText {
+		if expr, ok := fullTextMatch(dialect, s.Columns); ok {
+			rank := clause.Expr{SQL: fullTextRank(dialect, s.Columns) + " DESC", Vars: []any{s.Term}}
+			return query.Where(expr, s.Term), rank
+		}
+	}
+
+	pattern := likeReplacer.Replace(strings.ToLower(s.Term)) + "%"
+	if s.Mode != SearchPrefix {
+		pattern = "%" + pattern
+	}
+
+	conds := make([]string, 0, len(s.Columns))
+	args := make([]any, 0, len(s.Columns))
+	for _, column := range s.Columns {
+		conds = append(conds, likeCondition(dialect, column))
+		args = append(args, pattern)
+	}
+	return query.Where("("+strings.Join(conds, " OR ")+")", args...), nil
+}
+
+// likeCondition matches a lower-cased pattern against column. PostgreSQL has
+// ILIKE; MySQL and SQLite compare lower-cased values so the result does not
+// depend on the column collation.
+func likeCondition(dialect, column string) string {
+	if dialect == "postgres" {
+		return fmt.Sprintf("%s ILIKE ? ESCAPE '%s'", column, likeEscape)
+	}
+	return fmt.Sprintf("LOWER(%s) LIKE ? ESCAPE '%s'", column, likeEscape)
+}
+
+// fullTextMatch returns the full-text predicate for columns. On MySQL the
+// column list must match a FULLTEXT index exactly (see migration 000003).
+func fullTextMatch(dialect string, columns []string) (string, bool) {
+	switch dialect {
+	case "mysql":
+		return fmt.Sprintf("MATCH(%s) AGAINST(? IN NATURAL LANGUAGE MODE)", strings.Join(columns, ", ")), true
+	case "postgres":
+		return fmt.Sprintf("%s @@ plainto_tsquery('simple', ?)", tsVector(columns)), true
+	default:
+		return "", false
+	}
+}
+
+func fullTextRank(dialect string, columns []string) string {
+	if dialect == "postgres" {
+		return fmt.Sprintf("ts_rank(%s, plainto_tsquery('simple', ?))", tsVector(columns))
+	}
+	expr, _ := fullTextMatch(dialect, columns)
+	return expr
+}
+
+func tsVector(columns []string) string {
+	return fmt.Sprintf("to_tsvector('simple', concat_ws(' ', %s))", strings.Join(columns, ", "))
+}
@@ -0,0 +1,19 @@
+package database
+
+import "context"
+
+type txKey struct{}
+
+// ContextWithTx returns a copy of ctx that carries txDB, the transaction-scoped
+// IDatabase received in a WithTransaction callback. Any Database method called
+// with the returned ctx runs inside that transaction, which lets repositories
+// built on the pool in Routes join it without being reconstructed.
+func ContextWithTx(ctx context.Context, txDB IDatabase) context.Context {
+	return context.WithValue(ctx, txKey{}, txDB)
+}
+
+// TxFromContext returns the transaction-scoped IDatabase stored by ContextWithTx.
+func TxFromContext(ctx context.Context) (IDatabase, bool) {
+	txDB, ok := ctx.Value(txKey{}).(IDatabase)
+	return txDB, ok
+}
@@ -1,6 +1,7 @@
 package http
 
 import (
+	"db_blueprints/batch"
 	db "db_blueprints/gorm/database"
 	"db_blueprints/gorm/internal/domain/product/repository"
 	"db_blueprints/gorm/internal/domain/product/service"
@@ -15,15 +16,24 @@ func Routes(
 ) {
 	productRepository := repository.NewProductRepository(db)
 	userRepository := user_repo.NewUserRepository(db)
-	productService := service.NewProductService(productRepository, userRepository)
+	productService := service.NewProductService(db, productRepository, userRepository)
 	productHandler := NewProductHandler(productService)
 
 	productRoute := r.Group("/products")
 	{
 		productRoute.GET("", productHandler.GetProducts)
+		productRoute.GET("/export", productHandler.ExportProducts)
 		productRoute.GET("/:id", productHandler.GetProduct)
 		productRoute.POST("", productHandler.CreateProduct)
 		productRoute.PUT("/:id", productHandler.UpdateProduct)
+		productRoute.PATCH("/:id", productHandler.PatchProduct)
 		productRoute.DELETE("/:id", productHandler.DeleteProduct)
+		productRoute.POST("/:id/restore", productHandler.RestoreProduct)
 	}
+
+	r.POST("/products:action", batch.Actions("action", map[string]gin.HandlerFunc{
+		"batch":       productHandler.BatchCreateProducts,
+		"batchUpdate": productHandler.BatchUpdateProducts,
+		"batchDelete": productHandler.BatchDeleteProducts,
+	}))
 }
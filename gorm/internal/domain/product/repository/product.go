@@ -2,19 +2,34 @@ package repository
 
 import (
 	"context"
-	"db_blueprints/config"
+	"db_blueprints/apperror"
+	"db_blueprints/cursor"
 	db "db_blueprints/gorm/database"
 	"db_blueprints/gorm/internal/domain/product/controller/dto"
-	"db_blueprints/gorm/internal/model"
 	"db_blueprints/gorm/pkgs/paging"
+	"db_blueprints/metrics"
+	"db_blueprints/model"
+	"errors"
+	"time"
 )
 
 type IProductRepository interface {
 	ListProducts(ctx context.Context, req *dto.ListProductRequest) ([]*model.Product, *paging.Pagination, error)
+	ExportProducts(ctx context.Context, req *dto.ExportProductRequest, fn func(*model.Product) error) error
 	GetProductById(ctx context.Context, id int64) (*model.Product, error)
+	GetProductByIdUnscoped(ctx context.Context, id int64) (*model.Product, error)
 	CreatedProduct(ctx context.Context, product *model.Product) error
+	CreateProducts(ctx context.Context, products []*model.Product, batchSize int) error
 	UpdateProduct(ctx context.Context, product *model.Product) error
+	UpdateProductColumns(ctx context.Context, product *model.Product, columns []string) error
 	DeleteProduct(ctx context.Context, product *model.Product) error
+	DeleteProductsByIds(ctx context.Context, ids []int64) (int64, error)
+	DeleteProductsByOwner(ctx context.Context, ownerID int64) (int64, error)
+	DeleteProductsByOwners(ctx context.Context, ownerIDs []int64) (int64, error)
+	RestoreProduct(ctx context.Context, id int64) error
+	RestoreProductsByOwner(ctx context.Context, ownerID int64, since time.Time) (int64, error)
+	PurgeProducts(ctx context.Context, before time.Time) (int64, error)
+	ExistingProductIds(ctx context.Context, ids []int64) ([]int64, error)
 }
 
 type ProductRepository struct {
@@ -26,31 +41,23 @@ func NewProductRepository(db db.IDatabase) *ProductRepository {
 }
 
 func (pr *ProductRepository) ListProducts(ctx context.Context, req *dto.ListProductRequest) ([]*model.Product, *paging.Pagination, error) {
-	ctx, cancel := context.WithTimeout(ctx, config.DatabaseTimeout)
-	defer cancel()
+	ctx = metrics.WithOperation(ctx, "ProductRepository.ListProducts")
+	query := db.NewFilterQueries(req.Filter)
+	search := productSearch(req.Search, req.SearchMode)
 
-	query := make([]db.Query, 0)
-
-	if req.Search != "" {
-		query = append(query, db.NewQuery("name ILIKE ?", "%"+req.Search+"%"))
+	if req.Cursor != nil {
+		return pr.listProductsByCursor(ctx, req, query, search)
 	}
 
-	var order string
-	if req.OrderDesc {
-		order = "created_at DESC"
-	} else {
-		order = "created_at"
+	order, err := productSortable.Parse(req.OrderBy, req.OrderDesc, "created_at")
+	if err != nil {
+		return nil, nil, err
 	}
 
-	if req.OrderBy != "" {
-		order = req.OrderBy
-		if req.OrderDesc {
-			order += " DESC"
-		}
-	}
+	filters := productFilters(query, search, req.IncludeDeleted)
 
 	var total int64
-	if err := pr.db.Count(ctx, &model.Product{}, &total, db.WithQuery(query...)); err != nil {
+	if err := pr.db.Count(ctx, &model.Product{}, &total, filters...); err != nil {
 		return nil, nil, err
 	}
 
@@ -60,11 +67,13 @@ func (pr *ProductRepository) ListProducts(ctx context.Context, req *dto.ListProd
 	if err := pr.db.Find(
 		ctx,
 		&products,
-		db.WithQuery(query...),
-		db.WithLimit(int(pagination.Size)),
-		db.WithOffset(int(pagination.Skip)),
-		db.WithOrder(order),
-		db.WithPreload([]string{"Owner"}),
+		append(
+			filters,
+			db.WithLimit(int(pagination.Size)),
+			db.WithOffset(int(pagination.Skip)),
+			db.WithOrder(order...),
+			db.WithPreload([]string{"Owner"}),
+		)...,
 	); err != nil {
 		return nil, nil, err
 	}
@@ -72,22 +81,247 @@ func (pr *ProductRepository) ListProducts(ctx context.Context, req *dto.ListProd
 	return products, pagination, nil
 }
 
+// listProductsByCursor lists products with keyset pagination on top of the
+// filters in query and search. No COUNT query is run in this mode.
+func (pr *ProductRepository) listProductsByCursor(ctx context.Context, req *dto.ListProductRequest, query []db.Query, search db.Search) ([]*model.Product, *paging.Pagination, error) {
+	if search.Mode == db.SearchFullText {
+		return nil, nil, apperror.Validation("full-text search cannot be combined with a cursor")
+	}
+
+	key, err := productCursorKey(req.OrderBy)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyset, err := cursor.NewKeyset(key, req.OrderDesc, *req.Cursor, paging.PageSize(req.Limit), productID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	spec, err := productSortable.Spec(key.Column, keyset.Descending())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	where, args, err := keyset.Where()
+	if err != nil {
+		return nil, nil, err
+	}
+	if where != "" {
+		query = append(query, db.NewQuery(where, args...))
+	}
+
+	var products []*model.Product
+	if err := pr.db.Find(
+		ctx,
+		&products,
+		append(
+			productFilters(query, search, req.IncludeDeleted),
+			db.WithLimit(int(keyset.Limit())),
+			db.WithOrder(db.WithTieBreak([]db.SortSpec{spec})...),
+			db.WithPreload([]string{"Owner"}),
+		)...,
+	); err != nil {
+		return nil, nil, err
+	}
+
+	products, page := keyset.Page(products)
+	return products, paging.NewCursorPagination(page), nil
+}
+
+// ExportProducts streams every product matching req to fn without loading the
+// result set into memory.
+func (pr *ProductRepository) ExportProducts(ctx context.Context, req *dto.ExportProductRequest, fn func(*model.Product) error) error {
+	ctx = metrics.WithOperation(ctx, "ProductRepository.ExportProducts")
+	query := db.NewFilterQueries(req.Filter)
+	search := productSearch(req.Search, req.SearchMode)
+
+	order, err := productSortable.Parse(req.OrderBy, req.OrderDesc, "id")
+	if err != nil {
+		return err
+	}
+
+	var product model.Product
+	return pr.db.Stream(
+		ctx,
+		&product,
+		func() error {
+			row := product
+			return fn(&row)
+		},
+		append(
+			productFilters(query, search, req.IncludeDeleted),
+			db.WithOrder(db.WithTieBreak(order)...),
+		)...,
+	)
+}
+
 func (pr *ProductRepository) GetProductById(ctx context.Context, id int64) (*model.Product, error) {
+	ctx = metrics.WithOperation(ctx, "ProductRepository.GetProductById")
+	return pr.getProductById(ctx, id)
+}
+
+// GetProductByIdUnscoped returns the product even when it is soft-deleted.
+func (pr *ProductRepository) GetProductByIdUnscoped(ctx context.Context, id int64) (*model.Product, error) {
+	ctx = metrics.WithOperation(ctx, "ProductRepository.GetProductByIdUnscoped")
+	return pr.getProductById(ctx, id, db.WithUnscoped())
+}
+
+func (pr *ProductRepository) getProductById(ctx context.Context, id int64, opts ...db.FindOption) (*model.Product, error) {
 	var product model.Product
-	if err := pr.db.FindById(ctx, id, &product); err != nil {
+	if err := pr.db.FindById(ctx, id, &product, opts...); err != nil {
+		if errors.Is(err, apperror.ErrNotFound) {
+			return nil, apperror.NotFound("product with id %d not found", id)
+		}
 		return nil, err
 	}
 	return &product, nil
 }
 
 func (pr *ProductRepository) CreatedProduct(ctx context.Context, product *model.Product) error {
+	ctx = metrics.WithOperation(ctx, "ProductRepository.CreatedProduct")
 	return pr.db.Create(ctx, product)
 }
 
+// CreateProducts inserts the products with multi-row INSERTs of up to
+// batchSize rows and sets their IDs.
+func (pr *ProductRepository) CreateProducts(ctx context.Context, products []*model.Product, batchSize int) error {
+	ctx = metrics.WithOperation(ctx, "ProductRepository.CreateProducts")
+	return pr.db.CreateInBatches(ctx, products, batchSize)
+}
+
 func (pr *ProductRepository) UpdateProduct(ctx context.Context, product *model.Product) error {
+	ctx = metrics.WithOperation(ctx, "ProductRepository.UpdateProduct")
 	return pr.db.Update(ctx, product)
 }
 
+// UpdateProductColumns writes only the named columns of the product.
+func (pr *ProductRepository) UpdateProductColumns(ctx context.Context, product *model.Product, columns []string) error {
+	ctx = metrics.WithOperation(ctx, "ProductRepository.UpdateProductColumns")
+	return pr.db.UpdateColumns(ctx, product, columns)
+}
+
 func (pr *ProductRepository) DeleteProduct(ctx context.Context, product *model.Product) error {
+	ctx = metrics.WithOperation(ctx, "ProductRepository.DeleteProduct")
 	return pr.db.Delete(ctx, product)
 }
+
+// DeleteProductsByIds soft-deletes the live products among ids.
+func (pr *ProductRepository) DeleteProductsByIds(ctx context.Context, ids []int64) (int64, error) {
+	ctx = metrics.WithOperation(ctx, "ProductRepository.DeleteProductsByIds")
+	return pr.db.DeleteWhere(ctx, &model.Product{}, db.WithQuery(db.NewQuery("id IN ?", ids)))
+}
+
+// DeleteProductsByOwner soft-deletes the live products of the owner.
+func (pr *ProductRepository) DeleteProductsByOwner(ctx context.Context, ownerID int64) (int64, error) {
+	ctx = metrics.WithOperation(ctx, "ProductRepository.DeleteProductsByOwner")
+	return pr.db.DeleteWhere(ctx, &model.Product{}, db.WithQuery(db.NewQuery("owner_id = ?", ownerID)))
+}
+
+// DeleteProductsByOwners soft-deletes the live products of several owners.
+func (pr *ProductRepository) DeleteProductsByOwners(ctx context.Context, ownerIDs []int64) (int64, error) {
+	ctx = metrics.WithOperation(ctx, "ProductRepository.DeleteProductsByOwners")
+	return pr.db.DeleteWhere(ctx, &model.Product{}, db.WithQuery(db.NewQuery("owner_id IN ?", ownerIDs)))
+}
+
+func (pr *ProductRepository) RestoreProduct(ctx context.Context, id int64) error {
+	ctx = metrics.WithOperation(ctx, "ProductRepository.RestoreProduct")
+	restored, err := pr.db.Restore(ctx, &model.Product{}, db.WithQuery(db.NewQuery("id = ?", id)))
+	if err != nil {
+		return err
+	}
+	if restored == 0 {
+		return apperror.NotFound("deleted product with id %d not found", id)
+	}
+	return nil
+}
+
+// RestoreProductsByOwner restores the products of the owner deleted at or
+// after since, i.e. the ones deleted together with the owner.
+func (pr *ProductRepository) RestoreProductsByOwner(ctx context.Context, ownerID int64, since time.Time) (int64, error) {
+	ctx = metrics.WithOperation(ctx, "ProductRepository.RestoreProductsByOwner")
+	return pr.db.Restore(ctx, &model.Product{}, db.WithQuery(db.NewQuery("owner_id = ? AND deleted_at >= ?", ownerID, since)))
+}
+
+// PurgeProducts removes products soft-deleted before the given time for good.
+func (pr *ProductRepository) PurgeProducts(ctx context.Context, before time.Time) (int64, error) {
+	ctx = metrics.WithOperation(ctx, "ProductRepository.PurgeProducts")
+	return pr.db.DeleteWhere(
+		ctx,
+		&model.Product{},
+		db.WithUnscoped(),
+		db.WithQuery(db.NewQuery("deleted_at IS NOT NULL AND deleted_at < ?", before)),
+	)
+}
+
+// ExistingProductIds returns the ids among ids that belong to live products.
+func (pr *ProductRepository) ExistingProductIds(ctx context.Context, ids []int64) ([]int64, error) {
+	ctx = metrics.WithOperation(ctx, "ProductRepository.ExistingProductIds")
+	var products []*model.Product
+	if err := pr.db.Find(ctx, &products, db.WithQuery(db.NewQuery("id IN ?", ids))); err != nil {
+		return nil, err
+	}
+
+	existing := make([]int64, 0, len(products))
+	for _, p := range products {
+		existing = append(existing, p.ID)
+	}
+	return existing, nil
+}
+
+// productFilters are the options narrowing a listing or export down to the
+// requested products.
+func productFilters(query []db.Query, search db.Search, includeDeleted bool) []db.FindOption {
+	opts := []db.FindOption{db.WithQuery(query...), db.WithSearch(search)}
+	if includeDeleted {
+		opts = append(opts, db.WithUnscoped())
+	}
+	return opts
+}
+
+// productSearch searches the columns covered by the full-text index added in
+// migration 000003.
+func productSearch(term, mode string) db.Search {
+	search := db.Search{Term: term, Mode: db.SearchContains, Columns: []string{"name"}}
+	if mode != "" {
+		search.Mode = db.SearchMode(mode)
+	}
+	return search
+}
+
+// productSortable lists the fields products can be ordered by.
+var productSortable = db.RegisterSortable(&model.Product{}, map[string]string{
+	"id":         "id",
+	"name":       "name",
+	"price":      "price",
+	"owner_id":   "owner_id",
+	"created_at": "created_at",
+	"updated_at": "updated_at",
+})
+
+// productSortKeys are the fields products can be ordered by in cursor mode, keyed
+// by the order_by query value.
+var productSortKeys = map[string]cursor.SortKey[model.Product]{
+	"id":         cursor.IntKey("id", productID),
+	"name":       cursor.StringKey("name", func(p *model.Product) string { return p.Name }),
+	"price":      cursor.FloatKey("price", func(p *model.Product) float64 { return p.Price }),
+	"created_at": cursor.TimeKey("created_at", func(p *model.Product) time.Time { return p.CreatedAt }),
+}
+
+// productCursorKey returns the key for cursor mode, which orders by a single
+// field.
+func productCursorKey(orderBy string) (cursor.SortKey[model.Product], error) {
+	if orderBy == "" {
+		return productSortKeys["id"], nil
+	}
+
+	key, ok := productSortKeys[orderBy]
+	if !ok {
+		return key, apperror.Validation("cursor pagination cannot sort by %q", orderBy)
+	}
+	return key, nil
+}
+
+func productID(p *model.Product) int64 {
+	return p.ID
+}
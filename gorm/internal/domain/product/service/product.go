@@ -2,33 +2,48 @@ package service
 
 import (
 	"context"
+	"db_blueprints/apperror"
+	"db_blueprints/batch"
+	db "db_blueprints/gorm/database"
 	"db_blueprints/gorm/internal/domain/product/controller/dto"
 	"db_blueprints/gorm/internal/domain/product/repository"
 	user_repo "db_blueprints/gorm/internal/domain/user/repository"
-	"db_blueprints/gorm/internal/model"
 	"db_blueprints/gorm/pkgs/paging"
 	"db_blueprints/gorm/utils"
+	"db_blueprints/model"
+	"errors"
 	"log"
+	"time"
 )
 
 type IProductService interface {
 	ListProducts(ctx context.Context, req *dto.ListProductRequest) ([]*model.Product, *paging.Pagination, error)
-	GetProductById(ctx context.Context, id int64) (*model.Product, error)
+	ExportProducts(ctx context.Context, req *dto.ExportProductRequest, fn func(*model.Product) error) error
+	GetProductById(ctx context.Context, id int64, includeDeleted bool) (*model.Product, error)
 	CreateProduct(ctx context.Context, req *dto.CreateProductRequest) (*model.Product, error)
+	CreateProducts(ctx context.Context, reqs []*dto.CreateProductRequest) ([]*model.Product, error)
 	UpdateProduct(ctx context.Context, req *dto.UpdateProductRequest) (*model.Product, error)
+	PatchProduct(ctx context.Context, id int64, req *dto.PatchProductRequest) (*model.Product, []string, error)
+	UpdateProducts(ctx context.Context, items []*dto.BatchUpdateProductItem, allOrNothing bool) ([]*model.Product, []error, error)
 	DeleteProduct(ctx context.Context, id int64) error
+	DeleteProducts(ctx context.Context, ids []int64, allOrNothing bool) ([]int64, error)
+	RestoreProduct(ctx context.Context, id int64) (*model.Product, error)
+	PurgeDeleted(ctx context.Context, before time.Time) (int64, error)
 }
 
 type ProductService struct {
+	db        db.IDatabase
 	repo      repository.IProductRepository
 	user_repo user_repo.IUserRepository
 }
 
 func NewProductService(
+	db db.IDatabase,
 	repo repository.IProductRepository,
 	user_repo user_repo.IUserRepository,
 ) *ProductService {
 	return &ProductService{
+		db:        db,
 		repo:      repo,
 		user_repo: user_repo,
 	}
@@ -42,14 +57,25 @@ func (pu *ProductService) ListProducts(ctx context.Context, req *dto.ListProduct
 	return products, pagination, nil
 }
 
-func (pu *ProductService) GetProductById(ctx context.Context, id int64) (*model.Product, error) {
-	product, err := pu.repo.GetProductById(ctx, id)
+func (pu *ProductService) ExportProducts(ctx context.Context, req *dto.ExportProductRequest, fn func(*model.Product) error) error {
+	return pu.repo.ExportProducts(ctx, req, fn)
+}
+
+// GetProductById returns the product and its owner. With includeDeleted, a
+// soft-deleted product, and its possibly deleted owner, are found as well.
+func (pu *ProductService) GetProductById(ctx context.Context, id int64, includeDeleted bool) (*model.Product, error) {
+	getProduct, getUser := pu.repo.GetProductById, pu.user_repo.GetUserById
+	if includeDeleted {
+		getProduct, getUser = pu.repo.GetProductByIdUnscoped, pu.user_repo.GetUserByIdUnscoped
+	}
+
+	product, err := getProduct(ctx, id)
 	if err != nil {
 		return nil, err
 	}
 
 	println("user id", product.OwnerID)
-	user, err := pu.user_repo.GetUserById(ctx, product.OwnerID)
+	user, err := getUser(ctx, product.OwnerID)
 	if err != nil {
 		log.Printf("Get user by id %d fail, error: %s", product.OwnerID, err)
 		return nil, err
@@ -74,12 +100,38 @@ func (pu *ProductService) CreateProduct(ctx context.Context, req *dto.CreateProd
 	return &product, nil
 }
 
+// CreateProducts inserts the products in one transaction, with
+// CreateInBatches, and returns them in request order.
+func (pu *ProductService) CreateProducts(ctx context.Context, reqs []*dto.CreateProductRequest) ([]*model.Product, error) {
+	products := make([]*model.Product, 0, len(reqs))
+	for _, req := range reqs {
+		products = append(products, &model.Product{
+			Name:    req.Name,
+			Price:   req.Price,
+			OwnerID: req.OwnerID,
+		})
+	}
+
+	err := pu.db.WithTransaction(ctx, func(txDB db.IDatabase) error {
+		ctx := db.ContextWithTx(ctx, txDB)
+		return pu.repo.CreateProducts(ctx, products, batch.InsertSize)
+	})
+	if err != nil {
+		log.Printf("Create in batches fail, error: %s", err)
+		return nil, err
+	}
+	return products, nil
+}
+
 func (pu *ProductService) UpdateProduct(ctx context.Context, req *dto.UpdateProductRequest) (*model.Product, error) {
 	product, err := pu.repo.GetProductById(ctx, req.ID)
 	if err != nil {
 		log.Printf("Get fail, error: %s", err)
 		return nil, err
 	}
+	if req.Version != nil && *req.Version != product.Version {
+		return nil, apperror.VersionConflict("product with id %d is at version %d, not %d", req.ID, product.Version, *req.Version)
+	}
 	utils.MapStruct(product, req)
 
 	err = pu.repo.UpdateProduct(ctx, product)
@@ -91,6 +143,72 @@ func (pu *ProductService) UpdateProduct(ctx context.Context, req *dto.UpdateProd
 	return product, nil
 }
 
+// PatchProduct applies the members of a merge patch that differ from the stored
+// product and writes only those columns. It returns the product together with
+// the fields that changed.
+func (pu *ProductService) PatchProduct(ctx context.Context, id int64, req *dto.PatchProductRequest) (*model.Product, []string, error) {
+	product, err := pu.repo.GetProductById(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if req.Version != nil && *req.Version != product.Version {
+		return nil, nil, apperror.VersionConflict("product with id %d is at version %d, not %d", id, product.Version, *req.Version)
+	}
+
+	changed := []string{}
+	if req.Name != nil && *req.Name != product.Name {
+		product.Name = *req.Name
+		changed = append(changed, "name")
+	}
+	if req.Price != nil && *req.Price != product.Price {
+		product.Price = *req.Price
+		changed = append(changed, "price")
+	}
+	if req.OwnerID != nil && *req.OwnerID != product.OwnerID {
+		product.OwnerID = *req.OwnerID
+		changed = append(changed, "owner_id")
+	}
+
+	if err := pu.repo.UpdateProductColumns(ctx, product, changed); err != nil {
+		log.Printf("Patch fail, id: %d, error: %s", id, err)
+		return nil, nil, err
+	}
+
+	return product, changed, nil
+}
+
+// UpdateProducts patches the products of items in one transaction, each item in a
+// savepoint of its own, and returns the updated products and the error of each
+// item in request order. With allOrNothing, nothing is written when any item
+// fails.
+func (pu *ProductService) UpdateProducts(ctx context.Context, items []*dto.BatchUpdateProductItem, allOrNothing bool) ([]*model.Product, []error, error) {
+	products := make([]*model.Product, len(items))
+	errs := make([]error, len(items))
+	err := pu.db.WithTransaction(ctx, func(txDB db.IDatabase) error {
+		ctx := db.ContextWithTx(ctx, txDB)
+
+		failed := false
+		for i, item := range items {
+			req := &dto.PatchProductRequest{OwnerID: item.OwnerID, Name: item.Name, Price: item.Price, Version: item.Version}
+			errs[i] = pu.db.WithTransaction(ctx, func(txDB db.IDatabase) error {
+				var err error
+				products[i], _, err = pu.PatchProduct(db.ContextWithTx(ctx, txDB), item.ID, req)
+				return err
+			})
+			failed = failed || errs[i] != nil
+		}
+		if allOrNothing && failed {
+			return batch.ErrAborted
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, batch.ErrAborted) {
+		log.Printf("Update in batches fail, error: %s", err)
+		return nil, nil, err
+	}
+	return products, errs, nil
+}
+
 func (pu *ProductService) DeleteProduct(ctx context.Context, id int64) error {
 	product, err := pu.repo.GetProductById(ctx, id)
 	if err != nil {
@@ -103,3 +221,75 @@ func (pu *ProductService) DeleteProduct(ctx context.Context, id int64) error {
 
 	return nil
 }
+
+// DeleteProducts soft-deletes the products with the given ids in one
+// transaction and returns the ids that matched no live product. With
+// allOrNothing, nothing is deleted when any id is missing.
+func (pu *ProductService) DeleteProducts(ctx context.Context, ids []int64, allOrNothing bool) ([]int64, error) {
+	var missing []int64
+	err := pu.db.WithTransaction(ctx, func(txDB db.IDatabase) error {
+		ctx := db.ContextWithTx(ctx, txDB)
+
+		existing, err := pu.repo.ExistingProductIds(ctx, ids)
+		if err != nil {
+			return err
+		}
+		missing = missingIDs(ids, existing)
+		if len(existing) == 0 || (allOrNothing && len(missing) > 0) {
+			return nil
+		}
+
+		_, err = pu.repo.DeleteProductsByIds(ctx, existing)
+		return err
+	})
+	if err != nil {
+		log.Printf("Delete in batches fail, error: %s", err)
+		return nil, err
+	}
+	return missing, nil
+}
+
+// RestoreProduct undoes a soft delete. A product cannot come back while its
+// owner is deleted, since purging the owner would remove it again.
+func (pu *ProductService) RestoreProduct(ctx context.Context, id int64) (*model.Product, error) {
+	product, err := pu.repo.GetProductByIdUnscoped(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	ownerExists, err := pu.user_repo.ExistsByID(ctx, product.OwnerID)
+	if err != nil {
+		return nil, err
+	}
+	if !ownerExists {
+		return nil, apperror.Conflict("product %d cannot be restored while its owner %d is deleted", id, product.OwnerID)
+	}
+
+	if err := pu.repo.RestoreProduct(ctx, id); err != nil {
+		log.Printf("Restore fail, id: %d, error: %s", id, err)
+		return nil, err
+	}
+
+	return pu.GetProductById(ctx, id, false)
+}
+
+// PurgeDeleted removes products soft-deleted before the given time for good.
+func (pu *ProductService) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	return pu.repo.PurgeProducts(ctx, before)
+}
+
+// missingIDs returns the ids that are not in existing, in order.
+func missingIDs(ids, existing []int64) []int64 {
+	found := make(map[int64]bool, len(existing))
+	for _, id := range existing {
+		found[id] = true
+	}
+
+	var missing []int64
+	for _, id := range ids {
+		if !found[id] {
+			missing = append(missing, id)
+		}
+	}
+	return missing
+}
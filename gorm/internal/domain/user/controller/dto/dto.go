@@ -1,22 +1,72 @@
 package dto
 
-import "db_blueprints/gorm/pkgs/paging"
+import (
+	"db_blueprints/filter"
+	"db_blueprints/gorm/pkgs/paging"
+)
 
 type User struct {
-	ID        int64  `json:"id"`
-	Email     string `json:"email"`
-	Name      string `json:"name"`
-	CreatedAt string `json:"created_at"`
-	UpdatedAt string `json:"updated_at"`
+	ID        int64          `json:"id"`
+	Email     string         `json:"email"`
+	Name      string         `json:"name"`
+	Products  []*UserProduct `json:"products,omitempty"`
+	CreatedAt string         `json:"created_at"`
+	UpdatedAt string         `json:"updated_at"`
+	DeletedAt *string        `json:"deleted_at,omitempty"`
+	Version   int64          `json:"version"`
+}
+
+type UserProduct struct {
+	ID    int64   `json:"id"`
+	Name  string  `json:"name"`
+	Price float64 `json:"price"`
+}
+
+// UserFilters are the fields users can be filtered by, e.g.
+// created_at[after]=2024-01-01&id[in]=1,2.
+var UserFilters = filter.Schema{
+	"id":         {Column: "id", Kind: filter.Int},
+	"name":       {Column: "name", Kind: filter.String},
+	"email":      {Column: "email", Kind: filter.String},
+	"created_at": {Column: "created_at", Kind: filter.Time},
+	"updated_at": {Column: "updated_at", Kind: filter.Time},
+}
+
+// GetUserRequest holds the query parameters of a single user lookup.
+type GetUserRequest struct {
+	// IncludeDeleted finds a soft-deleted user too. Admins only.
+	IncludeDeleted bool `form:"include_deleted"`
 }
 
 type ListUserRequest struct {
-	Search    string `json:"search,omitempty" form:"search"`
-	Page      int64  `json:"-" form:"page"`
-	Limit     int64  `json:"-" form:"size"`
-	OrderBy   string `json:"-" form:"order_by"`
-	OrderDesc bool   `json:"-" form:"order_desc"`
-	TakeAll   bool   `json:"-" form:"take_all"`
+	Search string `json:"search,omitempty" form:"search" binding:"max=255"`
+	// SearchMode is contains (the default), prefix or fulltext.
+	SearchMode string `json:"-" form:"search_mode" binding:"omitempty,oneof=contains prefix fulltext"`
+	Page       int64  `json:"-" form:"page" binding:"omitempty,min=1"`
+	Limit      int64  `json:"-" form:"size" binding:"omitempty,min=1,max=1000"`
+	OrderBy    string `json:"-" form:"order_by"`
+	OrderDesc  bool   `json:"-" form:"order_desc"`
+	// Cursor switches listing to keyset pagination. Send it empty to get the
+	// first page, then pass back next_cursor or prev_cursor from the metadata.
+	Cursor *string `json:"-" form:"cursor"`
+	// IncludeDeleted lists soft-deleted rows too. Admins only.
+	IncludeDeleted bool `json:"-" form:"include_deleted"`
+	// Filter is parsed from the field[op]=value parameters by the handler.
+	Filter *filter.Filter `json:"-" form:"-"`
+}
+
+// ExportUserRequest filters and orders an export. It takes the same
+// parameters as listing, without paging.
+type ExportUserRequest struct {
+	Search string `json:"search,omitempty" form:"search" binding:"max=255"`
+	// SearchMode is contains (the default), prefix or fulltext.
+	SearchMode string `json:"-" form:"search_mode" binding:"omitempty,oneof=contains prefix fulltext"`
+	OrderBy    string `json:"-" form:"order_by"`
+	OrderDesc  bool   `json:"-" form:"order_desc"`
+	// IncludeDeleted lists soft-deleted rows too. Admins only.
+	IncludeDeleted bool `json:"-" form:"include_deleted"`
+	// Filter is parsed from the field[op]=value parameters by the handler.
+	Filter *filter.Filter `json:"-" form:"-"`
 }
 
 type ListUserResponse struct {
@@ -25,8 +75,15 @@ type ListUserResponse struct {
 }
 
 type CreateUserRequest struct {
-	Email string `json:"email"`
-	Name  string `json:"name"`
+	Email    string                   `json:"email" binding:"required,email,max=255,email_unique"`
+	Name     string                   `json:"name" binding:"required,max=255"`
+	Products []*CreateUserProductItem `json:"products" binding:"omitempty,dive"`
+}
+
+// CreateUserProductItem is an initial product created together with the user.
+type CreateUserProductItem struct {
+	Name  string  `json:"name" binding:"required,max=255"`
+	Price float64 `json:"price" binding:"required,gt=0"`
 }
 
 type CreateUserResponse struct {
@@ -35,10 +92,41 @@ type CreateUserResponse struct {
 
 type UpdateUserRequest struct {
 	ID    int64   `json:"id"`
-	Email *string `json:"email"`
-	Name  *string `json:"name"`
+	Email *string `json:"email" binding:"omitnil,email,max=255"`
+	Name  *string `json:"name" binding:"omitnil,min=1,max=255"`
+	// Version is the version the change is based on. The If-Match header
+	// takes precedence over it.
+	Version *int64 `json:"version" binding:"omitnil,min=1"`
 }
 
 type UpdateUserResponse struct {
 	User *User `json:"user"`
 }
+
+// PatchUserRequest holds the members of a JSON merge patch. Absent members
+// stay nil.
+type PatchUserRequest struct {
+	Email *string `json:"email" binding:"omitnil,email,max=255"`
+	Name  *string `json:"name" binding:"omitnil,min=1,max=255"`
+	// Version comes from the If-Match header.
+	Version *int64 `json:"-"`
+}
+
+// BatchUpdateUserItem is an item of a bulk update. Like a merge patch, it
+// writes only the fields it sets.
+type BatchUpdateUserItem struct {
+	ID    int64   `json:"id" binding:"required,min=1"`
+	Email *string `json:"email" binding:"omitnil,email,max=255"`
+	Name  *string `json:"name" binding:"omitnil,min=1,max=255"`
+	// Version is the version the change is based on.
+	Version *int64 `json:"version" binding:"omitnil,min=1"`
+}
+
+// UserPatchFields are the members a user merge patch may set.
+var UserPatchFields = []string{"email", "name"}
+
+type PatchUserResponse struct {
+	User *User `json:"user"`
+	// Changed lists the fields whose value the patch changed.
+	Changed []string `json:"changed"`
+}
@@ -1,16 +1,26 @@
 package http
 
 import (
+	"context"
+	"db_blueprints/apperror"
+	"db_blueprints/batch"
+	"db_blueprints/etag"
+	"db_blueprints/export"
+	"db_blueprints/filter"
 	"db_blueprints/gorm/internal/domain/user/controller/dto"
 	"db_blueprints/gorm/internal/domain/user/service"
-	"db_blueprints/gorm/internal/model"
-	"db_blueprints/gorm/pkgs/response"
 	"db_blueprints/gorm/utils"
+	"db_blueprints/mergepatch"
+	"db_blueprints/middleware"
+	"db_blueprints/model"
+	"db_blueprints/response"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 )
 
 type UserHandler struct {
@@ -28,6 +38,16 @@ func (h *UserHandler) GetUsers(c *gin.Context) {
 		response.Error(c, http.StatusBadRequest, err, "Invalid parameters")
 		return
 	}
+	if !allowIncludeDeleted(c, req.IncludeDeleted) {
+		return
+	}
+
+	filters, err := filter.Parse(c.Request.URL.Query(), dto.UserFilters)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err, "Invalid filter")
+		return
+	}
+	req.Filter = filters
 
 	users, pagination, err := h.service.ListUsers(c, &req)
 	if err != nil {
@@ -43,6 +63,69 @@ func (h *UserHandler) GetUsers(c *gin.Context) {
 	response.JSON(c, http.StatusOK, res)
 }
 
+// userExportColumns are the CSV columns of an user export.
+var userExportColumns = []export.Column[dto.User]{
+	{Header: "id", Value: func(u *dto.User) string { return strconv.FormatInt(u.ID, 10) }},
+	{Header: "email", Value: func(u *dto.User) string { return u.Email }},
+	{Header: "name", Value: func(u *dto.User) string { return u.Name }},
+	{Header: "created_at", Value: func(u *dto.User) string { return u.CreatedAt }},
+	{Header: "updated_at", Value: func(u *dto.User) string { return u.UpdatedAt }},
+}
+
+// ExportUsers streams every matching user as NDJSON or CSV, picked from the
+// Accept header. Rows are written as they are read from the database.
+func (h *UserHandler) ExportUsers(c *gin.Context) {
+	var req dto.ExportUserRequest
+	if err := c.ShouldBind(&req); err != nil {
+		log.Println("Failed to get query", err)
+		response.Error(c, http.StatusBadRequest, err, "Invalid parameters")
+		return
+	}
+	if !allowIncludeDeleted(c, req.IncludeDeleted) {
+		return
+	}
+
+	filters, err := filter.Parse(c.Request.URL.Query(), dto.UserFilters)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err, "Invalid filter")
+		return
+	}
+	req.Filter = filters
+
+	format := c.NegotiateFormat(export.Formats...)
+	if format == "" {
+		response.Error(c, http.StatusNotAcceptable, nil, "Export is available as application/x-ndjson or text/csv")
+		return
+	}
+
+	if err := export.LiftWriteDeadline(c.Writer); err != nil {
+		log.Printf("Failed to lift the write deadline of the export: %v", err)
+	}
+
+	c.Header("Content-Type", format)
+	c.Header("Content-Disposition", export.Disposition("users", format))
+
+	w := export.NewWriter(format, c.Writer, userExportColumns)
+	err = h.service.ExportUsers(c, &req, func(u *model.User) error {
+		var item dto.User
+		utils.MapStruct(&item, u)
+		return w.Write(&item)
+	})
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		log.Println("Failed to export users", err)
+		// Once rows have been sent the status is committed and the client
+		// only sees a truncated body.
+		if !c.Writer.Written() {
+			c.Writer.Header().Del("Content-Type")
+			c.Writer.Header().Del("Content-Disposition")
+			response.Error(c, http.StatusInternalServerError, err, "Failed to export users")
+		}
+	}
+}
+
 func (h *UserHandler) GetUser(c *gin.Context) {
 	var res model.User
 
@@ -51,13 +134,27 @@ func (h *UserHandler) GetUser(c *gin.Context) {
 		log.Println("Failed to parse", err)
 	}
 
-	user, err := h.service.GetUserById(c, userId)
+	var req dto.GetUserRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, err, "Invalid parameters")
+		return
+	}
+	if !allowIncludeDeleted(c, req.IncludeDeleted) {
+		return
+	}
+
+	user, err := h.service.GetUserById(c, userId, req.IncludeDeleted)
 	if err != nil {
 		log.Println("Failed to get user", err)
 		response.Error(c, http.StatusInternalServerError, err, "Failed to get user")
 		return
 	}
 
+	if etag.NotModified(c, user.Version) {
+		return
+	}
+	etag.Set(c, user.Version)
+
 	utils.MapStruct(&res, user)
 	response.JSON(c, http.StatusOK, res)
 }
@@ -83,6 +180,72 @@ func (h *UserHandler) CreateUser(c *gin.Context) {
 	response.JSON(c, http.StatusCreated, res)
 }
 
+// BatchCreateUsers creates many users, with their initial products, in one
+// transaction and reports the created ID or the validation error of each item.
+func (h *UserHandler) BatchCreateUsers(c *gin.Context) {
+	var req batch.CreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, err, "Invalid parameters")
+		return
+	}
+
+	results := batch.NewResults(len(req.Items))
+	items := uniqueEmails(batch.Decode[dto.CreateUserRequest](req.Items, results), results)
+	items = h.deletedEmails(c, items, results)
+	if results.Abort(req.Mode) || len(items) == 0 {
+		results.Respond(c, http.StatusCreated)
+		return
+	}
+
+	reqs := make([]*dto.CreateUserRequest, 0, len(items))
+	for _, item := range items {
+		reqs = append(reqs, item.Value)
+	}
+
+	users, err := h.service.CreateUsers(c, reqs)
+	if err != nil {
+		log.Println("Failed to create users", err)
+		response.Error(c, http.StatusInternalServerError, err, "Failed to create users")
+		return
+	}
+
+	for i, user := range users {
+		results.Succeed(items[i].Index, batch.Created, user.ID)
+	}
+	results.Respond(c, http.StatusCreated)
+}
+
+// uniqueEmails fails the items whose email an earlier item of the batch
+// already uses. email_unique only checks the users stored so far.
+func uniqueEmails(items []batch.Item[dto.CreateUserRequest], results batch.Results) []batch.Item[dto.CreateUserRequest] {
+	seen := make(map[string]bool, len(items))
+	unique := items[:0]
+	for _, item := range items {
+		email := strings.ToLower(item.Value.Email)
+		if seen[email] {
+			results.Fail(item.Index, apperror.Conflict("email %q appears more than once in the batch", item.Value.Email), "Invalid item")
+			continue
+		}
+		seen[email] = true
+		unique = append(unique, item)
+	}
+	return unique
+}
+
+// deletedEmails fails the items whose email belongs to a soft-deleted user,
+// see CheckEmail.
+func (h *UserHandler) deletedEmails(ctx context.Context, items []batch.Item[dto.CreateUserRequest], results batch.Results) []batch.Item[dto.CreateUserRequest] {
+	free := items[:0]
+	for _, item := range items {
+		if err := h.service.CheckEmail(ctx, item.Value.Email); err != nil {
+			results.Fail(item.Index, err, "Invalid item")
+			continue
+		}
+		free = append(free, item)
+	}
+	return free
+}
+
 func (h *UserHandler) UpdateUser(c *gin.Context) {
 	var req dto.UpdateUserRequest
 
@@ -101,18 +264,139 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 		return
 	}
 
+	version, ok, err := etag.IfMatch(c)
+	if err != nil {
+		response.Error(c, http.StatusPreconditionFailed, err, "Invalid If-Match header")
+		return
+	}
+	if ok {
+		req.Version = &version
+	}
+
 	user, err := h.service.UpdateUser(c, &req)
 	if err != nil {
-		response.Error(c, http.StatusInternalServerError, err, "Something went wrong")
+		if ok {
+			err = etag.Precondition(err)
+		}
+		response.Error(c, http.StatusInternalServerError, err, "Failed to update user")
 		return
 	}
 
 	var res dto.UpdateUserResponse
 	utils.MapStruct(&res.User, user)
+	etag.Set(c, user.Version)
 
 	response.JSON(c, http.StatusOK, res)
 }
 
+// PatchUser applies a JSON merge patch (RFC 7396) to a user. Only the
+// fields the patch changes are written, and the response lists them.
+func (h *UserHandler) PatchUser(c *gin.Context) {
+	userId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err, "Invalid user ID")
+		return
+	}
+
+	if contentType := c.ContentType(); contentType != mergepatch.ContentType && contentType != binding.MIMEJSON {
+		response.Error(c, http.StatusUnsupportedMediaType, nil, "Patch must be sent as "+mergepatch.ContentType)
+		return
+	}
+
+	body, err := c.GetRawData()
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err, "Invalid request body")
+		return
+	}
+
+	var req dto.PatchUserRequest
+	patch, err := mergepatch.Parse(body)
+	if err == nil {
+		err = patch.Check(dto.UserPatchFields...)
+	}
+	if err == nil {
+		err = patch.Decode(&req)
+	}
+	if err == nil {
+		err = binding.Validator.ValidateStruct(&req)
+	}
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err, "Invalid patch")
+		return
+	}
+
+	version, ok, err := etag.IfMatch(c)
+	if err != nil {
+		response.Error(c, http.StatusPreconditionFailed, err, "Invalid If-Match header")
+		return
+	}
+	if ok {
+		req.Version = &version
+	}
+
+	user, changed, err := h.service.PatchUser(c, userId, &req)
+	if err != nil {
+		if ok {
+			err = etag.Precondition(err)
+		}
+		log.Println("Failed to patch user", err)
+		response.Error(c, http.StatusInternalServerError, err, "Failed to patch user")
+		return
+	}
+
+	res := dto.PatchUserResponse{Changed: changed}
+	utils.MapStruct(&res.User, user)
+	etag.Set(c, user.Version)
+
+	response.JSON(c, http.StatusOK, res)
+}
+
+// BatchUpdateUsers updates many users in one transaction and reports the new
+// version or the error of each item.
+func (h *UserHandler) BatchUpdateUsers(c *gin.Context) {
+	var req batch.UpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, err, "Invalid parameters")
+		return
+	}
+
+	results := batch.NewResults(len(req.Items))
+	items := batch.Decode[dto.BatchUpdateUserItem](req.Items, results)
+	if results.Abort(req.Mode) || len(items) == 0 {
+		results.Respond(c, http.StatusOK)
+		return
+	}
+
+	reqs := make([]*dto.BatchUpdateUserItem, 0, len(items))
+	for _, item := range items {
+		reqs = append(reqs, item.Value)
+	}
+
+	users, errs, err := h.service.UpdateUsers(c, reqs, req.Mode != batch.BestEffort)
+	if err != nil {
+		log.Println("Failed to update users", err)
+		response.Error(c, http.StatusInternalServerError, err, "Failed to update users")
+		return
+	}
+
+	for i, err := range errs {
+		if err != nil {
+			results.Fail(items[i].Index, err, "Failed to update user")
+		}
+	}
+	if results.Abort(req.Mode) {
+		results.Respond(c, http.StatusOK)
+		return
+	}
+
+	for i, user := range users {
+		if errs[i] == nil {
+			results.SucceedUpdate(items[i].Index, user.ID, user.Version)
+		}
+	}
+	results.Respond(c, http.StatusOK)
+}
+
 func (h *UserHandler) DeleteUser(c *gin.Context) {
 	userId, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
@@ -122,9 +406,58 @@ func (h *UserHandler) DeleteUser(c *gin.Context) {
 	err = h.service.DeleteUser(c, userId)
 
 	if err != nil {
-		response.Error(c, http.StatusNotFound, err, "Not found")
+		response.Error(c, http.StatusInternalServerError, err, "Failed to delete user")
 		return
 	}
 
 	response.JSON(c, http.StatusOK, "Delete user successfully")
 }
+
+// BatchDeleteUsers soft-deletes the users with the given ids, and their
+// products, in one transaction and reports on each id.
+func (h *UserHandler) BatchDeleteUsers(c *gin.Context) {
+	var req batch.DeleteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, err, "Invalid parameters")
+		return
+	}
+
+	missing, err := h.service.DeleteUsers(c, req.IDs, req.Mode != batch.BestEffort)
+	if err != nil {
+		log.Println("Failed to delete users", err)
+		response.Error(c, http.StatusInternalServerError, err, "Failed to delete users")
+		return
+	}
+
+	batch.DeleteResults(req.IDs, missing, req.Mode, "user").Respond(c, http.StatusOK)
+}
+
+// RestoreUser undoes the soft delete of a user.
+func (h *UserHandler) RestoreUser(c *gin.Context) {
+	userId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err, "Invalid user ID")
+		return
+	}
+
+	user, err := h.service.RestoreUser(c, userId)
+	if err != nil {
+		log.Println("Failed to restore user", err)
+		response.Error(c, http.StatusInternalServerError, err, "Failed to restore user")
+		return
+	}
+
+	var res dto.User
+	utils.MapStruct(&res, user)
+	response.JSON(c, http.StatusOK, res)
+}
+
+// allowIncludeDeleted rejects include_deleted from callers without the admin
+// token and reports whether the request may go on.
+func allowIncludeDeleted(c *gin.Context, includeDeleted bool) bool {
+	if includeDeleted && !middleware.IsAdmin(c) {
+		response.Error(c, http.StatusForbidden, apperror.Forbidden("include_deleted requires an admin token"), "Forbidden")
+		return false
+	}
+	return true
+}
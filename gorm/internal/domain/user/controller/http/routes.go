@@ -1,7 +1,9 @@
 package http
 
 import (
+	"db_blueprints/batch"
 	db "db_blueprints/gorm/database"
+	product_repo "db_blueprints/gorm/internal/domain/product/repository"
 	"db_blueprints/gorm/internal/domain/user/repository"
 	"db_blueprints/gorm/internal/domain/user/service"
 
@@ -13,15 +15,25 @@ func Routes(
 	db db.IDatabase,
 ) {
 	userRepository := repository.NewUserRepository(db)
-	userService := service.NewUserService(userRepository)
+	productRepository := product_repo.NewProductRepository(db)
+	userService := service.NewUserService(db, userRepository, productRepository)
 	userHandler := NewUserHandler(userService)
 
 	userRoute := r.Group("/users")
 	{
 		userRoute.GET("", userHandler.GetUsers)
+		userRoute.GET("/export", userHandler.ExportUsers)
 		userRoute.GET("/:id", userHandler.GetUser)
 		userRoute.POST("", userHandler.CreateUser)
 		userRoute.PUT("/:id", userHandler.UpdateUser)
+		userRoute.PATCH("/:id", userHandler.PatchUser)
 		userRoute.DELETE("/:id", userHandler.DeleteUser)
+		userRoute.POST("/:id/restore", userHandler.RestoreUser)
 	}
+
+	r.POST("/users:action", batch.Actions("action", map[string]gin.HandlerFunc{
+		"batch":       userHandler.BatchCreateUsers,
+		"batchUpdate": userHandler.BatchUpdateUsers,
+		"batchDelete": userHandler.BatchDeleteUsers,
+	}))
 }
@@ -2,19 +2,34 @@ package repository
 
 import (
 	"context"
-	"db_blueprints/config"
+	"db_blueprints/apperror"
+	"db_blueprints/cursor"
 	db "db_blueprints/gorm/database"
 	"db_blueprints/gorm/internal/domain/user/controller/dto"
-	"db_blueprints/gorm/internal/model"
 	"db_blueprints/gorm/pkgs/paging"
+	"db_blueprints/metrics"
+	"db_blueprints/model"
+	"errors"
+	"time"
 )
 
 type IUserRepository interface {
 	ListUsers(ctx context.Context, req *dto.ListUserRequest) ([]*model.User, *paging.Pagination, error)
+	ExportUsers(ctx context.Context, req *dto.ExportUserRequest, fn func(*model.User) error) error
 	GetUserById(ctx context.Context, id int64) (*model.User, error)
+	GetUserByIdUnscoped(ctx context.Context, id int64) (*model.User, error)
 	CreatedUser(ctx context.Context, user *model.User) error
+	CreateUsers(ctx context.Context, users []*model.User, batchSize int) error
 	UpdateUser(ctx context.Context, user *model.User) error
+	UpdateUserColumns(ctx context.Context, user *model.User, columns []string) error
 	DeleteUser(ctx context.Context, user *model.User) error
+	DeleteUsersByIds(ctx context.Context, ids []int64) (int64, error)
+	RestoreUser(ctx context.Context, id int64) error
+	PurgeUsers(ctx context.Context, before time.Time) (int64, error)
+	ExistsByID(ctx context.Context, id int64) (bool, error)
+	ExistsByEmail(ctx context.Context, email string) (bool, error)
+	DeletedIdByEmail(ctx context.Context, email string) (int64, error)
+	ExistingUserIds(ctx context.Context, ids []int64) ([]int64, error)
 }
 
 type UserRepository struct {
@@ -26,31 +41,23 @@ func NewUserRepository(db db.IDatabase) *UserRepository {
 }
 
 func (pr *UserRepository) ListUsers(ctx context.Context, req *dto.ListUserRequest) ([]*model.User, *paging.Pagination, error) {
-	ctx, cancel := context.WithTimeout(ctx, config.DatabaseTimeout)
-	defer cancel()
+	ctx = metrics.WithOperation(ctx, "UserRepository.ListUsers")
+	query := db.NewFilterQueries(req.Filter)
+	search := userSearch(req.Search, req.SearchMode)
 
-	query := make([]db.Query, 0)
-
-	if req.Search != "" {
-		query = append(query, db.NewQuery("name LIKE ?", "%"+req.Search+"%"))
+	if req.Cursor != nil {
+		return pr.listUsersByCursor(ctx, req, query, search)
 	}
 
-	var order string
-	if req.OrderDesc {
-		order = "created_at DESC"
-	} else {
-		order = "created_at"
+	order, err := userSortable.Parse(req.OrderBy, req.OrderDesc, "created_at")
+	if err != nil {
+		return nil, nil, err
 	}
 
-	if req.OrderBy != "" {
-		order = req.OrderBy
-		if req.OrderDesc {
-			order += " DESC"
-		}
-	}
+	filters := userFilters(query, search, req.IncludeDeleted)
 
 	var total int64
-	if err := pr.db.Count(ctx, &model.User{}, &total, db.WithQuery(query...)); err != nil {
+	if err := pr.db.Count(ctx, &model.User{}, &total, filters...); err != nil {
 		return nil, nil, err
 	}
 
@@ -60,10 +67,12 @@ func (pr *UserRepository) ListUsers(ctx context.Context, req *dto.ListUserReques
 	if err := pr.db.Find(
 		ctx,
 		&users,
-		db.WithQuery(query...),
-		db.WithLimit(int(pagination.Size)),
-		db.WithOffset(int(pagination.Skip)),
-		db.WithOrder(order),
+		append(
+			filters,
+			db.WithLimit(int(pagination.Size)),
+			db.WithOffset(int(pagination.Skip)),
+			db.WithOrder(order...),
+		)...,
 	); err != nil {
 		return nil, nil, err
 	}
@@ -71,22 +80,262 @@ func (pr *UserRepository) ListUsers(ctx context.Context, req *dto.ListUserReques
 	return users, pagination, nil
 }
 
+// listUsersByCursor lists users with keyset pagination on top of the
+// filters in query and search. No COUNT query is run in this mode.
+func (pr *UserRepository) listUsersByCursor(ctx context.Context, req *dto.ListUserRequest, query []db.Query, search db.Search) ([]*model.User, *paging.Pagination, error) {
+	if search.Mode == db.SearchFullText {
+		return nil, nil, apperror.Validation("full-text search cannot be combined with a cursor")
+	}
+
+	key, err := userCursorKey(req.OrderBy)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyset, err := cursor.NewKeyset(key, req.OrderDesc, *req.Cursor, paging.PageSize(req.Limit), userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	spec, err := userSortable.Spec(key.Column, keyset.Descending())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	where, args, err := keyset.Where()
+	if err != nil {
+		return nil, nil, err
+	}
+	if where != "" {
+		query = append(query, db.NewQuery(where, args...))
+	}
+
+	var users []*model.User
+	if err := pr.db.Find(
+		ctx,
+		&users,
+		append(
+			userFilters(query, search, req.IncludeDeleted),
+			db.WithLimit(int(keyset.Limit())),
+			db.WithOrder(db.WithTieBreak([]db.SortSpec{spec})...),
+		)...,
+	); err != nil {
+		return nil, nil, err
+	}
+
+	users, page := keyset.Page(users)
+	return users, paging.NewCursorPagination(page), nil
+}
+
+// ExportUsers streams every user matching req to fn without loading the
+// result set into memory.
+func (pr *UserRepository) ExportUsers(ctx context.Context, req *dto.ExportUserRequest, fn func(*model.User) error) error {
+	ctx = metrics.WithOperation(ctx, "UserRepository.ExportUsers")
+	query := db.NewFilterQueries(req.Filter)
+	search := userSearch(req.Search, req.SearchMode)
+
+	order, err := userSortable.Parse(req.OrderBy, req.OrderDesc, "id")
+	if err != nil {
+		return err
+	}
+
+	var user model.User
+	return pr.db.Stream(
+		ctx,
+		&user,
+		func() error {
+			row := user
+			return fn(&row)
+		},
+		append(
+			userFilters(query, search, req.IncludeDeleted),
+			db.WithOrder(db.WithTieBreak(order)...),
+		)...,
+	)
+}
+
 func (pr *UserRepository) GetUserById(ctx context.Context, id int64) (*model.User, error) {
+	ctx = metrics.WithOperation(ctx, "UserRepository.GetUserById")
+	return pr.getUserById(ctx, id)
+}
+
+// GetUserByIdUnscoped returns the user even when it is soft-deleted.
+func (pr *UserRepository) GetUserByIdUnscoped(ctx context.Context, id int64) (*model.User, error) {
+	ctx = metrics.WithOperation(ctx, "UserRepository.GetUserByIdUnscoped")
+	return pr.getUserById(ctx, id, db.WithUnscoped())
+}
+
+func (pr *UserRepository) getUserById(ctx context.Context, id int64, opts ...db.FindOption) (*model.User, error) {
 	var user model.User
-	if err := pr.db.FindById(ctx, id, &user); err != nil {
+	if err := pr.db.FindById(ctx, id, &user, opts...); err != nil {
+		if errors.Is(err, apperror.ErrNotFound) {
+			return nil, apperror.NotFound("user with id %d not found", id)
+		}
 		return nil, err
 	}
 	return &user, nil
 }
 
 func (pr *UserRepository) CreatedUser(ctx context.Context, user *model.User) error {
+	ctx = metrics.WithOperation(ctx, "UserRepository.CreatedUser")
 	return pr.db.Create(ctx, user)
 }
 
+// CreateUsers inserts the users with multi-row INSERTs of up to batchSize
+// rows and sets their IDs.
+func (pr *UserRepository) CreateUsers(ctx context.Context, users []*model.User, batchSize int) error {
+	ctx = metrics.WithOperation(ctx, "UserRepository.CreateUsers")
+	return pr.db.CreateInBatches(ctx, users, batchSize)
+}
+
 func (pr *UserRepository) UpdateUser(ctx context.Context, user *model.User) error {
+	ctx = metrics.WithOperation(ctx, "UserRepository.UpdateUser")
 	return pr.db.Update(ctx, user)
 }
 
+// UpdateUserColumns writes only the named columns of the user.
+func (pr *UserRepository) UpdateUserColumns(ctx context.Context, user *model.User, columns []string) error {
+	ctx = metrics.WithOperation(ctx, "UserRepository.UpdateUserColumns")
+	return pr.db.UpdateColumns(ctx, user, columns)
+}
+
 func (pr *UserRepository) DeleteUser(ctx context.Context, user *model.User) error {
+	ctx = metrics.WithOperation(ctx, "UserRepository.DeleteUser")
 	return pr.db.Delete(ctx, user)
 }
+
+// DeleteUsersByIds soft-deletes the live users among ids.
+func (pr *UserRepository) DeleteUsersByIds(ctx context.Context, ids []int64) (int64, error) {
+	ctx = metrics.WithOperation(ctx, "UserRepository.DeleteUsersByIds")
+	return pr.db.DeleteWhere(ctx, &model.User{}, db.WithQuery(db.NewQuery("id IN ?", ids)))
+}
+
+func (pr *UserRepository) RestoreUser(ctx context.Context, id int64) error {
+	ctx = metrics.WithOperation(ctx, "UserRepository.RestoreUser")
+	restored, err := pr.db.Restore(ctx, &model.User{}, db.WithQuery(db.NewQuery("id = ?", id)))
+	if err != nil {
+		return err
+	}
+	if restored == 0 {
+		return apperror.NotFound("deleted user with id %d not found", id)
+	}
+	return nil
+}
+
+// PurgeUsers removes users soft-deleted before the given time for good. The
+// foreign key cascades to their products.
+func (pr *UserRepository) PurgeUsers(ctx context.Context, before time.Time) (int64, error) {
+	ctx = metrics.WithOperation(ctx, "UserRepository.PurgeUsers")
+	return pr.db.DeleteWhere(
+		ctx,
+		&model.User{},
+		db.WithUnscoped(),
+		db.WithQuery(db.NewQuery("deleted_at IS NOT NULL AND deleted_at < ?", before)),
+	)
+}
+
+func (pr *UserRepository) ExistsByID(ctx context.Context, id int64) (bool, error) {
+	ctx = metrics.WithOperation(ctx, "UserRepository.ExistsByID")
+	var total int64
+	if err := pr.db.Count(ctx, &model.User{}, &total, db.WithQuery(db.NewQuery("id = ?", id))); err != nil {
+		return false, err
+	}
+	return total > 0, nil
+}
+
+// ExistsByEmail reports whether a live user has email. Soft-deleted users
+// keep theirs in the unique index until they are purged, see
+// DeletedIdByEmail.
+func (pr *UserRepository) ExistsByEmail(ctx context.Context, email string) (bool, error) {
+	ctx = metrics.WithOperation(ctx, "UserRepository.ExistsByEmail")
+	var total int64
+	if err := pr.db.Count(ctx, &model.User{}, &total, db.WithQuery(db.NewQuery("email = ?", email))); err != nil {
+		return false, err
+	}
+	return total > 0, nil
+}
+
+// DeletedIdByEmail returns the id of the soft-deleted user with email, or 0
+// when there is none.
+func (pr *UserRepository) DeletedIdByEmail(ctx context.Context, email string) (int64, error) {
+	ctx = metrics.WithOperation(ctx, "UserRepository.DeletedIdByEmail")
+	var users []*model.User
+	if err := pr.db.Find(ctx, &users, db.WithUnscoped(), db.WithQuery(db.NewQuery("email = ? AND deleted_at IS NOT NULL", email))); err != nil {
+		return 0, err
+	}
+	if len(users) == 0 {
+		return 0, nil
+	}
+	return users[0].ID, nil
+}
+
+// ExistingUserIds returns the ids among ids that belong to live users.
+func (pr *UserRepository) ExistingUserIds(ctx context.Context, ids []int64) ([]int64, error) {
+	ctx = metrics.WithOperation(ctx, "UserRepository.ExistingUserIds")
+	var users []*model.User
+	if err := pr.db.Find(ctx, &users, db.WithQuery(db.NewQuery("id IN ?", ids))); err != nil {
+		return nil, err
+	}
+
+	existing := make([]int64, 0, len(users))
+	for _, u := range users {
+		existing = append(existing, u.ID)
+	}
+	return existing, nil
+}
+
+// userFilters are the options narrowing a listing or export down to the
+// requested users.
+func userFilters(query []db.Query, search db.Search, includeDeleted bool) []db.FindOption {
+	opts := []db.FindOption{db.WithQuery(query...), db.WithSearch(search)}
+	if includeDeleted {
+		opts = append(opts, db.WithUnscoped())
+	}
+	return opts
+}
+
+// userSearch searches the columns covered by the full-text index added in
+// migration 000003.
+func userSearch(term, mode string) db.Search {
+	search := db.Search{Term: term, Mode: db.SearchContains, Columns: []string{"name", "email"}}
+	if mode != "" {
+		search.Mode = db.SearchMode(mode)
+	}
+	return search
+}
+
+// userSortable lists the fields users can be ordered by.
+var userSortable = db.RegisterSortable(&model.User{}, map[string]string{
+	"id":         "id",
+	"name":       "name",
+	"email":      "email",
+	"created_at": "created_at",
+	"updated_at": "updated_at",
+})
+
+// userSortKeys are the fields users can be ordered by in cursor mode, keyed
+// by the order_by query value.
+var userSortKeys = map[string]cursor.SortKey[model.User]{
+	"id":         cursor.IntKey("id", userID),
+	"name":       cursor.StringKey("name", func(u *model.User) string { return u.Name }),
+	"email":      cursor.StringKey("email", func(u *model.User) string { return u.Email }),
+	"created_at": cursor.TimeKey("created_at", func(u *model.User) time.Time { return u.CreatedAt }),
+}
+
+// userCursorKey returns the key for cursor mode, which orders by a single
+// field.
+func userCursorKey(orderBy string) (cursor.SortKey[model.User], error) {
+	if orderBy == "" {
+		return userSortKeys["id"], nil
+	}
+
+	key, ok := userSortKeys[orderBy]
+	if !ok {
+		return key, apperror.Validation("cursor pagination cannot sort by %q", orderBy)
+	}
+	return key, nil
+}
+
+func userID(u *model.User) int64 {
+	return u.ID
+}
@@ -2,29 +2,53 @@ package service
 
 import (
 	"context"
+	"db_blueprints/apperror"
+	"db_blueprints/batch"
+	db "db_blueprints/gorm/database"
+	product_repo "db_blueprints/gorm/internal/domain/product/repository"
 	"db_blueprints/gorm/internal/domain/user/controller/dto"
 	"db_blueprints/gorm/internal/domain/user/repository"
-	"db_blueprints/gorm/internal/model"
 	"db_blueprints/gorm/pkgs/paging"
 	"db_blueprints/gorm/utils"
+	"db_blueprints/model"
+	"errors"
 	"log"
+	"time"
+
+	"gorm.io/gorm"
 )
 
 type IUserService interface {
 	ListUsers(ctx context.Context, req *dto.ListUserRequest) ([]*model.User, *paging.Pagination, error)
-	GetUserById(ctx context.Context, id int64) (*model.User, error)
+	ExportUsers(ctx context.Context, req *dto.ExportUserRequest, fn func(*model.User) error) error
+	GetUserById(ctx context.Context, id int64, includeDeleted bool) (*model.User, error)
 	CreateUser(ctx context.Context, req *dto.CreateUserRequest) (*model.User, error)
+	CreateUsers(ctx context.Context, reqs []*dto.CreateUserRequest) ([]*model.User, error)
+	CheckEmail(ctx context.Context, email string) error
 	UpdateUser(ctx context.Context, req *dto.UpdateUserRequest) (*model.User, error)
+	PatchUser(ctx context.Context, id int64, req *dto.PatchUserRequest) (*model.User, []string, error)
+	UpdateUsers(ctx context.Context, items []*dto.BatchUpdateUserItem, allOrNothing bool) ([]*model.User, []error, error)
 	DeleteUser(ctx context.Context, id int64) error
+	DeleteUsers(ctx context.Context, ids []int64, allOrNothing bool) ([]int64, error)
+	RestoreUser(ctx context.Context, id int64) (*model.User, error)
+	PurgeDeleted(ctx context.Context, before time.Time) (int64, error)
 }
 
 type UserService struct {
-	repo repository.IUserRepository
+	db           db.IDatabase
+	repo         repository.IUserRepository
+	product_repo product_repo.IProductRepository
 }
 
-func NewUserService(repo repository.IUserRepository) *UserService {
+func NewUserService(
+	db db.IDatabase,
+	repo repository.IUserRepository,
+	product_repo product_repo.IProductRepository,
+) *UserService {
 	return &UserService{
-		repo: repo,
+		db:           db,
+		repo:         repo,
+		product_repo: product_repo,
 	}
 }
 
@@ -36,19 +60,57 @@ func (pu *UserService) ListUsers(ctx context.Context, req *dto.ListUserRequest)
 	return users, pagination, nil
 }
 
-func (pu *UserService) GetUserById(ctx context.Context, id int64) (*model.User, error) {
-	User, err := pu.repo.GetUserById(ctx, id)
+func (pu *UserService) ExportUsers(ctx context.Context, req *dto.ExportUserRequest, fn func(*model.User) error) error {
+	return pu.repo.ExportUsers(ctx, req, fn)
+}
+
+// GetUserById returns the user; with includeDeleted a soft-deleted one too.
+func (pu *UserService) GetUserById(ctx context.Context, id int64, includeDeleted bool) (*model.User, error) {
+	getUser := pu.repo.GetUserById
+	if includeDeleted {
+		getUser = pu.repo.GetUserByIdUnscoped
+	}
+
+	User, err := getUser(ctx, id)
 	if err != nil {
 		return nil, err
 	}
 	return User, nil
 }
 
+// CreateUser inserts the user and its initial products in one transaction, so
+// a failing product never leaves an orphaned user behind.
 func (pu *UserService) CreateUser(ctx context.Context, req *dto.CreateUserRequest) (*model.User, error) {
-	var user model.User
-	utils.MapStruct(&user, &req)
+	if err := pu.CheckEmail(ctx, req.Email); err != nil {
+		return nil, err
+	}
+
+	user := model.User{
+		Name:  req.Name,
+		Email: req.Email,
+	}
 
-	err := pu.repo.CreatedUser(ctx, &user)
+	err := pu.db.WithTransaction(ctx, func(txDB db.IDatabase) error {
+		ctx := db.ContextWithTx(ctx, txDB)
+
+		if err := pu.repo.CreatedUser(ctx, &user); err != nil {
+			return err
+		}
+
+		for _, item := range req.Products {
+			product := model.Product{
+				Name:    item.Name,
+				Price:   item.Price,
+				OwnerID: user.ID,
+			}
+			if err := pu.product_repo.CreatedProduct(ctx, &product); err != nil {
+				return err
+			}
+			user.Products = append(user.Products, product)
+		}
+
+		return nil
+	})
 	if err != nil {
 		log.Printf("Create fail, error: %s", err)
 		return nil, err
@@ -56,12 +118,69 @@ func (pu *UserService) CreateUser(ctx context.Context, req *dto.CreateUserReques
 	return &user, nil
 }
 
+// CreateUsers inserts the users, then their initial products, in one
+// transaction with CreateInBatches. The users are returned in request order.
+func (pu *UserService) CreateUsers(ctx context.Context, reqs []*dto.CreateUserRequest) ([]*model.User, error) {
+	users := make([]*model.User, 0, len(reqs))
+	for _, req := range reqs {
+		users = append(users, &model.User{
+			Name:  req.Name,
+			Email: req.Email,
+		})
+	}
+
+	err := pu.db.WithTransaction(ctx, func(txDB db.IDatabase) error {
+		ctx := db.ContextWithTx(ctx, txDB)
+
+		if err := pu.repo.CreateUsers(ctx, users, batch.InsertSize); err != nil {
+			return err
+		}
+
+		var products []*model.Product
+		for i, req := range reqs {
+			for _, item := range req.Products {
+				products = append(products, &model.Product{
+					Name:    item.Name,
+					Price:   item.Price,
+					OwnerID: users[i].ID,
+				})
+			}
+		}
+		if len(products) == 0 {
+			return nil
+		}
+		return pu.product_repo.CreateProducts(ctx, products, batch.InsertSize)
+	})
+	if err != nil {
+		log.Printf("Create in batches fail, error: %s", err)
+		return nil, err
+	}
+	return users, nil
+}
+
+// CheckEmail fails with a conflict when email belongs to a soft-deleted user.
+// The email stays taken until the user is purged, so the way to use it again
+// is to restore that user. Live owners are rejected by email_unique.
+func (pu *UserService) CheckEmail(ctx context.Context, email string) error {
+	id, err := pu.repo.DeletedIdByEmail(ctx, email)
+	if err != nil {
+		return err
+	}
+	if id != 0 {
+		return apperror.Conflict("email %q belongs to deleted user %d, restore it with POST /api/users/%d/restore", email, id, id)
+	}
+	return nil
+}
+
 func (pu *UserService) UpdateUser(ctx context.Context, req *dto.UpdateUserRequest) (*model.User, error) {
 	user, err := pu.repo.GetUserById(ctx, req.ID)
 	if err != nil {
 		log.Printf("Get fail, error: %s", err)
 		return nil, err
 	}
+	if req.Version != nil && *req.Version != user.Version {
+		return nil, apperror.VersionConflict("user with id %d is at version %d, not %d", req.ID, user.Version, *req.Version)
+	}
 	utils.MapStruct(user, req)
 
 	err = pu.repo.UpdateUser(ctx, user)
@@ -73,15 +192,169 @@ func (pu *UserService) UpdateUser(ctx context.Context, req *dto.UpdateUserReques
 	return user, nil
 }
 
+// PatchUser applies the members of a merge patch that differ from the stored
+// user and writes only those columns. It returns the user together with
+// the fields that changed.
+func (pu *UserService) PatchUser(ctx context.Context, id int64, req *dto.PatchUserRequest) (*model.User, []string, error) {
+	user, err := pu.repo.GetUserById(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if req.Version != nil && *req.Version != user.Version {
+		return nil, nil, apperror.VersionConflict("user with id %d is at version %d, not %d", id, user.Version, *req.Version)
+	}
+
+	changed := []string{}
+	if req.Name != nil && *req.Name != user.Name {
+		user.Name = *req.Name
+		changed = append(changed, "name")
+	}
+	if req.Email != nil && *req.Email != user.Email {
+		user.Email = *req.Email
+		changed = append(changed, "email")
+	}
+
+	if err := pu.repo.UpdateUserColumns(ctx, user, changed); err != nil {
+		log.Printf("Patch fail, id: %d, error: %s", id, err)
+		return nil, nil, err
+	}
+
+	return user, changed, nil
+}
+
+// DeleteUser soft-deletes the user together with its products, in one
+// transaction. The rows stay in the database until the purge job removes
+// them, so RestoreUser can bring both back.
+// UpdateUsers patches the users of items in one transaction, each item in a
+// savepoint of its own, and returns the updated users and the error of each
+// item in request order. With allOrNothing, nothing is written when any item
+// fails.
+func (pu *UserService) UpdateUsers(ctx context.Context, items []*dto.BatchUpdateUserItem, allOrNothing bool) ([]*model.User, []error, error) {
+	users := make([]*model.User, len(items))
+	errs := make([]error, len(items))
+	err := pu.db.WithTransaction(ctx, func(txDB db.IDatabase) error {
+		ctx := db.ContextWithTx(ctx, txDB)
+
+		failed := false
+		for i, item := range items {
+			req := &dto.PatchUserRequest{Email: item.Email, Name: item.Name, Version: item.Version}
+			errs[i] = pu.db.WithTransaction(ctx, func(txDB db.IDatabase) error {
+				var err error
+				users[i], _, err = pu.PatchUser(db.ContextWithTx(ctx, txDB), item.ID, req)
+				return err
+			})
+			failed = failed || errs[i] != nil
+		}
+		if allOrNothing && failed {
+			return batch.ErrAborted
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, batch.ErrAborted) {
+		log.Printf("Update in batches fail, error: %s", err)
+		return nil, nil, err
+	}
+	return users, errs, nil
+}
+
 func (pu *UserService) DeleteUser(ctx context.Context, id int64) error {
 	User, err := pu.repo.GetUserById(ctx, id)
 	if err != nil {
 		return err
 	}
 
-	if err := pu.repo.DeleteUser(ctx, User); err != nil {
+	return pu.db.WithTransaction(ctx, func(txDB db.IDatabase) error {
+		ctx := db.ContextWithTx(ctx, txDB)
+
+		if err := pu.repo.DeleteUser(ctx, User); err != nil {
+			return err
+		}
+		if _, err := pu.product_repo.DeleteProductsByOwner(ctx, id); err != nil {
+			return err
+		}
+		return nil
+	})
+}
+
+// DeleteUsers soft-deletes the users with the given ids, together with their
+// products, in one transaction and returns the ids that matched no live user.
+// With allOrNothing, nothing is deleted when any id is missing.
+func (pu *UserService) DeleteUsers(ctx context.Context, ids []int64, allOrNothing bool) ([]int64, error) {
+	var missing []int64
+	err := pu.db.WithTransaction(ctx, func(txDB db.IDatabase) error {
+		ctx := db.ContextWithTx(ctx, txDB)
+
+		existing, err := pu.repo.ExistingUserIds(ctx, ids)
+		if err != nil {
+			return err
+		}
+		missing = missingIDs(ids, existing)
+		if len(existing) == 0 || (allOrNothing && len(missing) > 0) {
+			return nil
+		}
+
+		if _, err := pu.repo.DeleteUsersByIds(ctx, existing); err != nil {
+			return err
+		}
+		_, err = pu.product_repo.DeleteProductsByOwners(ctx, existing)
 		return err
+	})
+	if err != nil {
+		log.Printf("Delete in batches fail, error: %s", err)
+		return nil, err
 	}
+	return missing, nil
+}
 
-	return nil
+// RestoreUser undoes a soft delete, together with the products deleted along
+// with the user. Products deleted earlier on their own stay deleted.
+func (pu *UserService) RestoreUser(ctx context.Context, id int64) (*model.User, error) {
+	user, err := pu.repo.GetUserByIdUnscoped(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !user.DeletedAt.Valid {
+		return nil, apperror.NotFound("deleted user with id %d not found", id)
+	}
+
+	err = pu.db.WithTransaction(ctx, func(txDB db.IDatabase) error {
+		ctx := db.ContextWithTx(ctx, txDB)
+
+		if err := pu.repo.RestoreUser(ctx, id); err != nil {
+			return err
+		}
+		if _, err := pu.product_repo.RestoreProductsByOwner(ctx, id, user.DeletedAt.Time); err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Restore fail, id: %d, error: %s", id, err)
+		return nil, err
+	}
+
+	user.DeletedAt = gorm.DeletedAt{}
+	return user, nil
+}
+
+// PurgeDeleted removes users soft-deleted before the given time for good,
+// and with them their products.
+func (pu *UserService) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	return pu.repo.PurgeUsers(ctx, before)
+}
+
+// missingIDs returns the ids that are not in existing, in order.
+func missingIDs(ids, existing []int64) []int64 {
+	found := make(map[int64]bool, len(existing))
+	for _, id := range existing {
+		found[id] = true
+	}
+
+	var missing []int64
+	for _, id := range ids {
+		if !found[id] {
+			missing = append(missing, id)
+		}
+	}
+	return missing
 }
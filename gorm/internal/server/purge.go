@@ -0,0 +1,52 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	productRepo "db_blueprints/gorm/internal/domain/product/repository"
+	productService "db_blueprints/gorm/internal/domain/product/service"
+	userRepo "db_blueprints/gorm/internal/domain/user/repository"
+	userService "db_blueprints/gorm/internal/domain/user/service"
+)
+
+// runPurge removes rows that were soft-deleted longer than
+// SOFT_DELETE_RETENTION ago, once at start and then every PURGE_INTERVAL,
+// until ctx is done. A zero interval disables it.
+func (s Server) runPurge(ctx context.Context) {
+	if s.cfg.PURGE_INTERVAL <= 0 {
+		return
+	}
+
+	products := productRepo.NewProductRepository(s.db)
+	users := userRepo.NewUserRepository(s.db)
+	productSvc := productService.NewProductService(s.db, products, users)
+	userSvc := userService.NewUserService(s.db, users, products)
+
+	ticker := time.NewTicker(s.cfg.PURGE_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		before := time.Now().UTC().Add(-s.cfg.SOFT_DELETE_RETENTION)
+
+		// Products first: purging a user cascades to its products, which
+		// would hide them from the count.
+		if n, err := productSvc.PurgeDeleted(ctx, before); err != nil {
+			log.Printf("Purge products: %v", err)
+		} else if n > 0 {
+			log.Printf("Purged %d deleted products", n)
+		}
+		if n, err := userSvc.PurgeDeleted(ctx, before); err != nil {
+			log.Printf("Purge users: %v", err)
+		} else if n > 0 {
+			log.Printf("Purged %d deleted users", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
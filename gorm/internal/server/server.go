@@ -1,44 +1,134 @@
 package server
 
 import (
+	"context"
 	"db_blueprints/config"
 	db "db_blueprints/gorm/database"
+	"db_blueprints/health"
+	"db_blueprints/metrics"
+	"db_blueprints/middleware"
+	"db_blueprints/validation"
 	"fmt"
 	"log"
+	"net"
+	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
 	httpProduct "db_blueprints/gorm/internal/domain/product/controller/http"
 	httpUser "db_blueprints/gorm/internal/domain/user/controller/http"
+	userRepo "db_blueprints/gorm/internal/domain/user/repository"
 )
 
 type Server struct {
-	engine *gin.Engine
-	cfg    *config.Config
-	db     db.IDatabase
+	engine  *gin.Engine
+	cfg     *config.Config
+	db      db.IDatabase
+	health  *health.Checker
+	metrics *metrics.Metrics
 }
 
 func NewServer(db db.IDatabase, cfg *config.Config) *Server {
+	validation.Setup()
+
+	// A nil pool is reported by the readiness check.
+	sqlDB, err := db.GetDB().DB()
+	if err != nil {
+		log.Println("Cannot get connection pool:", err)
+	}
+	m := metrics.New("gorm", sqlDB, db.Replicas())
+	if err := db.Observe(m.ObserveQuery); err != nil {
+		log.Println("Cannot observe queries:", err)
+	}
+
+	engine := gin.Default()
+	engine.Use(middleware.Metrics(m))
+	engine.Use(middleware.RequestID())
+	engine.Use(middleware.Admin(cfg.ADMIN_TOKEN))
+	engine.Use(middleware.ReadYourWrites())
+
 	return &Server{
-		engine: gin.Default(),
-		cfg:    cfg,
-		db:     db,
+		engine:  engine,
+		cfg:     cfg,
+		db:      db,
+		health:  health.New(sqlDB, cfg.Driver(), db.Replicas()),
+		metrics: m,
 	}
 }
 
-func (s Server) Run() error {
+// Run serves HTTP on HTTP_PORT until ctx is done. It then fails readiness
+// checks for SHUTDOWN_DELAY, stops accepting connections and gives in-flight
+// requests up to SHUTDOWN_TIMEOUT to finish.
+// The purge job stops with it, so the database can be closed once Run
+// returns.
+func (s Server) Run(ctx context.Context) error {
 	if err := s.MapRoutes(); err != nil {
-		log.Printf("MapRoutes Error: %v", err)
+		return fmt.Errorf("map routes: %w", err)
+	}
+
+	srv := &http.Server{
+		Addr:         fmt.Sprintf(":%s", s.cfg.HTTP_PORT),
+		Handler:      s.engine,
+		ReadTimeout:  s.cfg.HTTP_READ_TIMEOUT,
+		WriteTimeout: s.cfg.HTTP_WRITE_TIMEOUT,
+		IdleTimeout:  s.cfg.HTTP_IDLE_TIMEOUT,
+	}
+
+	purgeCtx, stopPurge := context.WithCancel(ctx)
+	purgeDone := make(chan struct{})
+	go func() {
+		defer close(purgeDone)
+		s.runPurge(purgeCtx)
+	}()
+	defer func() {
+		stopPurge()
+		<-purgeDone
+	}()
+
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return fmt.Errorf("listen: %w", err)
 	}
 
-	if err := s.engine.Run(fmt.Sprintf(":%s", s.cfg.HTTP_PORT)); err != nil {
-		log.Printf("Running HTTP server: %v", err)
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.Serve(ln)
+	}()
+	s.health.SetState(health.Serving)
+	log.Printf("Listening on %s", srv.Addr)
+
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("serve http: %w", err)
+	case <-ctx.Done():
 	}
 
+	s.health.SetState(health.Draining)
+	if s.cfg.SHUTDOWN_DELAY > 0 {
+		log.Printf("Draining, failing readiness checks for %s", s.cfg.SHUTDOWN_DELAY)
+		time.Sleep(s.cfg.SHUTDOWN_DELAY)
+	}
+
+	log.Println("Shutting down, waiting for in-flight requests")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.cfg.SHUTDOWN_TIMEOUT)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("shut down http server: %w", err)
+	}
 	return nil
 }
 
 func (s Server) MapRoutes() error {
+	if err := validation.RegisterUserRules(userRepo.NewUserRepository(s.db)); err != nil {
+		return err
+	}
+
+	s.engine.GET("/healthz", s.healthz)
+	s.engine.GET("/readyz", s.readyz)
+	s.engine.GET("/metrics", gin.WrapH(s.metrics.Handler()))
+
 	routesV1 := s.engine.Group("/api")
 
 	httpProduct.Routes(routesV1, s.db)
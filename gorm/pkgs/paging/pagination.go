@@ -1,6 +1,9 @@
 package paging
 
-import "math"
+import (
+	"db_blueprints/cursor"
+	"math"
+)
 
 const (
 	DefaultPageSize int64 = 20
@@ -9,12 +12,15 @@ const (
 type Pagination struct {
 	Page        int64 `json:"page"`
 	Size        int64 `json:"size"`
-	TakeAll     bool  `json:"take_all"`
 	Skip        int64 `json:"skip"`
 	TotalCount  int64 `json:"total_count"`
 	TotalPages  int64 `json:"total_pages"`
 	HasPrevious bool  `json:"has_previous"`
 	HasNext     bool  `json:"has_next"`
+
+	// Set in cursor mode only, where the total counts are not computed.
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
 }
 
 func NewPagination(page int64, size int64, total int64) *Pagination {
@@ -58,3 +64,23 @@ func NewPagination(page int64, size int64, total int64) *Pagination {
 
 	return &pageInfo
 }
+
+// NewCursorPagination returns the metadata of a page of keyset pagination,
+// which has no page numbers or total counts.
+func NewCursorPagination(page cursor.Page) *Pagination {
+	return &Pagination{
+		Size:        page.Size,
+		NextCursor:  page.NextCursor,
+		PrevCursor:  page.PrevCursor,
+		HasNext:     page.NextCursor != "",
+		HasPrevious: page.PrevCursor != "",
+	}
+}
+
+// PageSize returns size, or DefaultPageSize when size is not set.
+func PageSize(size int64) int64 {
+	if size <= 0 {
+		return DefaultPageSize
+	}
+	return size
+}
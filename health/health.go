@@ -0,0 +1,232 @@
+// Package health reports whether a server can take traffic: whether it is
+// up at all, and whether the database it depends on is reachable and
+// migrated.
+package health
+
+import (
+	"context"
+	"database/sql"
+	"db_blueprints/dbpool"
+	"db_blueprints/migration"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultTimeout bounds a readiness check. Probes usually give up after a
+// few seconds, so a slow database is reported as down rather than hanging.
+const DefaultTimeout = time.Second * 2
+
+// State is where a server is in its life.
+type State int32
+
+const (
+	Starting State = iota
+	Serving
+	Draining
+)
+
+// Status is the state of a dependency.
+type Status string
+
+const (
+	Up   Status = "up"
+	Down Status = "down"
+)
+
+// Report is the body of a readiness check. Status is "ready" when the server
+// is serving and every dependency is up, otherwise "starting", "draining" or
+// "not_ready". Replicas are listed but never make a server unready, since
+// reads fail over to the primary.
+//
+// The errors of a report are generic, since driver errors can name hosts or
+// parts of the DSN. WithDetails adds the error text of the driver.
+type Report struct {
+	Status     string           `json:"status"`
+	Database   DatabaseCheck    `json:"database"`
+	Migrations MigrationsCheck  `json:"migrations"`
+	Replicas   []dbpool.Replica `json:"replicas,omitempty"`
+
+	replicaErrors []string
+}
+
+// Ready reports whether the server should get traffic.
+func (r Report) Ready() bool {
+	return r.Status == "ready"
+}
+
+// WithDetails returns a copy of r whose errors carry the error text of the
+// driver and the migrator. It is meant for developers and admins only.
+func (r Report) WithDetails() Report {
+	if r.Database.err != nil {
+		r.Database.Error = r.Database.err.Error()
+	}
+	if r.Migrations.err != nil {
+		r.Migrations.Error = r.Migrations.err.Error()
+	}
+	r.Replicas = append([]dbpool.Replica(nil), r.Replicas...)
+	for i := range r.Replicas {
+		r.Replicas[i].Error = r.replicaErrors[i]
+	}
+	return r
+}
+
+type DatabaseCheck struct {
+	Status    Status  `json:"status"`
+	Error     string  `json:"error,omitempty"`
+	LatencyMs float64 `json:"latency_ms"`
+	Pool      Pool    `json:"pool"`
+
+	err error
+}
+
+// Pool is the part of sql.DBStats worth watching.
+type Pool struct {
+	MaxOpen           int     `json:"max_open"`
+	Open              int     `json:"open"`
+	InUse             int     `json:"in_use"`
+	Idle              int     `json:"idle"`
+	WaitCount         int64   `json:"wait_count"`
+	WaitDurationMs    float64 `json:"wait_duration_ms"`
+	MaxIdleClosed     int64   `json:"max_idle_closed"`
+	MaxLifetimeClosed int64   `json:"max_lifetime_closed"`
+}
+
+// MigrationsCheck is down while the database is dirty or behind the
+// migrations embedded in the binary. A database ahead of them is up, so an
+// older instance keeps serving during a rolling deploy.
+type MigrationsCheck struct {
+	Status  Status `json:"status"`
+	Error   string `json:"error,omitempty"`
+	Version uint64 `json:"version"`
+	Latest  uint64 `json:"latest"`
+	Dirty   bool   `json:"dirty"`
+
+	err error
+}
+
+// Checker checks the database of a server and tracks the server's state.
+type Checker struct {
+	db       *sql.DB
+	replicas *dbpool.Replicas
+	migrator *migration.Migrator
+	loadErr  error
+	state    atomic.Int32
+
+	// Timeout bounds Check.
+	Timeout time.Duration
+}
+
+// New returns a Checker for db, whose dialect is one of the config.Driver
+// values, and its replicas, which may be nil. The server starts in the
+// Starting state.
+func New(db *sql.DB, dialect string, replicas *dbpool.Replicas) *Checker {
+	c := &Checker{db: db, replicas: replicas, Timeout: DefaultTimeout}
+	if db == nil {
+		c.loadErr = errors.New("no connection pool")
+		return c
+	}
+	c.migrator, c.loadErr = migration.New(db, dialect)
+	return c
+}
+
+// SetState records the state of the server, one of Starting, Serving and
+// Draining.
+func (c *Checker) SetState(state State) {
+	c.state.Store(int32(state))
+}
+
+// Check pings the database and reads its migration version. The checks run
+// in every state, so a draining server still shows what it depends on.
+func (c *Checker) Check(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	report := Report{
+		Database:   c.checkDatabase(ctx),
+		Migrations: c.checkMigrations(ctx),
+	}
+	if c.replicas != nil {
+		report.Replicas = c.replicas.State()
+		report.replicaErrors = make([]string, len(report.Replicas))
+		for i, replica := range report.Replicas {
+			if replica.Error != "" {
+				report.replicaErrors[i] = replica.Error
+				report.Replicas[i].Error = "replica is unreachable"
+			}
+		}
+	}
+
+	switch state := State(c.state.Load()); {
+	case state == Starting:
+		report.Status = "starting"
+	case state == Draining:
+		report.Status = "draining"
+	case report.Database.Status == Down || report.Migrations.Status == Down:
+		report.Status = "not_ready"
+	default:
+		report.Status = "ready"
+	}
+	return report
+}
+
+func (c *Checker) checkDatabase(ctx context.Context) DatabaseCheck {
+	if c.db == nil {
+		return DatabaseCheck{Status: Down, Error: "database is not configured", err: c.loadErr}
+	}
+
+	start := time.Now()
+	err := c.db.PingContext(ctx)
+	check := DatabaseCheck{
+		Status:    Up,
+		LatencyMs: milliseconds(time.Since(start)),
+		Pool:      poolOf(c.db.Stats()),
+	}
+	if err != nil {
+		check.Status = Down
+		check.Error = "database is unreachable"
+		check.err = err
+	}
+	return check
+}
+
+func (c *Checker) checkMigrations(ctx context.Context) MigrationsCheck {
+	if c.loadErr != nil {
+		return MigrationsCheck{Status: Down, Error: "migrations cannot be checked", err: c.loadErr}
+	}
+
+	check := MigrationsCheck{Status: Up, Latest: c.migrator.Latest()}
+	version, dirty, err := c.migrator.Current(ctx)
+	switch {
+	case err != nil:
+		check.Status = Down
+		check.Error = "migration version cannot be read"
+		check.err = err
+	case dirty:
+		check.Status = Down
+		check.Error = fmt.Sprintf("version %d is dirty", version)
+	case version < check.Latest:
+		check.Status = Down
+		check.Error = fmt.Sprintf("database is at version %d, expected %d", version, check.Latest)
+	}
+	check.Version, check.Dirty = version, dirty
+	return check
+}
+
+func poolOf(stats sql.DBStats) Pool {
+	return Pool{
+		MaxOpen:           stats.MaxOpenConnections,
+		Open:              stats.OpenConnections,
+		InUse:             stats.InUse,
+		Idle:              stats.Idle,
+		WaitCount:         stats.WaitCount,
+		WaitDurationMs:    milliseconds(stats.WaitDuration),
+		MaxIdleClosed:     stats.MaxIdleClosed,
+		MaxLifetimeClosed: stats.MaxLifetimeClosed,
+	}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,107 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+	"db_blueprints/migration"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// openDB returns an in-memory SQLite database, migrated to the latest
+// version when migrated is set.
+func openDB(t *testing.T, migrated bool) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	if migrated {
+		m, err := migration.New(db, "sqlite")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Up(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func TestCheckFollowsState(t *testing.T) {
+	c := New(openDB(t, true), "sqlite", nil)
+
+	tests := []struct {
+		state  State
+		status string
+		ready  bool
+	}{
+		{state: Starting, status: "starting"},
+		{state: Serving, status: "ready", ready: true},
+		{state: Draining, status: "draining"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			c.SetState(tt.state)
+			report := c.Check(context.Background())
+			if report.Status != tt.status || report.Ready() != tt.ready {
+				t.Errorf("Check() = %q, ready %v, want %q, ready %v", report.Status, report.Ready(), tt.status, tt.ready)
+			}
+			// The dependencies are still checked while starting or draining.
+			if report.Database.Status != Up || report.Migrations.Status != Up {
+				t.Errorf("database %s and migrations %s, want both up", report.Database.Status, report.Migrations.Status)
+			}
+		})
+	}
+}
+
+func TestCheckMigrationsBehind(t *testing.T) {
+	c := New(openDB(t, false), "sqlite", nil)
+	c.SetState(Serving)
+
+	report := c.Check(context.Background())
+	if report.Ready() {
+		t.Fatal("an unmigrated database is reported ready")
+	}
+	if report.Status != "not_ready" || report.Migrations.Status != Down {
+		t.Errorf("status %q and migrations %s, want not_ready and down", report.Status, report.Migrations.Status)
+	}
+	if report.Migrations.Version != 0 || report.Migrations.Latest == 0 {
+		t.Errorf("migrations at %d of %d, want 0 of the latest", report.Migrations.Version, report.Migrations.Latest)
+	}
+}
+
+func TestCheckDatabaseDown(t *testing.T) {
+	db := openDB(t, true)
+	c := New(db, "sqlite", nil)
+	c.SetState(Serving)
+	db.Close()
+
+	report := c.Check(context.Background())
+	if report.Status != "not_ready" || report.Database.Status != Down {
+		t.Fatalf("status %q and database %s, want not_ready and down", report.Status, report.Database.Status)
+	}
+	if report.Database.Error != "database is unreachable" {
+		t.Errorf("error = %q, want the generic message", report.Database.Error)
+	}
+	if detail := report.WithDetails().Database.Error; !strings.Contains(detail, "closed") {
+		t.Errorf("detailed error = %q, want the driver error", detail)
+	}
+}
+
+func TestCheckWithoutPool(t *testing.T) {
+	c := New(nil, "sqlite", nil)
+	c.SetState(Serving)
+
+	report := c.Check(context.Background())
+	if report.Ready() || report.Database.Status != Down || report.Migrations.Status != Down {
+		t.Errorf("Check() without a pool = %+v, want not ready", report)
+	}
+}
@@ -0,0 +1,66 @@
+// Package mergepatch reads JSON merge patches (RFC 7396) aimed at flat
+// resources, whose members are all scalar, non-nullable fields. On such a
+// resource a patch member either replaces the field or is absent; a null,
+// which would remove the field, and members the resource does not have are
+// rejected.
+package mergepatch
+
+import (
+	"bytes"
+	"db_blueprints/apperror"
+	"encoding/json"
+	"slices"
+	"sort"
+)
+
+// ContentType is the media type of a merge patch. Plain application/json is
+// accepted as well.
+const ContentType = "application/merge-patch+json"
+
+// Patch is a parsed merge patch.
+type Patch struct {
+	members map[string]json.RawMessage
+	data    []byte
+}
+
+// Parse reads a merge patch document. It must be a JSON object: any other
+// value would replace the resource as a whole.
+func Parse(data []byte) (*Patch, error) {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(data, &members); err != nil || members == nil {
+		return nil, apperror.Validation("merge patch must be a JSON object")
+	}
+	return &Patch{members: members, data: data}, nil
+}
+
+// Check rejects members that are not in fields and members set to null.
+func (p *Patch) Check(fields ...string) error {
+	for _, name := range p.Members() {
+		if !slices.Contains(fields, name) {
+			return apperror.Validation("field %q cannot be patched", name)
+		}
+		if bytes.Equal(bytes.TrimSpace(p.members[name]), []byte("null")) {
+			return apperror.Validation("field %q cannot be removed", name)
+		}
+	}
+	return nil
+}
+
+// Members returns the names of the patched members, sorted.
+func (p *Patch) Members() []string {
+	names := make([]string, 0, len(p.members))
+	for name := range p.members {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Decode unmarshals the patch into v, typically a struct of pointers that
+// stay nil for absent members.
+func (p *Patch) Decode(v any) error {
+	if err := json.Unmarshal(p.data, v); err != nil {
+		return apperror.Wrap(apperror.CodeValidation, err, "merge patch does not match the resource")
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package mergepatch
+
+import (
+	"db_blueprints/apperror"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+var fields = []string{"name", "price", "owner_id"}
+
+type product struct {
+	Name    *string  `json:"name"`
+	Price   *float64 `json:"price"`
+	OwnerID *int64   `json:"owner_id"`
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		members []string
+		wantErr bool
+	}{
+		{name: "object", data: `{"price": 2.5, "name": "Pen"}`, members: []string{"name", "price"}},
+		{name: "empty object", data: `{}`, members: []string{}},
+		{name: "array", data: `[{"name": "Pen"}]`, wantErr: true},
+		{name: "string", data: `"Pen"`, wantErr: true},
+		{name: "null document", data: `null`, wantErr: true},
+		{name: "empty body", data: ``, wantErr: true},
+		{name: "malformed", data: `{"name": }`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Parse([]byte(tt.data))
+			if tt.wantErr {
+				if !errors.Is(err, apperror.ErrValidation) {
+					t.Fatalf("Parse(%s) error = %v, want a validation error", tt.data, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse(%s) failed: %v", tt.data, err)
+			}
+			if got := p.Members(); !reflect.DeepEqual(got, tt.members) {
+				t.Errorf("Members() = %v, want %v", got, tt.members)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{name: "known fields", data: `{"name": "Pen", "price": 2.5}`},
+		{name: "empty patch", data: `{}`},
+		{name: "zero values are kept", data: `{"name": "", "price": 0}`},
+		{name: "unknown field", data: `{"name": "Pen", "color": "red"}`, wantErr: `field "color" cannot be patched`},
+		{name: "read-only field", data: `{"id": 2}`, wantErr: `field "id" cannot be patched`},
+		{name: "field names are case sensitive", data: `{"Name": "Pen"}`, wantErr: `field "Name" cannot be patched`},
+		{name: "null member", data: `{"price": null}`, wantErr: `field "price" cannot be removed`},
+		{name: "null member with spaces", data: `{"price":  null }`, wantErr: `field "price" cannot be removed`},
+		{name: "unknown null member", data: `{"color": null}`, wantErr: `field "color" cannot be patched`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Parse([]byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = p.Check(fields...)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Check() failed: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, apperror.ErrValidation) || err.Error() != tt.wantErr {
+				t.Errorf("Check() error = %v, want validation error %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    product
+		wantErr bool
+	}{
+		{name: "absent members stay nil", data: `{"name": "Pen"}`, want: product{Name: ptr("Pen")}},
+		{name: "every member", data: `{"name": "Pen", "price": 2.5, "owner_id": 3}`,
+			want: product{Name: ptr("Pen"), Price: ptr(2.5), OwnerID: ptr(int64(3))}},
+		{name: "zero value is set", data: `{"price": 0}`, want: product{Price: ptr(0.0)}},
+		{name: "wrong type", data: `{"price": "cheap"}`, wantErr: true},
+		{name: "fraction for an integer", data: `{"owner_id": 1.5}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Parse([]byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got product
+			err = p.Decode(&got)
+			if tt.wantErr {
+				if !errors.Is(err, apperror.ErrValidation) {
+					t.Fatalf("Decode() error = %v, want a validation error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Decode() failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decode() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
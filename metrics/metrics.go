@@ -0,0 +1,114 @@
+// Package metrics defines the Prometheus metrics of the servers. Both
+// blueprints record the same series, labelled with their name, so they can be
+// compared under the same load.
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"db_blueprints/dbpool"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// OtherOperation labels the statements that run outside a repository method.
+const OtherOperation = "other"
+
+type operationKey struct{}
+
+// WithOperation returns a copy of ctx whose statements are recorded under
+// the repository method op, e.g. "ProductRepository.GetByID". Every
+// repository method sets it first thing.
+func WithOperation(ctx context.Context, op string) context.Context {
+	return context.WithValue(ctx, operationKey{}, op)
+}
+
+// Operation returns the repository method set on ctx by WithOperation, or
+// OtherOperation.
+func Operation(ctx context.Context) string {
+	if op, ok := ctx.Value(operationKey{}).(string); ok {
+		return op
+	}
+	return OtherOperation
+}
+
+// Metrics holds the collectors of one server in a registry of its own.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	queryDuration   *prometheus.HistogramVec
+	queryErrors     *prometheus.CounterVec
+}
+
+// New returns the metrics of the blueprint named blueprint, whose primary is
+// db and whose replicas may be nil. The pool statistics of both are exported
+// as go_sql_* gauges, with db_name set to "primary" or the replica address.
+func New(blueprint string, db *sql.DB, replicas *dbpool.Replicas) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "HTTP requests by method, route and status.",
+		}, []string{"method", "route", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Time to handle an HTTP request, by method, route and status.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "db_query_duration_seconds",
+			Help:    "Time until the database answers a statement, by repository method.",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+		}, []string{"operation"}),
+		queryErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "db_query_errors_total",
+			Help: "Statements that failed, by repository method.",
+		}, []string{"operation"}),
+	}
+
+	reg := prometheus.WrapRegistererWith(prometheus.Labels{"blueprint": blueprint}, m.registry)
+	reg.MustRegister(m.requests, m.requestDuration, m.queryDuration, m.queryErrors)
+	if db != nil {
+		reg.MustRegister(collectors.NewDBStatsCollector(db, "primary"))
+	}
+	if replicas != nil {
+		for i, replica := range replicas.State() {
+			reg.MustRegister(collectors.NewDBStatsCollector(replicas.DB(i), replica.Addr))
+		}
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// ObserveRequest records a handled request. route is the route pattern, such
+// as /api/products/:id, so that ids do not each get their own series.
+func (m *Metrics) ObserveRequest(method, route string, status int, duration time.Duration) {
+	labels := prometheus.Labels{"method": method, "route": route, "status": strconv.Itoa(status)}
+	m.requests.With(labels).Inc()
+	m.requestDuration.With(labels).Observe(duration.Seconds())
+}
+
+// ObserveQuery records a statement that ran with ctx, started at start and
+// failed with err, if not nil. It is labelled with the Operation of ctx.
+func (m *Metrics) ObserveQuery(ctx context.Context, start time.Time, err error) {
+	op := Operation(ctx)
+	m.queryDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	if err != nil {
+		m.queryErrors.WithLabelValues(op).Inc()
+	}
+}
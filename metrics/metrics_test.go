@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// scrape returns the exposition of m.
+func scrape(t *testing.T, m *Metrics) string {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("scrape = %d: %s", w.Code, w.Body)
+	}
+	body, err := io.ReadAll(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestMetrics(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	m := New("db_sql", db, nil)
+	m.ObserveRequest(http.MethodGet, "/api/products/:id", http.StatusOK, time.Millisecond)
+	m.ObserveRequest(http.MethodGet, "/api/products/:id", http.StatusOK, time.Millisecond)
+	m.ObserveRequest(http.MethodGet, "/api/products/:id", http.StatusNotFound, time.Millisecond)
+
+	ctx := WithOperation(context.Background(), "ProductRepository.GetByID")
+	m.ObserveQuery(ctx, time.Now(), nil)
+	m.ObserveQuery(ctx, time.Now(), errors.New("fail"))
+	m.ObserveQuery(context.Background(), time.Now(), nil)
+
+	body := scrape(t, m)
+	for _, line := range []string{
+		`http_requests_total{blueprint="db_sql",method="GET",route="/api/products/:id",status="200"} 2`,
+		`http_requests_total{blueprint="db_sql",method="GET",route="/api/products/:id",status="404"} 1`,
+		`http_request_duration_seconds_count{blueprint="db_sql",method="GET",route="/api/products/:id",status="200"} 2`,
+		`db_query_duration_seconds_count{blueprint="db_sql",operation="ProductRepository.GetByID"} 2`,
+		`db_query_duration_seconds_count{blueprint="db_sql",operation="other"} 1`,
+		`db_query_errors_total{blueprint="db_sql",operation="ProductRepository.GetByID"} 1`,
+		`go_sql_max_open_connections{blueprint="db_sql",db_name="primary"} 0`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics lack %s", line)
+		}
+	}
+	if strings.Contains(body, `db_query_errors_total{blueprint="db_sql",operation="other"}`) {
+		t.Error("a query without errors was counted as failed")
+	}
+}
+
+func TestOperation(t *testing.T) {
+	if op := Operation(context.Background()); op != OtherOperation {
+		t.Errorf("Operation() of a plain context = %q, want %q", op, OtherOperation)
+	}
+	ctx := WithOperation(context.Background(), "UserRepository.List")
+	if op := Operation(ctx); op != "UserRepository.List" {
+		t.Errorf("Operation() = %q, want UserRepository.List", op)
+	}
+}
@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const AdminKey = "is_admin"
+
+// Admin marks requests that carry "Authorization: Bearer <token>" as admin
+// requests. It never rejects a request; handlers decide what needs an admin
+// with IsAdmin. An empty token disables admin access.
+func Admin(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		bearer, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if ok && token != "" && subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) == 1 {
+			c.Set(AdminKey, true)
+		}
+		c.Next()
+	}
+}
+
+// IsAdmin reports whether Admin accepted the request's token.
+func IsAdmin(c *gin.Context) bool {
+	return c.GetBool(AdminKey)
+}
@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"db_blueprints/metrics"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Metrics records the count and duration of requests by route and status.
+// Requests that match no route are recorded under "unmatched".
+func Metrics(m *metrics.Metrics) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		m.ObserveRequest(c.Request.Method, route, c.Writer.Status(), time.Since(start))
+	}
+}
@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"db_blueprints/dbpool"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReadYourWrites sends every read of a request that may write, anything but
+// GET, HEAD and OPTIONS, to the primary. A lagging replica would otherwise
+// answer the reads that follow a write with the rows as they were before it.
+func ReadYourWrites() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			c.Request = c.Request.WithContext(dbpool.WithPrimary(c.Request.Context()))
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,107 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// Usage documents the arguments accepted by Command.
+const Usage = `usage: migrate <command> [arg]
+
+commands:
+  up           apply all pending migrations
+  down [N]     revert the last N migrations (default 1, "all" for every one)
+  goto V       migrate up or down to version V
+  force V      record version V as applied without running SQL
+  version      print the current version
+  status       list migrations and their state`
+
+// Command runs the migrate subcommand described by args, for example
+// ["up"] or ["goto", "2"], and writes its output to out.
+func Command(ctx context.Context, m *Migrator, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing command\n%s", Usage)
+	}
+
+	switch cmd, rest := args[0], args[1:]; cmd {
+	case "up":
+		return m.Up(ctx)
+
+	case "down":
+		steps := 1
+		if len(rest) > 0 {
+			if rest[0] == "all" {
+				steps = 0
+			} else if n, err := strconv.Atoi(rest[0]); err == nil && n > 0 {
+				steps = n
+			} else {
+				return fmt.Errorf("invalid step count %q\n%s", rest[0], Usage)
+			}
+		}
+		return m.Down(ctx, steps)
+
+	case "goto", "force":
+		if len(rest) == 0 {
+			return fmt.Errorf("%s needs a version\n%s", cmd, Usage)
+		}
+		version, err := strconv.ParseUint(rest[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid version %q\n%s", rest[0], Usage)
+		}
+		if cmd == "goto" {
+			return m.Goto(ctx, version)
+		}
+		return m.Force(ctx, version)
+
+	case "version":
+		version, dirty, err := m.Version(ctx)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			fmt.Fprintf(out, "%d (dirty)\n", version)
+		} else {
+			fmt.Fprintln(out, version)
+		}
+		return nil
+
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tSTATE\tAPPLIED AT")
+		for _, s := range statuses {
+			appliedAt := "-"
+			if s.Applied {
+				appliedAt = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", s.Version, s.Name, s.state(), appliedAt)
+		}
+		return w.Flush()
+
+	default:
+		return fmt.Errorf("unknown command %q\n%s", cmd, Usage)
+	}
+}
+
+func (s Status) state() string {
+	switch {
+	case s.Dirty:
+		return "dirty"
+	case s.Applied && s.Up == "":
+		return "applied (missing file)"
+	case s.Modified:
+		return "applied (modified)"
+	case s.Applied:
+		return "applied"
+	default:
+		return "pending"
+	}
+}
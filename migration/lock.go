@@ -0,0 +1,69 @@
+package migration
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"time"
+)
+
+// lockName identifies the migration lock. MySQL locks are server wide, so
+// the database name is prepended to it at lock time.
+const lockName = "db_blueprints:" + HistoryTable
+
+var errLockTimeout = errors.New("timed out waiting for the migration lock")
+
+// lock takes a session-level advisory lock on conn and returns the function
+// that releases it. SQLite has no advisory locks; it allows a single writer,
+// which serialises concurrent migrators well enough.
+func (m *Migrator) lock(ctx context.Context, conn *sql.Conn) (func(), error) {
+	switch m.dialect {
+	case "mysql":
+		var acquired sql.NullInt64
+		err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(CONCAT(DATABASE(), ':', ?), ?)", lockName, lockSeconds(m.LockTimeout)).Scan(&acquired)
+		if err != nil {
+			return nil, fmt.Errorf("acquire migration lock: %w", err)
+		}
+		if acquired.Int64 != 1 {
+			return nil, errLockTimeout
+		}
+		return func() {
+			conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(CONCAT(DATABASE(), ':', ?))", lockName)
+		}, nil
+
+	case "postgres":
+		lockCtx, cancel := context.WithTimeout(ctx, m.LockTimeout)
+		defer cancel()
+
+		key := lockKey()
+		if _, err := conn.ExecContext(lockCtx, "SELECT pg_advisory_lock($1)", key); err != nil {
+			if errors.Is(lockCtx.Err(), context.DeadlineExceeded) {
+				return nil, errLockTimeout
+			}
+			return nil, fmt.Errorf("acquire migration lock: %w", err)
+		}
+		return func() {
+			conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", key)
+		}, nil
+
+	default:
+		return func() {}, nil
+	}
+}
+
+// lockSeconds converts timeout to the whole seconds GET_LOCK takes, rounding
+// up so that a timeout under a second still waits instead of failing at once.
+func lockSeconds(timeout time.Duration) int {
+	return int(math.Ceil(timeout.Seconds()))
+}
+
+// lockKey derives the bigint key PostgreSQL advisory locks take from
+// lockName. The locks are per database, so no database name is needed.
+func lockKey() int64 {
+	h := fnv.New64a()
+	h.Write([]byte(lockName))
+	return int64(h.Sum64())
+}
@@ -0,0 +1,193 @@
+// Package migration embeds the SQL migrations and applies them in process.
+//
+// Migrations live in one directory per dialect (mysql, postgres, sqlite) and
+// follow the golang-migrate naming scheme, NNNNNN_name.up.sql and
+// NNNNNN_name.down.sql, so the Makefile targets keep working on the same
+// files.
+package migration
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+//go:embed mysql/*.sql postgres/*.sql sqlite/*.sql
+var files embed.FS
+
+// Migration is one versioned schema change.
+type Migration struct {
+	Version  uint64
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+var filePattern = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Load returns the embedded migrations for dialect, ordered by version.
+func Load(dialect string) ([]Migration, error) {
+	return LoadFS(files, dialect)
+}
+
+// LoadFS reads migrations from the dialect directory of fsys. Every version
+// needs an up file; the down file is optional.
+func LoadFS(fsys fs.FS, dialect string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dialect)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for dialect %q: %w", dialect, err)
+	}
+
+	byVersion := make(map[uint64]*Migration)
+	for _, entry := range entries {
+		match := filePattern.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseUint(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %s: %w", entry.Name(), err)
+		}
+
+		body, err := fs.ReadFile(fsys, dialect+"/"+entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("read migration %s: %w", entry.Name(), err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, m.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			m.Up = string(body)
+			m.Checksum = checksum(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Checksum == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+func checksum(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// statements splits a migration file into single statements so it can run on
+// drivers that reject multi-statement Exec calls, such as MySQL without
+// multiStatements=true. Semicolons are ignored inside quotes, -- and /* */
+// comments and, on PostgreSQL, dollar-quoted bodies such as a function's
+// $$ ... $$. MySQL also has # comments and backslash escapes in strings.
+func statements(dialect, script string) []string {
+	var (
+		result []string
+		start  int
+		code   bool // whether the current statement has more than comments
+	)
+
+	flush := func(end int) {
+		if stmt := strings.TrimSpace(script[start:end]); code && stmt != "" {
+			result = append(result, stmt)
+		}
+		start, code = end+1, false
+	}
+
+	for i := 0; i < len(script); i++ {
+		c := script[i]
+		switch {
+		case c == '-' && strings.HasPrefix(script[i:], "--"),
+			c == '#' && dialect == "mysql":
+			i = skipPast(script, i, "\n") - 1
+		case c == '/' && strings.HasPrefix(script[i:], "/*"):
+			i = skipPast(script, i+2, "*/") - 1
+		case c == '\'' || c == '"' || c == '`':
+			i = skipQuoted(script, i, dialect == "mysql" && c != '`') - 1
+			code = true
+		case c == '$' && dialect == "postgres":
+			if tag, ok := dollarTag(script[i:]); ok {
+				i = skipPast(script, i+len(tag), tag) - 1
+			}
+			code = true
+		case c == ';':
+			flush(i)
+		case !unicode.IsSpace(rune(c)):
+			code = true
+		}
+	}
+	flush(len(script))
+
+	return result
+}
+
+// skipPast returns the index just after the first end at or after i, or the
+// length of script when there is none.
+func skipPast(script string, i int, end string) int {
+	n := strings.Index(script[i:], end)
+	if n < 0 {
+		return len(script)
+	}
+	return i + n + len(end)
+}
+
+// skipQuoted returns the index just after the quoted text starting at i. A
+// doubled quote stands for itself; with backslashes set, so does a quote
+// after a backslash.
+func skipQuoted(script string, i int, backslashes bool) int {
+	quote := script[i]
+	for i++; i < len(script); i++ {
+		switch script[i] {
+		case '\\':
+			if backslashes {
+				i++
+			}
+		case quote:
+			if i+1 < len(script) && script[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(script)
+}
+
+// dollarTag returns the opening tag of a PostgreSQL dollar-quoted string, $$
+// or $name$, at the start of s.
+func dollarTag(s string) (string, bool) {
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '$':
+			return s[:i+1], true
+		case c == '_' || unicode.IsLetter(rune(c)) || (i > 1 && unicode.IsDigit(rune(c))):
+		default:
+			return "", false
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,229 @@
+package migration
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestStatements(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialect string
+		script  string
+		want    []string
+	}{
+		{
+			name:   "splits on semicolons",
+			script: "CREATE TABLE a (id INT);\nCREATE TABLE b (id INT);\n",
+			want:   []string{"CREATE TABLE a (id INT)", "CREATE TABLE b (id INT)"},
+		},
+		{
+			name:   "last statement without semicolon",
+			script: "DROP TABLE a;\nDROP TABLE b",
+			want:   []string{"DROP TABLE a", "DROP TABLE b"},
+		},
+		{
+			name:   "semicolon in single quotes",
+			script: "INSERT INTO notes VALUES ('a;b'); SELECT 1;",
+			want:   []string{"INSERT INTO notes VALUES ('a;b')", "SELECT 1"},
+		},
+		{
+			name:   "escaped single quote",
+			script: "INSERT INTO notes VALUES ('it''s; fine'); SELECT 1;",
+			want:   []string{"INSERT INTO notes VALUES ('it''s; fine')", "SELECT 1"},
+		},
+		{
+			name:   "semicolon in double quotes",
+			script: `CREATE TABLE "a;b" (id INT); SELECT 1;`,
+			want:   []string{`CREATE TABLE "a;b" (id INT)`, "SELECT 1"},
+		},
+		{
+			name:   "semicolon in backticks",
+			script: "CREATE TABLE `a;b` (id INT); SELECT 1;",
+			want:   []string{"CREATE TABLE `a;b` (id INT)", "SELECT 1"},
+		},
+		{
+			name:   "other quotes inside a literal",
+			script: "SELECT 'say \"hi\"; `now`'; SELECT 1;",
+			want:   []string{"SELECT 'say \"hi\"; `now`'", "SELECT 1"},
+		},
+		{
+			name:   "semicolon and quote in a comment",
+			script: "-- don't split; here\nCREATE TABLE a (id INT);",
+			want:   []string{"-- don't split; here\nCREATE TABLE a (id INT)"},
+		},
+		{
+			name:   "trailing comment",
+			script: "CREATE TABLE a (id INT); -- done; really",
+			want:   []string{"CREATE TABLE a (id INT)"},
+		},
+		{
+			name:   "block comment",
+			script: "/* don't split; here */ CREATE TABLE a (id INT); /* done; */",
+			want:   []string{"/* don't split; here */ CREATE TABLE a (id INT)"},
+		},
+		{
+			name:   "block comment over lines",
+			script: "/*\n * a;\n * b;\n */\nCREATE TABLE a (id INT);",
+			want:   []string{"/*\n * a;\n * b;\n */\nCREATE TABLE a (id INT)"},
+		},
+		{
+			name:    "backslash escape on mysql",
+			dialect: "mysql",
+			script:  `INSERT INTO notes VALUES ('it\'s; fine'); SELECT 1;`,
+			want:    []string{`INSERT INTO notes VALUES ('it\'s; fine')`, "SELECT 1"},
+		},
+		{
+			name:    "backslash is literal on postgres",
+			dialect: "postgres",
+			script:  `INSERT INTO paths VALUES ('C:\'); SELECT 1;`,
+			want:    []string{`INSERT INTO paths VALUES ('C:\')`, "SELECT 1"},
+		},
+		{
+			name:    "hash comment on mysql",
+			dialect: "mysql",
+			script:  "# don't split; here\nCREATE TABLE a (id INT);",
+			want:    []string{"# don't split; here\nCREATE TABLE a (id INT)"},
+		},
+		{
+			name:    "dollar-quoted function body",
+			dialect: "postgres",
+			script: "CREATE FUNCTION f() RETURNS trigger AS $$\nBEGIN\n  NEW.version := NEW.version + 1;\n  RETURN NEW;\nEND;\n$$ LANGUAGE plpgsql;\n" +
+				"CREATE FUNCTION g() RETURNS text AS $body$ SELECT 'a;$$b' $body$ LANGUAGE sql;",
+			want: []string{
+				"CREATE FUNCTION f() RETURNS trigger AS $$\nBEGIN\n  NEW.version := NEW.version + 1;\n  RETURN NEW;\nEND;\n$$ LANGUAGE plpgsql",
+				"CREATE FUNCTION g() RETURNS text AS $body$ SELECT 'a;$$b' $body$ LANGUAGE sql",
+			},
+		},
+		{
+			name:    "placeholder is not a dollar quote",
+			dialect: "postgres",
+			script:  "SELECT $1; SELECT 2;",
+			want:    []string{"SELECT $1", "SELECT 2"},
+		},
+		{
+			name:   "only comments and blanks",
+			script: "-- nothing to do\n\n  ;\n/* at all; */\n",
+			want:   nil,
+		},
+		{
+			name:   "empty script",
+			script: "",
+			want:   nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dialect := tt.dialect
+			if dialect == "" {
+				dialect = "sqlite"
+			}
+			if got := statements(dialect, tt.script); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("statements(%q, %q) = %q, want %q", dialect, tt.script, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadFS(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    fstest.MapFS
+		versions []uint64
+		wantErr  string
+	}{
+		{
+			name: "ordered by version",
+			files: fstest.MapFS{
+				"sqlite/000010_b.up.sql":   {Data: []byte("SELECT 10;")},
+				"sqlite/000002_a.up.sql":   {Data: []byte("SELECT 2;")},
+				"sqlite/000002_a.down.sql": {Data: []byte("SELECT -2;")},
+				"sqlite/README.md":         {Data: []byte("not a migration")},
+			},
+			versions: []uint64{2, 10},
+		},
+		{
+			name:    "missing dialect",
+			files:   fstest.MapFS{"mysql/000001_a.up.sql": {Data: []byte("SELECT 1;")}},
+			wantErr: `no migrations for dialect "sqlite"`,
+		},
+		{
+			name:    "down file only",
+			files:   fstest.MapFS{"sqlite/000001_a.down.sql": {Data: []byte("SELECT 1;")}},
+			wantErr: "migration 1_a has no up file",
+		},
+		{
+			name: "two names for one version",
+			files: fstest.MapFS{
+				"sqlite/000001_a.up.sql":   {Data: []byte("SELECT 1;")},
+				"sqlite/000001_b.down.sql": {Data: []byte("SELECT 1;")},
+			},
+			wantErr: "migration 1 has two names",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations, err := LoadFS(tt.files, "sqlite")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadFS() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadFS() failed: %v", err)
+			}
+
+			versions := make([]uint64, 0, len(migrations))
+			for _, m := range migrations {
+				versions = append(versions, m.Version)
+				if m.Checksum != checksum([]byte(m.Up)) {
+					t.Errorf("migration %d has checksum %s of another body", m.Version, m.Checksum)
+				}
+			}
+			if !reflect.DeepEqual(versions, tt.versions) {
+				t.Errorf("versions = %v, want %v", versions, tt.versions)
+			}
+			if migrations[0].Down != "SELECT -2;" {
+				t.Errorf("down of migration 2 = %q, want %q", migrations[0].Down, "SELECT -2;")
+			}
+		})
+	}
+}
+
+func TestEmbeddedStatements(t *testing.T) {
+	for _, dialect := range []string{"mysql", "postgres", "sqlite"} {
+		migrations, err := Load(dialect)
+		if err != nil {
+			t.Fatalf("Load(%q) failed: %v", dialect, err)
+		}
+		for _, m := range migrations {
+			if len(statements(dialect, m.Up)) == 0 {
+				t.Errorf("%s migration %d_%s has no statement", dialect, m.Version, m.Name)
+			}
+		}
+	}
+}
+
+func TestLockSeconds(t *testing.T) {
+	tests := []struct {
+		timeout time.Duration
+		want    int
+	}{
+		{timeout: time.Minute, want: 60},
+		{timeout: 1500 * time.Millisecond, want: 2},
+		{timeout: 200 * time.Millisecond, want: 1},
+		{timeout: 0, want: 0},
+	}
+
+	for _, tt := range tests {
+		if got := lockSeconds(tt.timeout); got != tt.want {
+			t.Errorf("lockSeconds(%v) = %d, want %d", tt.timeout, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,443 @@
+package migration
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HistoryTable records applied migrations. It is separate from the
+// schema_migrations table of golang-migrate so both tools can be pointed at
+// the same database while the Makefile targets are still in use.
+const HistoryTable = "schema_history"
+
+const defaultLockTimeout = time.Minute
+
+var (
+	// ErrDirty means a migration failed half way. Fix the schema by hand and
+	// run force with the last version that is fully applied.
+	ErrDirty = errors.New("database is dirty")
+	// ErrChecksumMismatch means an applied migration file was edited after it
+	// ran. Restore the file, or run force to accept the new contents.
+	ErrChecksumMismatch = errors.New("migration checksum mismatch")
+	// ErrUnknownVersion means a version is not among the loaded migrations.
+	ErrUnknownVersion = errors.New("unknown migration version")
+)
+
+// Applied is a row of the history table.
+type Applied struct {
+	Version   uint64
+	Name      string
+	Checksum  string
+	Dirty     bool
+	AppliedAt time.Time
+}
+
+// Status describes one migration against the history table.
+type Status struct {
+	Migration
+	Applied   bool
+	Dirty     bool
+	Modified  bool
+	AppliedAt time.Time
+}
+
+// Migrator applies migrations to a database. Every operation holds an
+// advisory lock for its whole duration, so instances started together take
+// turns instead of racing on the same migration.
+type Migrator struct {
+	db         *sql.DB
+	dialect    string
+	migrations []Migration
+
+	// LockTimeout bounds the wait for the advisory lock.
+	LockTimeout time.Duration
+	// Logf, when set, is called once for every migration applied or reverted.
+	Logf func(format string, args ...any)
+}
+
+// New returns a Migrator for the embedded migrations of dialect, which is
+// one of the config.Driver values.
+func New(db *sql.DB, dialect string) (*Migrator, error) {
+	migrations, err := Load(dialect)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithMigrations(db, dialect, migrations), nil
+}
+
+// NewWithMigrations returns a Migrator for an explicit list of migrations,
+// which must be ordered by version.
+func NewWithMigrations(db *sql.DB, dialect string, migrations []Migration) *Migrator {
+	return &Migrator{
+		db:          db,
+		dialect:     dialect,
+		migrations:  migrations,
+		LockTimeout: defaultLockTimeout,
+	}
+}
+
+// Up applies every pending migration.
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.run(ctx, func(conn *sql.Conn, applied map[uint64]Applied) error {
+		if err := m.check(applied); err != nil {
+			return err
+		}
+		return m.upTo(ctx, conn, applied, m.latest())
+	})
+}
+
+// Down reverts the last steps applied migrations. A steps value of zero or
+// less reverts all of them.
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	return m.run(ctx, func(conn *sql.Conn, applied map[uint64]Applied) error {
+		if err := m.check(applied); err != nil {
+			return err
+		}
+
+		versions := sortedVersions(applied)
+		target := uint64(0)
+		if steps > 0 && steps < len(versions) {
+			target = versions[len(versions)-steps-1]
+		}
+		return m.downTo(ctx, conn, applied, target)
+	})
+}
+
+// Goto migrates up or down until version is the latest applied migration.
+// Version 0 reverts everything.
+func (m *Migrator) Goto(ctx context.Context, version uint64) error {
+	if version != 0 {
+		if _, ok := m.find(version); !ok {
+			return fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+		}
+	}
+
+	return m.run(ctx, func(conn *sql.Conn, applied map[uint64]Applied) error {
+		if err := m.check(applied); err != nil {
+			return err
+		}
+		if err := m.downTo(ctx, conn, applied, version); err != nil {
+			return err
+		}
+		return m.upTo(ctx, conn, applied, version)
+	})
+}
+
+// Force rewrites the history table so that exactly the migrations up to
+// version are recorded as applied, without running any SQL. It clears the
+// dirty flag and records the current checksums, which is how an edited
+// migration is accepted.
+func (m *Migrator) Force(ctx context.Context, version uint64) error {
+	if version != 0 {
+		if _, ok := m.find(version); !ok {
+			return fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+		}
+	}
+
+	return m.run(ctx, func(conn *sql.Conn, applied map[uint64]Applied) error {
+		if _, err := conn.ExecContext(ctx, m.rebind("DELETE FROM "+HistoryTable+" WHERE version > ?"), version); err != nil {
+			return fmt.Errorf("force version %d: %w", version, err)
+		}
+
+		for _, mig := range m.migrations {
+			if mig.Version > version {
+				break
+			}
+
+			query := "UPDATE " + HistoryTable + " SET name = ?, checksum = ?, dirty = ? WHERE version = ?"
+			args := []any{mig.Name, mig.Checksum, false, mig.Version}
+			if _, ok := applied[mig.Version]; !ok {
+				query = "INSERT INTO " + HistoryTable + " (name, checksum, dirty, version, applied_at) VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)"
+			}
+			if _, err := conn.ExecContext(ctx, m.rebind(query), args...); err != nil {
+				return fmt.Errorf("force version %d: %w", version, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Version returns the latest applied version, 0 when nothing is applied, and
+// whether any migration is dirty.
+func (m *Migrator) Version(ctx context.Context) (uint64, bool, error) {
+	var (
+		version uint64
+		dirty   bool
+	)
+	err := m.run(ctx, func(_ *sql.Conn, applied map[uint64]Applied) error {
+		version, dirty = head(applied)
+		return nil
+	})
+	return version, dirty, err
+}
+
+// Status lists every known migration with its state. Versions recorded in
+// the history table but missing from the files are listed last.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+	err := m.run(ctx, func(_ *sql.Conn, applied map[uint64]Applied) error {
+		for _, mig := range m.migrations {
+			status := Status{Migration: mig}
+			if a, ok := applied[mig.Version]; ok {
+				status.Applied = true
+				status.Dirty = a.Dirty
+				status.Modified = a.Checksum != mig.Checksum
+				status.AppliedAt = a.AppliedAt
+			}
+			statuses = append(statuses, status)
+		}
+
+		for _, v := range sortedVersions(applied) {
+			if _, ok := m.find(v); !ok {
+				a := applied[v]
+				statuses = append(statuses, Status{
+					Migration: Migration{Version: v, Name: a.Name, Checksum: a.Checksum},
+					Applied:   true,
+					Dirty:     a.Dirty,
+					AppliedAt: a.AppliedAt,
+				})
+			}
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+// run takes the advisory lock on a dedicated connection, makes sure the
+// history table exists and hands the applied migrations to fn.
+func (m *Migrator) run(ctx context.Context, fn func(conn *sql.Conn, applied map[uint64]Applied) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("get connection: %w", err)
+	}
+	defer conn.Close()
+
+	unlock, err := m.lock(ctx, conn)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if _, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+HistoryTable+` (
+    version BIGINT PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    checksum CHAR(64) NOT NULL,
+    dirty BOOLEAN NOT NULL,
+    applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`); err != nil {
+		return fmt.Errorf("create %s: %w", HistoryTable, err)
+	}
+
+	applied, err := m.applied(ctx, conn)
+	if err != nil {
+		return err
+	}
+	return fn(conn, applied)
+}
+
+// Current is Version for callers that must not wait or write, such as health
+// checks: it takes no lock and does not create the history table, so it
+// fails on a database that was never migrated.
+func (m *Migrator) Current(ctx context.Context) (uint64, bool, error) {
+	applied, err := m.applied(ctx, m.db)
+	if err != nil {
+		return 0, false, err
+	}
+	version, dirty := head(applied)
+	return version, dirty, nil
+}
+
+// head returns the latest applied version and whether any is dirty.
+func head(applied map[uint64]Applied) (version uint64, dirty bool) {
+	for v, a := range applied {
+		version = max(version, v)
+		dirty = dirty || a.Dirty
+	}
+	return version, dirty
+}
+
+// Latest returns the version of the last loaded migration, 0 when there are
+// none.
+func (m *Migrator) Latest() uint64 {
+	return m.latest()
+}
+
+func (m *Migrator) applied(ctx context.Context, conn interface {
+	QueryContext(context.Context, string, ...any) (*sql.Rows, error)
+}) (map[uint64]Applied, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, name, checksum, dirty, applied_at FROM "+HistoryTable)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", HistoryTable, err)
+	}
+	defer rows.Close()
+
+	applied := make(map[uint64]Applied)
+	for rows.Next() {
+		var a Applied
+		if err := rows.Scan(&a.Version, &a.Name, &a.Checksum, &a.Dirty, &a.AppliedAt); err != nil {
+			return nil, fmt.Errorf("scan %s: %w", HistoryTable, err)
+		}
+		applied[a.Version] = a
+	}
+	return applied, rows.Err()
+}
+
+// check refuses to migrate a dirty database or one whose applied migrations
+// no longer match their files.
+func (m *Migrator) check(applied map[uint64]Applied) error {
+	for _, v := range sortedVersions(applied) {
+		a := applied[v]
+		if a.Dirty {
+			return fmt.Errorf("%w: migration %d_%s did not finish", ErrDirty, a.Version, a.Name)
+		}
+		if mig, ok := m.find(v); ok && mig.Checksum != a.Checksum {
+			return fmt.Errorf("%w: %d_%s was changed after it was applied", ErrChecksumMismatch, mig.Version, mig.Name)
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) upTo(ctx context.Context, conn *sql.Conn, applied map[uint64]Applied, target uint64) error {
+	for _, mig := range m.migrations {
+		if mig.Version > target {
+			break
+		}
+		if _, ok := applied[mig.Version]; ok {
+			continue
+		}
+		if err := m.apply(ctx, conn, mig, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) downTo(ctx context.Context, conn *sql.Conn, applied map[uint64]Applied, target uint64) error {
+	versions := sortedVersions(applied)
+	for i := len(versions) - 1; i >= 0 && versions[i] > target; i-- {
+		mig, ok := m.find(versions[i])
+		if !ok {
+			return fmt.Errorf("%w: %d is applied but its files are missing", ErrUnknownVersion, versions[i])
+		}
+		if err := m.apply(ctx, conn, mig, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply runs one migration in the given direction. The history row is
+// marked dirty before the SQL runs and settled afterwards, so a failure
+// leaves a record of where the schema was left.
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, mig Migration, up bool) error {
+	direction, script := "down", mig.Down
+	if up {
+		direction, script = "up", mig.Up
+	}
+
+	mark := "UPDATE " + HistoryTable + " SET dirty = ? WHERE version = ?"
+	markArgs := []any{true, mig.Version}
+	if up {
+		mark = "INSERT INTO " + HistoryTable + " (dirty, version, name, checksum, applied_at) VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)"
+		markArgs = append(markArgs, mig.Name, mig.Checksum)
+	}
+	if _, err := conn.ExecContext(ctx, m.rebind(mark), markArgs...); err != nil {
+		return fmt.Errorf("mark migration %d_%s: %w", mig.Version, mig.Name, err)
+	}
+
+	if err := m.exec(ctx, conn, script); err != nil {
+		return fmt.Errorf("migration %d_%s %s: %w", mig.Version, mig.Name, direction, err)
+	}
+
+	settle := "DELETE FROM " + HistoryTable + " WHERE version = ?"
+	settleArgs := []any{mig.Version}
+	if up {
+		settle = "UPDATE " + HistoryTable + " SET dirty = ?, applied_at = CURRENT_TIMESTAMP WHERE version = ?"
+		settleArgs = []any{false, mig.Version}
+	}
+	if _, err := conn.ExecContext(ctx, m.rebind(settle), settleArgs...); err != nil {
+		return fmt.Errorf("record migration %d_%s: %w", mig.Version, mig.Name, err)
+	}
+
+	if m.Logf != nil {
+		m.Logf("migrated %s %d_%s", direction, mig.Version, mig.Name)
+	}
+	return nil
+}
+
+// exec runs the statements of script. PostgreSQL and SQLite roll back DDL
+// with the transaction; MySQL commits DDL implicitly, so a transaction
+// would not help there.
+func (m *Migrator) exec(ctx context.Context, conn *sql.Conn, script string) error {
+	stmts := statements(m.dialect, script)
+	if m.dialect == "mysql" {
+		for _, stmt := range stmts {
+			if _, err := conn.ExecContext(ctx, stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (m *Migrator) find(version uint64) (Migration, bool) {
+	for _, mig := range m.migrations {
+		if mig.Version == version {
+			return mig, true
+		}
+	}
+	return Migration{}, false
+}
+
+func (m *Migrator) latest() uint64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// rebind rewrites ? placeholders into $n for PostgreSQL. The queries in this
+// package never contain a literal question mark.
+func (m *Migrator) rebind(query string) string {
+	if m.dialect != "postgres" {
+		return query
+	}
+
+	parts := strings.Split(query, "?")
+	var b strings.Builder
+	for i, part := range parts {
+		b.WriteString(part)
+		if i < len(parts)-1 {
+			b.WriteString("$" + strconv.Itoa(i+1))
+		}
+	}
+	return b.String()
+}
+
+func sortedVersions(applied map[uint64]Applied) []uint64 {
+	versions := make([]uint64, 0, len(applied))
+	for v := range applied {
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	return versions
+}
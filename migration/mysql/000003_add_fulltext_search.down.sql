@@ -0,0 +1,2 @@
+ALTER TABLE users DROP INDEX ft_users_name_email;
+ALTER TABLE products DROP INDEX ft_products_name;
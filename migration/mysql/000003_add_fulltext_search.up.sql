@@ -0,0 +1,2 @@
+ALTER TABLE products ADD FULLTEXT INDEX ft_products_name (name);
+ALTER TABLE users ADD FULLTEXT INDEX ft_users_name_email (name, email);
@@ -0,0 +1,4 @@
+ALTER TABLE products DROP INDEX idx_products_deleted_at;
+ALTER TABLE products DROP COLUMN deleted_at;
+ALTER TABLE users DROP INDEX idx_users_deleted_at;
+ALTER TABLE users DROP COLUMN deleted_at;
@@ -0,0 +1,4 @@
+ALTER TABLE users ADD COLUMN deleted_at TIMESTAMP NULL DEFAULT NULL;
+ALTER TABLE users ADD INDEX idx_users_deleted_at (deleted_at);
+ALTER TABLE products ADD COLUMN deleted_at TIMESTAMP NULL DEFAULT NULL;
+ALTER TABLE products ADD INDEX idx_products_deleted_at (deleted_at);
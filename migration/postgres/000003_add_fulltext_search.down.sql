@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS ft_users_name_email;
+DROP INDEX IF EXISTS ft_products_name;
@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS ft_products_name ON products USING GIN (to_tsvector('simple', concat_ws(' ', name)));
+CREATE INDEX IF NOT EXISTS ft_users_name_email ON users USING GIN (to_tsvector('simple', concat_ws(' ', name, email)));
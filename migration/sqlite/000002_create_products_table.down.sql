@@ -0,0 +1 @@
+DROP TABLE IF EXISTS products;
\ No newline at end of file
@@ -0,0 +1,2 @@
+-- See 000003_add_fulltext_search.up.sql.
+SELECT 1;
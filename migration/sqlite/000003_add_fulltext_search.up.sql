@@ -0,0 +1,4 @@
+-- SQLite has no full-text index on ordinary tables; fulltext searches fall
+-- back to contains matching. Kept so migration versions line up across
+-- dialects.
+SELECT 1;
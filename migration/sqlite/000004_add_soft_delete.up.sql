@@ -0,0 +1,4 @@
+ALTER TABLE users ADD COLUMN deleted_at TIMESTAMP NULL;
+CREATE INDEX idx_users_deleted_at ON users (deleted_at);
+ALTER TABLE products ADD COLUMN deleted_at TIMESTAMP NULL;
+CREATE INDEX idx_products_deleted_at ON products (deleted_at);
@@ -1,6 +1,10 @@
 package model
 
-import "time"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 // Product defines the product model.
 // It has a "belongs to" relationship with a User.
@@ -11,10 +15,15 @@ type Product struct {
 	OwnerID   int64     `json:"owner_id" db:"owner_id" gorm:"column:owner_id"`
 	CreatedAt time.Time `json:"created_at" db:"created_at" gorm:"column:created_at"`
 	UpdatedAt time.Time `json:"updated_at" db:"updated_at" gorm:"column:updated_at"`
+	// DeletedAt is set when the row is soft-deleted. gorm leaves such rows
+	// out of queries unless they are Unscoped.
+	DeletedAt gorm.DeletedAt `json:"deleted_at,omitempty" db:"deleted_at" gorm:"column:deleted_at;index"`
+	// Version is bumped by every update; see database.Update.
+	Version int64 `json:"version" db:"version" gorm:"column:version;not null;default:1"`
 
 	// Owner represents the many-to-one relationship: a Product belongs to one User.
 	// This field is used by GORM to preload/join the owner's data.
-	Owner User `json:"user" gorm:"foreignKey:OwnerID"`
+	Owner User `json:"user" db:"-" gorm:"foreignKey:OwnerID"`
 }
 
 // TableName explicitly specifies the table name for GORM.
@@ -1,6 +1,12 @@
+// Package model holds the models of the gorm and sqlx blueprints. Their db
+// tags map columns for sqlx, and their gorm tags for gorm.
 package model
 
-import "time"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 // User defines the model for a user in the system.
 // The `json`, `db`, and `gorm` struct tags are used for compatibility
@@ -8,13 +14,18 @@ import "time"
 type User struct {
 	ID        int64     `json:"id" db:"id" gorm:"column:id;primaryKey"`
 	Name      string    `json:"name" db:"name" gorm:"column:name"`
-	Email     string    `json:"email" db:"email" gorm:"column:email"`
+	Email     string    `json:"email" db:"email" gorm:"column:email;unique"`
 	CreatedAt time.Time `json:"created_at" db:"created_at" gorm:"column:created_at"`
 	UpdatedAt time.Time `json:"updated_at" db:"updated_at" gorm:"column:updated_at"`
+	// DeletedAt is set when the row is soft-deleted. gorm leaves such rows
+	// out of queries unless they are Unscoped.
+	DeletedAt gorm.DeletedAt `json:"deleted_at,omitempty" db:"deleted_at" gorm:"column:deleted_at;index"`
+	// Version is bumped by every update; see database.Update.
+	Version int64 `json:"version" db:"version" gorm:"column:version;not null;default:1"`
 
 	// Products represents the one-to-many relationship: a User has many Products.
 	// This field is primarily used by GORM for preloading/joining.
-	Products []Product `json:"-" gorm:"foreignKey:OwnerID"`
+	Products []Product `json:"products,omitempty" db:"-" gorm:"foreignKey:OwnerID"`
 }
 
 // TableName explicitly specifies the table name for GORM.
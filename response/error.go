@@ -0,0 +1,155 @@
+package response
+
+import (
+	"db_blueprints/apperror"
+	"db_blueprints/config"
+	"db_blueprints/middleware"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+type ErrorBody struct {
+	Code      apperror.Code `json:"code"`
+	Message   string        `json:"message"`
+	Details   []FieldError  `json:"details,omitempty"`
+	RequestID string        `json:"request_id,omitempty"`
+	// Debug carries the internal error text. It is only sent when ENV is
+	// development.
+	Debug string `json:"debug,omitempty"`
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule,omitempty"`
+	Message string `json:"message"`
+}
+
+// Error writes an error payload. When err carries an apperror code, the
+// status and message are derived from it and the arguments act as fallbacks.
+// Binding errors are expanded into per-field details.
+func Error(c *gin.Context, status int, err error, message string) {
+	status, body := errorBody(status, err, message)
+	body.RequestID = middleware.GetRequestID(c)
+
+	c.JSON(status, ErrorResponse{Error: body})
+}
+
+// ItemError builds the error payload of a single item of a bulk request, as
+// Error would write it for a request of its own.
+func ItemError(err error, message string) *ErrorBody {
+	_, body := errorBody(http.StatusBadRequest, err, message)
+	return &body
+}
+
+func errorBody(status int, err error, message string) (int, ErrorBody) {
+	code := apperror.CodeOf(err)
+	if code != apperror.CodeInternal {
+		status = code.HTTPStatus()
+		if msg := apperror.MessageOf(err); msg != "" {
+			message = msg
+		}
+	} else {
+		code = apperror.CodeForStatus(status)
+	}
+
+	details := fieldErrors(err)
+	if len(details) != 0 {
+		code = apperror.CodeValidation
+	}
+
+	body := ErrorBody{
+		Code:    code,
+		Message: message,
+		Details: details,
+	}
+	if err != nil && config.GetConfig().IsDevelopment() {
+		body.Debug = err.Error()
+	}
+	return status, body
+}
+
+func fieldErrors(err error) []FieldError {
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		details := make([]FieldError, 0, len(validationErrs))
+		for _, fe := range validationErrs {
+			details = append(details, FieldError{
+				Field:   fieldPath(fe),
+				Rule:    fe.Tag(),
+				Message: fieldMessage(fe),
+			})
+		}
+		return details
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return []FieldError{{
+			Field:   typeErr.Field,
+			Rule:    "type",
+			Message: fmt.Sprintf("must be of type %s", typeErr.Type),
+		}}
+	}
+
+	return nil
+}
+
+// fieldPath returns the field's path below the bound struct, e.g.
+// "products[1].price" rather than "CreateUserRequest.products[1].price".
+func fieldPath(fe validator.FieldError) string {
+	if _, path, ok := strings.Cut(fe.Namespace(), "."); ok {
+		return path
+	}
+	return fe.Field()
+}
+
+func fieldMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	case "max":
+		return "must be at most " + bound(fe)
+	case "min":
+		return "must be at least " + bound(fe)
+	case "gt":
+		return fmt.Sprintf("must be greater than %s", fe.Param())
+	case "gte":
+		return fmt.Sprintf("must be greater than or equal to %s", fe.Param())
+	case "lte":
+		return fmt.Sprintf("must be less than or equal to %s", fe.Param())
+	case "oneof":
+		return fmt.Sprintf("must be one of [%s]", fe.Param())
+	case "owner_exists":
+		return "must reference an existing user"
+	case "email_unique":
+		return "is already registered"
+	default:
+		return fmt.Sprintf("failed the %q rule", fe.Tag())
+	}
+}
+
+// bound describes the parameter of a min or max rule: a length for strings,
+// a count for slices and maps, and the bare value for numbers.
+func bound(fe validator.FieldError) string {
+	switch fe.Kind() {
+	case reflect.String:
+		return fe.Param() + " characters"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return fe.Param() + " items"
+	default:
+		return fe.Param()
+	}
+}
@@ -0,0 +1,64 @@
+package response
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin/binding"
+)
+
+func TestFieldMessage(t *testing.T) {
+	type request struct {
+		Name    string   `binding:"min=2,max=4"`
+		Note    *string  `binding:"omitnil,max=3"`
+		Tags    []string `binding:"min=1,max=2"`
+		Price   float64  `binding:"min=1,max=100"`
+		OwnerID int64    `binding:"min=1"`
+	}
+	note := "long"
+
+	tests := []struct {
+		name string
+		req  request
+		want map[string]string
+	}{
+		{
+			name: "lower bounds",
+			req:  request{Name: "a", Tags: []string{}, Price: 0.5, OwnerID: 0},
+			want: map[string]string{
+				"Name":    "must be at least 2 characters",
+				"Tags":    "must be at least 1 items",
+				"Price":   "must be at least 1",
+				"OwnerID": "must be at least 1",
+			},
+		},
+		{
+			name: "upper bounds",
+			req:  request{Name: "abcde", Note: &note, Tags: []string{"a", "b", "c"}, Price: 101, OwnerID: 1},
+			want: map[string]string{
+				"Name":  "must be at most 4 characters",
+				"Note":  "must be at most 3 characters",
+				"Tags":  "must be at most 2 items",
+				"Price": "must be at most 100",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			details := fieldErrors(binding.Validator.ValidateStruct(&tt.req))
+
+			got := make(map[string]string, len(details))
+			for _, detail := range details {
+				got[detail.Field] = detail.Message
+			}
+			for field, want := range tt.want {
+				if got[field] != want {
+					t.Errorf("%s: message = %q, want %q", field, got[field], want)
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("got messages %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
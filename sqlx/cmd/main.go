@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"db_blueprints/config"
+	db "db_blueprints/sqlx/database"
+	"db_blueprints/sqlx/internal/server"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+	cfg := config.LoadConfig()
+
+	database, err := db.NewDatabase(cfg)
+	if err != nil {
+		log.Fatalln("Cannot connect to database:", err)
+	}
+	defer database.Close()
+
+	// SIGINT or SIGTERM starts a graceful shutdown. A second one kills the
+	// process without waiting for in-flight requests.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	context.AfterFunc(ctx, stop)
+
+	httpSvr := server.NewServer(database, cfg)
+	if err := httpSvr.Run(ctx); err != nil {
+		database.Close()
+		log.Fatalln("Running HTTP server error:", err)
+	}
+	log.Println("Server stopped")
+}
@@ -0,0 +1,104 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"db_blueprints/config"
+	"db_blueprints/dbpool"
+	"fmt"
+	"log"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// DBTX is satisfied by both *sqlx.DB and *sqlx.Tx, so repositories can run
+// either directly against the pool or inside a transaction.
+type DBTX interface {
+	sqlx.ExtContext
+	GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	NamedExecContext(ctx context.Context, query string, arg interface{}) (sql.Result, error)
+}
+
+// NewDatabase opens the database selected by DB_DRIVER (mysql, postgres or
+// sqlite) with the same DSN and pool settings as the other blueprints, so
+// they can be compared against the same database. The first connection is
+// retried DB_CONNECT_RETRIES times.
+func NewDatabase(config *config.Config) (*sqlx.DB, error) {
+	driverName, err := driverOf(config)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sqlx.Open(driverName, config.DSN())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database connection: %w", err)
+	}
+
+	dbpool.Configure(db.DB, config)
+
+	if err := dbpool.Connect(context.Background(), config, db.PingContext); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	log.Println("Successfully connected to the database!")
+	return db, nil
+}
+
+// driverOf returns the database/sql driver of DB_DRIVER. sqlx picks the
+// placeholder style of Rebind and named queries from it.
+func driverOf(cfg *config.Config) (string, error) {
+	switch cfg.Driver() {
+	case config.DriverMySQL:
+		return "mysql", nil
+	case config.DriverPostgres:
+		return "pgx", nil
+	case config.DriverSQLite:
+		return "sqlite3", nil
+	default:
+		return "", fmt.Errorf("unsupported DB_DRIVER %q", cfg.DB_DRIVER)
+	}
+}
+
+// InsertReturningID runs the named INSERT query with arg and returns the key
+// of the new row. MySQL reports it as the last insert id; PostgreSQL and
+// SQLite return it with RETURNING, since pgx has no last insert id.
+func InsertReturningID(ctx context.Context, db DBTX, query string, arg interface{}) (int64, error) {
+	if db.DriverName() == "mysql" {
+		result, err := db.NamedExecContext(ctx, query, arg)
+		if err != nil {
+			return 0, err
+		}
+		return result.LastInsertId()
+	}
+
+	rows, err := sqlx.NamedQueryContext(ctx, db, query+" RETURNING id", arg)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var id int64
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+		return 0, sql.ErrNoRows
+	}
+	if err := rows.Scan(&id); err != nil {
+		return 0, err
+	}
+	return id, rows.Close()
+}
+
+// DeletionTime is the deleted_at of rows soft-deleted now. It is cut to the
+// second, as the other blueprints do, so rows deleted together compare equal
+// whatever precision the column has.
+func DeletionTime() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
@@ -0,0 +1,68 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"db_blueprints/apperror"
+	"errors"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// MySQL server error numbers translated by TranslateError.
+const (
+	mysqlDuplicateEntry  = 1062
+	mysqlRowIsReferenced = 1451
+	mysqlNoReferencedRow = 1452
+)
+
+// PostgreSQL SQLSTATE codes translated by TranslateError.
+const (
+	pgUniqueViolation     = "23505"
+	pgForeignKeyViolation = "23503"
+)
+
+// TranslateError maps driver errors onto apperror codes so the HTTP layer can
+// report them correctly. Errors it does not recognise are returned unchanged.
+func TranslateError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var mysqlErr *mysql.MySQLError
+	var pgErr *pgconn.PgError
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return apperror.Wrap(apperror.CodeNotFound, err, "resource not found")
+	case errors.Is(err, context.DeadlineExceeded):
+		return apperror.Wrap(apperror.CodeTimeout, err, "database operation timed out")
+	case errors.As(err, &mysqlErr):
+		switch mysqlErr.Number {
+		case mysqlDuplicateEntry:
+			return apperror.Wrap(apperror.CodeConflict, err, "resource already exists")
+		case mysqlRowIsReferenced:
+			return apperror.Wrap(apperror.CodeConflict, err, "resource is still referenced by other records")
+		case mysqlNoReferencedRow:
+			return apperror.Wrap(apperror.CodeValidation, err, "referenced resource does not exist")
+		}
+	case errors.As(err, &pgErr):
+		switch pgErr.Code {
+		case pgUniqueViolation:
+			return apperror.Wrap(apperror.CodeConflict, err, "resource already exists")
+		case pgForeignKeyViolation:
+			// PostgreSQL uses one code for both directions; the message tells
+			// a delete of a referenced row from an insert of a dangling key.
+			if strings.HasPrefix(pgErr.Message, "update or delete") {
+				return apperror.Wrap(apperror.CodeConflict, err, "resource is still referenced by other records")
+			}
+			return apperror.Wrap(apperror.CodeValidation, err, "referenced resource does not exist")
+		}
+	}
+
+	if translated, ok := translateSQLiteError(err); ok {
+		return translated
+	}
+	return err
+}
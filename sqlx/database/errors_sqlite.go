@@ -0,0 +1,27 @@
+//go:build cgo
+
+package database
+
+import (
+	"db_blueprints/apperror"
+	"errors"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// translateSQLiteError maps SQLite constraint violations. go-sqlite3 only
+// defines its error type when built with cgo, hence the separate file.
+func translateSQLiteError(err error) (error, bool) {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return nil, false
+	}
+
+	switch sqliteErr.ExtendedCode {
+	case sqlite3.ErrConstraintUnique, sqlite3.ErrConstraintPrimaryKey:
+		return apperror.Wrap(apperror.CodeConflict, err, "resource already exists"), true
+	case sqlite3.ErrConstraintForeignKey:
+		return apperror.Wrap(apperror.CodeValidation, err, "referenced resource does not exist"), true
+	}
+	return nil, false
+}
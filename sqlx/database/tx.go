@@ -0,0 +1,43 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// WithTx runs fn inside a transaction, which is committed when fn returns nil
+// and rolled back when it returns an error or panics. When db is already a
+// transaction, fn joins it.
+func WithTx(ctx context.Context, db DBTX, fn func(tx DBTX) error) error {
+	switch db := db.(type) {
+	case *sqlx.Tx:
+		return fn(db)
+	case *sqlx.DB:
+		tx, err := db.BeginTxx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("begin transaction: %w", err)
+		}
+		defer func() {
+			if p := recover(); p != nil {
+				_ = tx.Rollback()
+				panic(p)
+			}
+		}()
+
+		if err := fn(tx); err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				return errors.Join(err, fmt.Errorf("rollback transaction: %w", rbErr))
+			}
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("commit transaction: %w", err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("begin transaction: %T cannot start a transaction", db)
+	}
+}
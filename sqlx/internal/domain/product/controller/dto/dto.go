@@ -0,0 +1,64 @@
+package dto
+
+import (
+	"db_blueprints/sqlx/internal/domain/user/controller/dto"
+	"db_blueprints/sqlx/pkgs/paging"
+)
+
+type Product struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Price     float64   `json:"price"`
+	OwnerID   int64     `json:"owner_id"`
+	Owner     *dto.User `json:"user,omitempty"`
+	CreatedAt string    `json:"created_at"`
+	UpdatedAt string    `json:"updated_at"`
+	DeletedAt *string   `json:"deleted_at,omitempty"`
+	Version   int64     `json:"version"`
+}
+
+type ListProductRequest struct {
+	Search    string `json:"search,omitempty" form:"search" binding:"max=255"`
+	Page      int64  `json:"-" form:"page" binding:"omitempty,min=1"`
+	Limit     int64  `json:"-" form:"size" binding:"omitempty,min=1,max=1000"`
+	OrderBy   string `json:"-" form:"order_by"`
+	OrderDesc bool   `json:"-" form:"order_desc"`
+	TakeAll   bool   `json:"-" form:"take_all"`
+	// IncludeDeleted lists soft-deleted rows too. Admins only.
+	IncludeDeleted bool `json:"-" form:"include_deleted"`
+}
+
+// GetProductRequest holds the query parameters of a single product lookup.
+type GetProductRequest struct {
+	// IncludeDeleted finds a soft-deleted product too. Admins only.
+	IncludeDeleted bool `form:"include_deleted"`
+}
+
+type ListProductResponse struct {
+	Products   []*Product         `json:"items"`
+	Pagination *paging.Pagination `json:"metadata"`
+}
+
+type CreateProductRequest struct {
+	OwnerID int64   `json:"owner_id" binding:"required,owner_exists"`
+	Name    string  `json:"name" binding:"required,max=255"`
+	Price   float64 `json:"price" binding:"required,gt=0"`
+}
+
+type CreateProductResponse struct {
+	Product *Product `json:"product"`
+}
+
+type UpdateProductRequest struct {
+	ID    int64    `json:"id"`
+	Owner *int64   `json:"owner_id" binding:"omitnil,owner_exists"`
+	Name  *string  `json:"name" binding:"omitnil,min=1,max=255"`
+	Price *float64 `json:"price" binding:"omitnil,gt=0"`
+	// Version is the version the change is based on. The If-Match header
+	// takes precedence over it.
+	Version *int64 `json:"version" binding:"omitnil,min=1"`
+}
+
+type UpdateProductResponse struct {
+	Product *Product `json:"product"`
+}
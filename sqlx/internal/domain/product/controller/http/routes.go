@@ -0,0 +1,30 @@
+package http
+
+import (
+	db "db_blueprints/sqlx/database"
+	"db_blueprints/sqlx/internal/domain/product/repository"
+	"db_blueprints/sqlx/internal/domain/product/service"
+	user_repo "db_blueprints/sqlx/internal/domain/user/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+func Routes(
+	r *gin.RouterGroup,
+	db db.DBTX,
+) {
+	productRepository := repository.NewProductRepository(db)
+	userRepository := user_repo.NewUserRepository(db)
+	productService := service.NewProductService(productRepository, userRepository)
+	productHandler := NewProductHandler(productService)
+
+	productRoute := r.Group("/products")
+	{
+		productRoute.GET("", productHandler.GetProducts)
+		productRoute.GET("/:id", productHandler.GetProduct)
+		productRoute.POST("", productHandler.CreateProduct)
+		productRoute.PUT("/:id", productHandler.UpdateProduct)
+		productRoute.DELETE("/:id", productHandler.DeleteProduct)
+		productRoute.POST("/:id/restore", productHandler.RestoreProduct)
+	}
+}
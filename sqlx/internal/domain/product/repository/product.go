@@ -0,0 +1,215 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"db_blueprints/apperror"
+	"db_blueprints/model"
+	"db_blueprints/sqlx/database"
+	"db_blueprints/sqlx/internal/domain/product/controller/dto"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const productColumns = "id, name, price, owner_id, created_at, updated_at, deleted_at, version"
+
+type IProductRepository interface {
+	GetByID(ctx context.Context, id int64) (*model.Product, error)
+	GetByIDUnscoped(ctx context.Context, id int64) (*model.Product, error)
+	Create(ctx context.Context, product *model.Product) (*model.Product, error)
+	Update(ctx context.Context, product *model.Product) (*model.Product, error)
+	Delete(ctx context.Context, id int64) error
+	DeleteByOwner(ctx context.Context, ownerID int64) (int64, error)
+	Restore(ctx context.Context, id int64) error
+	RestoreByOwner(ctx context.Context, ownerID int64, since time.Time) (int64, error)
+	List(ctx context.Context, req *dto.ListProductRequest) ([]*model.Product, int64, error)
+	WithTx(tx database.DBTX) IProductRepository
+}
+
+type ProductRepository struct {
+	db database.DBTX
+}
+
+func NewProductRepository(db database.DBTX) IProductRepository {
+	return &ProductRepository{db: db}
+}
+
+// WithTx returns a copy of the repository bound to tx, typically the one
+// handed to a database.WithTx callback.
+func (r *ProductRepository) WithTx(tx database.DBTX) IProductRepository {
+	return &ProductRepository{db: tx}
+}
+
+func (r *ProductRepository) GetByID(ctx context.Context, id int64) (*model.Product, error) {
+	return r.get(ctx, "id = ? AND deleted_at IS NULL", id)
+}
+
+// GetByIDUnscoped returns the product even when it is soft-deleted.
+func (r *ProductRepository) GetByIDUnscoped(ctx context.Context, id int64) (*model.Product, error) {
+	return r.get(ctx, "id = ?", id)
+}
+
+func (r *ProductRepository) get(ctx context.Context, cond string, id int64) (*model.Product, error) {
+	query := r.db.Rebind("SELECT " + productColumns + " FROM products WHERE " + cond)
+	var p model.Product
+	if err := r.db.GetContext(ctx, &p, query, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, apperror.NotFound("product with id %d not found", id)
+		}
+		return nil, database.TranslateError(fmt.Errorf("get product by id: %w", err))
+	}
+	return &p, nil
+}
+
+func (r *ProductRepository) Create(ctx context.Context, product *model.Product) (*model.Product, error) {
+	query := "INSERT INTO products (name, price, owner_id, created_at, updated_at) VALUES (:name, :price, :owner_id, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)"
+	id, err := database.InsertReturningID(ctx, r.db, query, product)
+	if err != nil {
+		return nil, database.TranslateError(fmt.Errorf("create product: %w", err))
+	}
+	product.ID = id
+	product.Version = 1
+	return product, nil
+}
+
+// Update writes the product if the row is still at product.Version, and
+// bumps the version. It fails with apperror.VersionConflict when another
+// update got there first.
+func (r *ProductRepository) Update(ctx context.Context, product *model.Product) (*model.Product, error) {
+	query := "UPDATE products SET name = :name, price = :price, updated_at = CURRENT_TIMESTAMP, version = version + 1" +
+		" WHERE id = :id AND version = :version AND deleted_at IS NULL"
+	result, err := r.db.NamedExecContext(ctx, query, product)
+	if err != nil {
+		return nil, database.TranslateError(fmt.Errorf("update product: %w", err))
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("get rows affected for product update: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		current, err := r.GetByID(ctx, product.ID)
+		if err != nil {
+			return nil, err
+		}
+		return nil, apperror.VersionConflict("product with id %d is at version %d, not %d", product.ID, current.Version, product.Version)
+	}
+
+	product.Version++
+	return product, nil
+}
+
+// Delete soft-deletes the product.
+func (r *ProductRepository) Delete(ctx context.Context, id int64) error {
+	query := r.db.Rebind("UPDATE products SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL")
+	rowsAffected, err := r.exec(ctx, "delete", query, database.DeletionTime(), id)
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return apperror.NotFound("product with id %d not found", id)
+	}
+	return nil
+}
+
+// DeleteByOwner soft-deletes the live products of an owner.
+func (r *ProductRepository) DeleteByOwner(ctx context.Context, ownerID int64) (int64, error) {
+	query := r.db.Rebind("UPDATE products SET deleted_at = ? WHERE owner_id = ? AND deleted_at IS NULL")
+	return r.exec(ctx, "delete", query, database.DeletionTime(), ownerID)
+}
+
+// Restore clears the deletion mark of the product.
+func (r *ProductRepository) Restore(ctx context.Context, id int64) error {
+	query := r.db.Rebind("UPDATE products SET deleted_at = NULL WHERE id = ? AND deleted_at IS NOT NULL")
+	rowsAffected, err := r.exec(ctx, "restore", query, id)
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return apperror.NotFound("deleted product with id %d not found", id)
+	}
+	return nil
+}
+
+// RestoreByOwner restores the products of an owner that were deleted at or
+// after since, i.e. together with the owner rather than before it.
+func (r *ProductRepository) RestoreByOwner(ctx context.Context, ownerID int64, since time.Time) (int64, error) {
+	query := r.db.Rebind("UPDATE products SET deleted_at = NULL WHERE owner_id = ? AND deleted_at >= ?")
+	return r.exec(ctx, "restore", query, ownerID, since)
+}
+
+// exec runs a statement that changes products and returns how many it
+// changed. action names it in errors.
+func (r *ProductRepository) exec(ctx context.Context, action, query string, args ...interface{}) (int64, error) {
+	result, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, database.TranslateError(fmt.Errorf("%s product: %w", action, err))
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("get rows affected for product %s: %w", action, err)
+	}
+	return rowsAffected, nil
+}
+
+func (r *ProductRepository) List(ctx context.Context, req *dto.ListProductRequest) ([]*model.Product, int64, error) {
+	where := strings.Builder{}
+	where.WriteString(" WHERE 1=1")
+	args := []interface{}{}
+
+	if !req.IncludeDeleted {
+		where.WriteString(" AND deleted_at IS NULL")
+	}
+	if req.Search != "" {
+		where.WriteString(" AND name LIKE ?")
+		args = append(args, "%"+req.Search+"%")
+	}
+
+	var total int64
+	countQuery := r.db.Rebind("SELECT COUNT(id) FROM products" + where.String())
+	if err := r.db.GetContext(ctx, &total, countQuery, args...); err != nil {
+		return nil, 0, database.TranslateError(fmt.Errorf("count products: %w", err))
+	}
+	if total == 0 {
+		return []*model.Product{}, 0, nil
+	}
+
+	queryBuilder := strings.Builder{}
+	queryBuilder.WriteString("SELECT " + productColumns + " FROM products")
+	queryBuilder.WriteString(where.String())
+
+	orderBy := "id"
+	allowedOrderBys := map[string]string{
+		"name":       "name",
+		"price":      "price",
+		"created_at": "created_at",
+	}
+	if col, ok := allowedOrderBys[req.OrderBy]; ok {
+		orderBy = col
+	}
+	queryBuilder.WriteString(fmt.Sprintf(" ORDER BY %s", orderBy))
+	if req.OrderDesc {
+		queryBuilder.WriteString(" DESC")
+	} else {
+		queryBuilder.WriteString(" ASC")
+	}
+
+	if !req.TakeAll {
+		queryBuilder.WriteString(" LIMIT ? OFFSET ?")
+		offset := (req.Page - 1) * req.Limit
+		args = append(args, req.Limit, offset)
+	}
+
+	products := []*model.Product{}
+	if err := r.db.SelectContext(ctx, &products, r.db.Rebind(queryBuilder.String()), args...); err != nil {
+		return nil, 0, database.TranslateError(fmt.Errorf("list products: %w", err))
+	}
+
+	return products, total, nil
+}
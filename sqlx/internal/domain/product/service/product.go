@@ -0,0 +1,197 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"db_blueprints/apperror"
+	"db_blueprints/model"
+	"db_blueprints/sqlx/internal/domain/product/controller/dto"
+	"db_blueprints/sqlx/internal/domain/product/repository"
+	user_repo "db_blueprints/sqlx/internal/domain/user/repository"
+	"db_blueprints/sqlx/pkgs/paging"
+)
+
+type IProductService interface {
+	ListProducts(ctx context.Context, req *dto.ListProductRequest) ([]*model.Product, *paging.Pagination, error)
+	GetByID(ctx context.Context, id int64, includeDeleted bool) (*model.Product, error)
+	CreateProduct(ctx context.Context, req *dto.CreateProductRequest) (*model.Product, error)
+	UpdateProduct(ctx context.Context, id int64, req *dto.UpdateProductRequest) (*model.Product, error)
+	DeleteProduct(ctx context.Context, id int64) error
+	RestoreProduct(ctx context.Context, id int64) (*model.Product, error)
+}
+
+type ProductService struct {
+	repo      repository.IProductRepository
+	user_repo user_repo.IUserRepository
+}
+
+func NewProductService(
+	repo repository.IProductRepository,
+	user_repo user_repo.IUserRepository,
+) IProductService {
+	return &ProductService{
+		repo:      repo,
+		user_repo: user_repo,
+	}
+}
+
+func (s *ProductService) ListProducts(ctx context.Context, req *dto.ListProductRequest) ([]*model.Product, *paging.Pagination, error) {
+	if req.Page < 1 {
+		req.Page = 1
+	}
+	if req.Limit <= 0 {
+		req.Limit = paging.DefaultPageSize
+	}
+
+	products, total, err := s.repo.List(ctx, req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("service: failed to list products: %w", err)
+	}
+	if len(products) == 0 {
+		return nil, nil, nil
+	}
+
+	// 2. Thu thập các owner_id
+	ownerIDs := make([]int64, 0, len(products))
+	for _, p := range products {
+		ownerIDs = append(ownerIDs, p.OwnerID)
+	}
+
+	owners, err := s.user_repo.ListByIDs(ctx, ownerIDs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("service: failed to get owners for products: %w", err)
+	}
+
+	ownerMap := make(map[int64]*model.User, len(owners))
+	for _, owner := range owners {
+		ownerMap[owner.ID] = owner
+	}
+
+	productResponses := make([]*model.Product, 0, len(products))
+	for _, p := range products {
+		productResp := &model.Product{
+			ID:        p.ID,
+			Name:      p.Name,
+			Price:     p.Price,
+			OwnerID:   p.OwnerID,
+			CreatedAt: p.CreatedAt,
+			UpdatedAt: p.UpdatedAt,
+			DeletedAt: p.DeletedAt,
+			Version:   p.Version,
+		}
+
+		if owner, ok := ownerMap[p.OwnerID]; ok {
+			productResp.Owner = model.User{
+				ID:    owner.ID,
+				Name:  owner.Name,
+				Email: owner.Email,
+			}
+		}
+		productResponses = append(productResponses, productResp)
+	}
+
+	pagination := paging.NewPagination(req.Page, req.Limit, total)
+	pagination.TakeAll = req.TakeAll
+
+	return productResponses, pagination, nil
+}
+
+// GetByID returns the product and its owner; with includeDeleted
+// soft-deleted ones too.
+func (s *ProductService) GetByID(ctx context.Context, id int64, includeDeleted bool) (*model.Product, error) {
+	getProduct, getUser := s.repo.GetByID, s.user_repo.GetByID
+	if includeDeleted {
+		getProduct, getUser = s.repo.GetByIDUnscoped, s.user_repo.GetByIDUnscoped
+	}
+
+	product, err := getProduct(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("service: failed to get product by id: %w", err)
+	}
+
+	user, err := getUser(ctx, product.OwnerID)
+	if err != nil {
+		return nil, fmt.Errorf("service: failed to get user by id: %w", err)
+	}
+
+	product.Owner = *user
+
+	return product, nil
+}
+
+func (s *ProductService) CreateProduct(ctx context.Context, req *dto.CreateProductRequest) (*model.Product, error) {
+	product := &model.Product{
+		Name:    req.Name,
+		Price:   req.Price,
+		OwnerID: req.OwnerID,
+	}
+
+	createdProduct, err := s.repo.Create(ctx, product)
+	if err != nil {
+		return nil, fmt.Errorf("service: failed to create product: %w", err)
+	}
+
+	return createdProduct, nil
+}
+
+func (s *ProductService) UpdateProduct(ctx context.Context, id int64, req *dto.UpdateProductRequest) (*model.Product, error) {
+	productToUpdate, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("service: failed to get product for update: %w", err)
+	}
+	if req.Version != nil && *req.Version != productToUpdate.Version {
+		return nil, apperror.VersionConflict("product with id %d is at version %d, not %d", id, productToUpdate.Version, *req.Version)
+	}
+
+	if req.Name != nil {
+		productToUpdate.Name = *req.Name
+	}
+	if req.Price != nil {
+		productToUpdate.Price = *req.Price
+	}
+
+	updatedProduct, err := s.repo.Update(ctx, productToUpdate)
+	if err != nil {
+		return nil, fmt.Errorf("service: failed to update product: %w", err)
+	}
+
+	return updatedProduct, nil
+}
+
+func (s *ProductService) DeleteProduct(ctx context.Context, id int64) error {
+	_, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return fmt.Errorf("service: product with id %d cannot be deleted: %w", id, err)
+	}
+
+	err = s.repo.Delete(ctx, id)
+	if err != nil {
+		return fmt.Errorf("service: failed to delete product: %w", err)
+	}
+
+	return nil
+}
+
+// RestoreProduct undoes a soft delete. A product cannot come back while its
+// owner is deleted.
+func (s *ProductService) RestoreProduct(ctx context.Context, id int64) (*model.Product, error) {
+	product, err := s.repo.GetByIDUnscoped(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("service: failed to get product for restore: %w", err)
+	}
+
+	ownerExists, err := s.user_repo.ExistsByID(ctx, product.OwnerID)
+	if err != nil {
+		return nil, fmt.Errorf("service: failed to check product owner: %w", err)
+	}
+	if !ownerExists {
+		return nil, apperror.Conflict("product %d cannot be restored while its owner %d is deleted", id, product.OwnerID)
+	}
+
+	if err := s.repo.Restore(ctx, id); err != nil {
+		return nil, fmt.Errorf("service: failed to restore product: %w", err)
+	}
+
+	return s.GetByID(ctx, id, false)
+}
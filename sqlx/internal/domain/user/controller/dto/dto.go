@@ -0,0 +1,59 @@
+package dto
+
+import (
+	"db_blueprints/sqlx/pkgs/paging"
+)
+
+type User struct {
+	ID        int64   `json:"id"`
+	Email     string  `json:"email"`
+	Name      string  `json:"name"`
+	CreatedAt string  `json:"created_at"`
+	UpdatedAt string  `json:"updated_at"`
+	DeletedAt *string `json:"deleted_at,omitempty"`
+	Version   int64   `json:"version"`
+}
+
+type ListUserRequest struct {
+	Search    string `json:"search,omitempty" form:"search" binding:"max=255"`
+	Page      int64  `json:"-" form:"page" binding:"omitempty,min=1"`
+	Limit     int64  `json:"-" form:"size" binding:"omitempty,min=1,max=1000"`
+	OrderBy   string `json:"-" form:"order_by"`
+	OrderDesc bool   `json:"-" form:"order_desc"`
+	TakeAll   bool   `json:"-" form:"take_all"`
+	// IncludeDeleted lists soft-deleted rows too. Admins only.
+	IncludeDeleted bool `json:"-" form:"include_deleted"`
+}
+
+// GetUserRequest holds the query parameters of a single user lookup.
+type GetUserRequest struct {
+	// IncludeDeleted finds a soft-deleted user too. Admins only.
+	IncludeDeleted bool `form:"include_deleted"`
+}
+
+type ListUserResponse struct {
+	Users      []*User            `json:"items"`
+	Pagination *paging.Pagination `json:"metadata"`
+}
+
+type CreateUserRequest struct {
+	Email string `json:"email" binding:"required,email,max=255,email_unique"`
+	Name  string `json:"name" binding:"required,max=255"`
+}
+
+type CreateUserResponse struct {
+	User *User `json:"user"`
+}
+
+type UpdateUserRequest struct {
+	ID    int64   `json:"id"`
+	Email *string `json:"email" binding:"omitnil,email,max=255"`
+	Name  *string `json:"name" binding:"omitnil,min=1,max=255"`
+	// Version is the version the change is based on. The If-Match header
+	// takes precedence over it.
+	Version *int64 `json:"version" binding:"omitnil,min=1"`
+}
+
+type UpdateUserResponse struct {
+	User *User `json:"user"`
+}
@@ -0,0 +1,194 @@
+package http
+
+import (
+	"db_blueprints/apperror"
+	"db_blueprints/etag"
+	"db_blueprints/middleware"
+	"db_blueprints/model"
+	"db_blueprints/response"
+	"db_blueprints/sqlx/internal/domain/user/controller/dto"
+	"db_blueprints/sqlx/internal/domain/user/service"
+	"db_blueprints/sqlx/utils"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type UserHandler struct {
+	service service.IUserService
+}
+
+func NewUserHandler(service service.IUserService) *UserHandler {
+	return &UserHandler{service: service}
+}
+
+func (h *UserHandler) GetUsers(c *gin.Context) {
+	var req dto.ListUserRequest
+	if err := c.ShouldBind(&req); err != nil {
+		log.Println("Failed to get query", err)
+		response.Error(c, http.StatusBadRequest, err, "Invalid parameters")
+		return
+	}
+	if !allowIncludeDeleted(c, req.IncludeDeleted) {
+		return
+	}
+
+	users, pagination, err := h.service.ListUsers(c, &req)
+	if err != nil {
+		log.Println("Failed to get users", err)
+		response.Error(c, http.StatusInternalServerError, err, "Failed to get users")
+		return
+	}
+
+	var res dto.ListUserResponse
+	utils.MapStruct(&res.Users, users)
+	res.Pagination = pagination
+
+	response.JSON(c, http.StatusOK, res)
+}
+
+func (h *UserHandler) GetUser(c *gin.Context) {
+	var res model.User
+
+	userId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		log.Println("Failed to parse", err)
+	}
+
+	var req dto.GetUserRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, err, "Invalid parameters")
+		return
+	}
+	if !allowIncludeDeleted(c, req.IncludeDeleted) {
+		return
+	}
+
+	user, err := h.service.GetByID(c, userId, req.IncludeDeleted)
+	if err != nil {
+		log.Println("Failed to get user", err)
+		response.Error(c, http.StatusInternalServerError, err, "Failed to get user")
+		return
+	}
+
+	if etag.NotModified(c, user.Version) {
+		return
+	}
+	etag.Set(c, user.Version)
+
+	utils.MapStruct(&res, user)
+	response.JSON(c, http.StatusOK, res)
+}
+
+func (h *UserHandler) CreateUser(c *gin.Context) {
+	var req dto.CreateUserRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, err, "Invalid parameters")
+		return
+	}
+
+	user, err := h.service.CreateUser(c, &req)
+	if err != nil {
+		log.Println("Failed to create user", err)
+		response.Error(c, http.StatusInternalServerError, err, "Failed to create user")
+		return
+	}
+
+	var res dto.CreateUserResponse
+	utils.MapStruct(&res.User, user)
+
+	response.JSON(c, http.StatusCreated, res)
+}
+
+func (h *UserHandler) UpdateUser(c *gin.Context) {
+	var req dto.UpdateUserRequest
+
+	if err := c.ShouldBind(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, err, "Invalid parameters")
+		return
+	}
+
+	userId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		log.Println("Failed to parse", err)
+	}
+
+	if userId != req.ID {
+		response.Error(c, http.StatusBadRequest, nil, "User ID mismatch")
+		return
+	}
+
+	version, ok, err := etag.IfMatch(c)
+	if err != nil {
+		response.Error(c, http.StatusPreconditionFailed, err, "Invalid If-Match header")
+		return
+	}
+	if ok {
+		req.Version = &version
+	}
+
+	user, err := h.service.UpdateUser(c, userId, &req)
+	if err != nil {
+		if ok {
+			err = etag.Precondition(err)
+		}
+		response.Error(c, http.StatusInternalServerError, err, "Failed to update user")
+		return
+	}
+
+	var res dto.UpdateUserResponse
+	utils.MapStruct(&res.User, user)
+	etag.Set(c, user.Version)
+
+	response.JSON(c, http.StatusOK, res)
+}
+
+func (h *UserHandler) DeleteUser(c *gin.Context) {
+	userId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		log.Println("Failed to parse", err)
+	}
+
+	err = h.service.DeleteUser(c, userId)
+
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, err, "Failed to delete user")
+		return
+	}
+
+	response.JSON(c, http.StatusOK, "Delete user successfully")
+}
+
+// RestoreUser undoes the soft delete of a user and of the products deleted
+// together with it.
+func (h *UserHandler) RestoreUser(c *gin.Context) {
+	userId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err, "Invalid user ID")
+		return
+	}
+
+	user, err := h.service.RestoreUser(c, userId)
+	if err != nil {
+		log.Println("Failed to restore user", err)
+		response.Error(c, http.StatusInternalServerError, err, "Failed to restore user")
+		return
+	}
+
+	var res model.User
+	utils.MapStruct(&res, user)
+	response.JSON(c, http.StatusOK, res)
+}
+
+// allowIncludeDeleted rejects include_deleted from callers without the admin
+// token and reports whether the request may go on.
+func allowIncludeDeleted(c *gin.Context, includeDeleted bool) bool {
+	if includeDeleted && !middleware.IsAdmin(c) {
+		response.Error(c, http.StatusForbidden, apperror.Forbidden("include_deleted requires an admin token"), "Forbidden")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,30 @@
+package http
+
+import (
+	db "db_blueprints/sqlx/database"
+	product_repo "db_blueprints/sqlx/internal/domain/product/repository"
+	"db_blueprints/sqlx/internal/domain/user/repository"
+	"db_blueprints/sqlx/internal/domain/user/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+func Routes(
+	r *gin.RouterGroup,
+	db db.DBTX,
+) {
+	userRepository := repository.NewUserRepository(db)
+	productRepository := product_repo.NewProductRepository(db)
+	userService := service.NewUserService(db, userRepository, productRepository)
+	userHandler := NewUserHandler(userService)
+
+	userRoute := r.Group("/users")
+	{
+		userRoute.GET("", userHandler.GetUsers)
+		userRoute.GET("/:id", userHandler.GetUser)
+		userRoute.POST("", userHandler.CreateUser)
+		userRoute.PUT("/:id", userHandler.UpdateUser)
+		userRoute.DELETE("/:id", userHandler.DeleteUser)
+		userRoute.POST("/:id/restore", userHandler.RestoreUser)
+	}
+}
@@ -0,0 +1,251 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"db_blueprints/apperror"
+	"db_blueprints/model"
+	"db_blueprints/sqlx/database"
+	"db_blueprints/sqlx/internal/domain/user/controller/dto"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const userColumns = "id, name, email, created_at, updated_at, deleted_at, version"
+
+type IUserRepository interface {
+	GetByID(ctx context.Context, id int64) (*model.User, error)
+	GetByIDUnscoped(ctx context.Context, id int64) (*model.User, error)
+	Create(ctx context.Context, user *model.User) (*model.User, error)
+	Update(ctx context.Context, user *model.User) (*model.User, error)
+	Delete(ctx context.Context, id int64) error
+	Restore(ctx context.Context, id int64) error
+	List(ctx context.Context, req *dto.ListUserRequest) ([]*model.User, int64, error)
+	ListByIDs(ctx context.Context, ids []int64) ([]*model.User, error)
+	ExistsByID(ctx context.Context, id int64) (bool, error)
+	ExistsByEmail(ctx context.Context, email string) (bool, error)
+	DeletedIDByEmail(ctx context.Context, email string) (int64, error)
+	WithTx(tx database.DBTX) IUserRepository
+}
+
+type UserRepository struct {
+	db database.DBTX
+}
+
+func NewUserRepository(db database.DBTX) IUserRepository {
+	return &UserRepository{db: db}
+}
+
+// WithTx returns a copy of the repository bound to tx, typically the one
+// handed to a database.WithTx callback.
+func (r *UserRepository) WithTx(tx database.DBTX) IUserRepository {
+	return &UserRepository{db: tx}
+}
+
+func (r *UserRepository) GetByID(ctx context.Context, id int64) (*model.User, error) {
+	return r.get(ctx, "id = ? AND deleted_at IS NULL", id)
+}
+
+// GetByIDUnscoped returns the user even when it is soft-deleted.
+func (r *UserRepository) GetByIDUnscoped(ctx context.Context, id int64) (*model.User, error) {
+	return r.get(ctx, "id = ?", id)
+}
+
+func (r *UserRepository) get(ctx context.Context, cond string, id int64) (*model.User, error) {
+	query := r.db.Rebind("SELECT " + userColumns + " FROM users WHERE " + cond)
+	var user model.User
+	if err := r.db.GetContext(ctx, &user, query, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, apperror.NotFound("user with id %d not found", id)
+		}
+		return nil, database.TranslateError(fmt.Errorf("get user by id: %w", err))
+	}
+	return &user, nil
+}
+
+func (r *UserRepository) Create(ctx context.Context, user *model.User) (*model.User, error) {
+	query := "INSERT INTO users (name, email, created_at, updated_at) VALUES (:name, :email, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)"
+	id, err := database.InsertReturningID(ctx, r.db, query, user)
+	if err != nil {
+		return nil, database.TranslateError(fmt.Errorf("create user: %w", err))
+	}
+	user.ID = id
+	user.Version = 1
+	return user, nil
+}
+
+// Update writes the user if the row is still at user.Version, and bumps the
+// version. It fails with apperror.VersionConflict when another update got
+// there first.
+func (r *UserRepository) Update(ctx context.Context, user *model.User) (*model.User, error) {
+	query := "UPDATE users SET name = :name, email = :email, updated_at = CURRENT_TIMESTAMP, version = version + 1" +
+		" WHERE id = :id AND version = :version AND deleted_at IS NULL"
+	result, err := r.db.NamedExecContext(ctx, query, user)
+	if err != nil {
+		return nil, database.TranslateError(fmt.Errorf("update user: %w", err))
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		current, err := r.GetByID(ctx, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		return nil, apperror.VersionConflict("user with id %d is at version %d, not %d", user.ID, current.Version, user.Version)
+	}
+
+	user.Version++
+	return user, nil
+}
+
+// Delete soft-deletes the user. Its products are left to the caller, since
+// the ON DELETE CASCADE of products only fires on a hard delete.
+func (r *UserRepository) Delete(ctx context.Context, id int64) error {
+	query := r.db.Rebind("UPDATE users SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL")
+	result, err := r.db.ExecContext(ctx, query, database.DeletionTime(), id)
+	if err != nil {
+		return database.TranslateError(fmt.Errorf("delete user: %w", err))
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return apperror.NotFound("user with id %d not found", id)
+	}
+	return nil
+}
+
+// Restore clears the deletion mark of the user.
+func (r *UserRepository) Restore(ctx context.Context, id int64) error {
+	query := r.db.Rebind("UPDATE users SET deleted_at = NULL WHERE id = ? AND deleted_at IS NOT NULL")
+	result, err := r.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return database.TranslateError(fmt.Errorf("restore user: %w", err))
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return apperror.NotFound("deleted user with id %d not found", id)
+	}
+	return nil
+}
+
+func (r *UserRepository) List(ctx context.Context, req *dto.ListUserRequest) ([]*model.User, int64, error) {
+	where := strings.Builder{}
+	where.WriteString(" WHERE 1=1")
+	args := []interface{}{}
+
+	if !req.IncludeDeleted {
+		where.WriteString(" AND deleted_at IS NULL")
+	}
+	if req.Search != "" {
+		where.WriteString(" AND (name LIKE ? OR email LIKE ?)")
+		searchPattern := "%" + req.Search + "%"
+		args = append(args, searchPattern, searchPattern)
+	}
+
+	var total int64
+	countQuery := r.db.Rebind("SELECT COUNT(id) FROM users" + where.String())
+	if err := r.db.GetContext(ctx, &total, countQuery, args...); err != nil {
+		return nil, 0, database.TranslateError(fmt.Errorf("count users: %w", err))
+	}
+	if total == 0 {
+		return []*model.User{}, 0, nil
+	}
+
+	queryBuilder := strings.Builder{}
+	queryBuilder.WriteString("SELECT " + userColumns + " FROM users")
+	queryBuilder.WriteString(where.String())
+
+	orderBy := "id"
+	allowedOrderBys := map[string]string{"name": "name", "email": "email", "created_at": "created_at"}
+	if col, ok := allowedOrderBys[req.OrderBy]; ok {
+		orderBy = col
+	}
+	queryBuilder.WriteString(fmt.Sprintf(" ORDER BY %s", orderBy))
+	if req.OrderDesc {
+		queryBuilder.WriteString(" DESC")
+	} else {
+		queryBuilder.WriteString(" ASC")
+	}
+
+	if !req.TakeAll {
+		queryBuilder.WriteString(" LIMIT ? OFFSET ?")
+		offset := (req.Page - 1) * req.Limit
+		args = append(args, req.Limit, offset)
+	}
+
+	users := []*model.User{}
+	if err := r.db.SelectContext(ctx, &users, r.db.Rebind(queryBuilder.String()), args...); err != nil {
+		return nil, 0, database.TranslateError(fmt.Errorf("list users: %w", err))
+	}
+	return users, total, nil
+}
+
+// ListByIDs includes soft-deleted users, so the owners of products listed
+// with include_deleted are found too.
+func (r *UserRepository) ListByIDs(ctx context.Context, ids []int64) ([]*model.User, error) {
+	if len(ids) == 0 {
+		return []*model.User{}, nil
+	}
+
+	query, args, err := sqlx.In("SELECT "+userColumns+" FROM users WHERE id IN (?)", ids)
+	if err != nil {
+		return nil, fmt.Errorf("build list users by ids query: %w", err)
+	}
+
+	users := []*model.User{}
+	if err := r.db.SelectContext(ctx, &users, r.db.Rebind(query), args...); err != nil {
+		return nil, database.TranslateError(fmt.Errorf("list users by ids: %w", err))
+	}
+
+	return users, nil
+}
+
+func (r *UserRepository) ExistsByID(ctx context.Context, id int64) (bool, error) {
+	var exists bool
+	query := r.db.Rebind("SELECT EXISTS(SELECT 1 FROM users WHERE id = ? AND deleted_at IS NULL)")
+	if err := r.db.GetContext(ctx, &exists, query, id); err != nil {
+		return false, database.TranslateError(fmt.Errorf("check user exists by id: %w", err))
+	}
+	return exists, nil
+}
+
+// ExistsByEmail reports whether a live user has email. Soft-deleted users
+// keep theirs until they are purged, see DeletedIDByEmail.
+func (r *UserRepository) ExistsByEmail(ctx context.Context, email string) (bool, error) {
+	var exists bool
+	query := r.db.Rebind("SELECT EXISTS(SELECT 1 FROM users WHERE email = ? AND deleted_at IS NULL)")
+	if err := r.db.GetContext(ctx, &exists, query, email); err != nil {
+		return false, database.TranslateError(fmt.Errorf("check user exists by email: %w", err))
+	}
+	return exists, nil
+}
+
+// DeletedIDByEmail returns the id of the soft-deleted user with email, or 0
+// when there is none.
+func (r *UserRepository) DeletedIDByEmail(ctx context.Context, email string) (int64, error) {
+	var id int64
+	query := r.db.Rebind("SELECT id FROM users WHERE email = ? AND deleted_at IS NOT NULL")
+	if err := r.db.GetContext(ctx, &id, query, email); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil
+		}
+		return 0, database.TranslateError(fmt.Errorf("get deleted user by email: %w", err))
+	}
+	return id, nil
+}
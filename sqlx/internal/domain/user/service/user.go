@@ -0,0 +1,178 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"db_blueprints/apperror"
+	"db_blueprints/model"
+	"db_blueprints/sqlx/database"
+	product_repo "db_blueprints/sqlx/internal/domain/product/repository"
+	"db_blueprints/sqlx/internal/domain/user/controller/dto"
+	"db_blueprints/sqlx/internal/domain/user/repository"
+	"db_blueprints/sqlx/pkgs/paging"
+)
+
+type IUserService interface {
+	ListUsers(ctx context.Context, req *dto.ListUserRequest) ([]*model.User, *paging.Pagination, error)
+	GetByID(ctx context.Context, id int64, includeDeleted bool) (*model.User, error)
+	CreateUser(ctx context.Context, req *dto.CreateUserRequest) (*model.User, error)
+	UpdateUser(ctx context.Context, id int64, req *dto.UpdateUserRequest) (*model.User, error)
+	DeleteUser(ctx context.Context, id int64) error
+	RestoreUser(ctx context.Context, id int64) (*model.User, error)
+}
+
+type UserService struct {
+	db           database.DBTX
+	repo         repository.IUserRepository
+	product_repo product_repo.IProductRepository
+}
+
+func NewUserService(
+	db database.DBTX,
+	repo repository.IUserRepository,
+	product_repo product_repo.IProductRepository,
+) IUserService {
+	return &UserService{
+		db:           db,
+		repo:         repo,
+		product_repo: product_repo,
+	}
+}
+
+func (s *UserService) ListUsers(ctx context.Context, req *dto.ListUserRequest) ([]*model.User, *paging.Pagination, error) {
+	if req.Page < 1 {
+		req.Page = 1
+	}
+	if req.Limit <= 0 {
+		req.Limit = paging.DefaultPageSize
+	}
+
+	users, total, err := s.repo.List(ctx, req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("service: failed to list users: %w", err)
+	}
+
+	pagination := paging.NewPagination(req.Page, req.Limit, total)
+	pagination.TakeAll = req.TakeAll
+
+	return users, pagination, nil
+}
+
+// GetByID returns the user; with includeDeleted a soft-deleted one too.
+func (s *UserService) GetByID(ctx context.Context, id int64, includeDeleted bool) (*model.User, error) {
+	getUser := s.repo.GetByID
+	if includeDeleted {
+		getUser = s.repo.GetByIDUnscoped
+	}
+
+	user, err := getUser(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("service: failed to get user by id: %w", err)
+	}
+	return user, nil
+}
+
+func (s *UserService) CreateUser(ctx context.Context, req *dto.CreateUserRequest) (*model.User, error) {
+	if err := s.checkEmail(ctx, req.Email); err != nil {
+		return nil, err
+	}
+
+	user := &model.User{
+		Name:  req.Name,
+		Email: req.Email,
+	}
+
+	createdUser, err := s.repo.Create(ctx, user)
+	if err != nil {
+		return nil, fmt.Errorf("service: failed to create user: %w", err)
+	}
+
+	return createdUser, nil
+}
+
+// checkEmail fails with a conflict when email belongs to a soft-deleted user.
+// The email stays taken until the user is purged, so the way to use it again
+// is to restore that user. Live owners are rejected by email_unique.
+func (s *UserService) checkEmail(ctx context.Context, email string) error {
+	id, err := s.repo.DeletedIDByEmail(ctx, email)
+	if err != nil {
+		return fmt.Errorf("service: failed to check email: %w", err)
+	}
+	if id != 0 {
+		return apperror.Conflict("email %q belongs to deleted user %d, restore it with POST /api/users/%d/restore", email, id, id)
+	}
+	return nil
+}
+
+func (s *UserService) UpdateUser(ctx context.Context, id int64, req *dto.UpdateUserRequest) (*model.User, error) {
+	userToUpdate, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("service: failed to get user for update: %w", err)
+	}
+	if req.Version != nil && *req.Version != userToUpdate.Version {
+		return nil, apperror.VersionConflict("user with id %d is at version %d, not %d", id, userToUpdate.Version, *req.Version)
+	}
+
+	if req.Name != nil {
+		userToUpdate.Name = *req.Name
+	}
+	if req.Email != nil {
+		userToUpdate.Email = *req.Email
+	}
+
+	updatedUser, err := s.repo.Update(ctx, userToUpdate)
+	if err != nil {
+		return nil, fmt.Errorf("service: failed to update user: %w", err)
+	}
+
+	return updatedUser, nil
+}
+
+// DeleteUser soft-deletes the user together with its products, in one
+// transaction. The rows stay in the database, so RestoreUser can bring both
+// back.
+func (s *UserService) DeleteUser(ctx context.Context, id int64) error {
+	_, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return fmt.Errorf("service: user with id %d cannot be deleted: %w", id, err)
+	}
+
+	return database.WithTx(ctx, s.db, func(tx database.DBTX) error {
+		if err := s.repo.WithTx(tx).Delete(ctx, id); err != nil {
+			return fmt.Errorf("service: failed to delete user: %w", err)
+		}
+		if _, err := s.product_repo.WithTx(tx).DeleteByOwner(ctx, id); err != nil {
+			return fmt.Errorf("service: failed to delete products of user: %w", err)
+		}
+		return nil
+	})
+}
+
+// RestoreUser undoes a soft delete, together with the products deleted along
+// with the user. Products deleted earlier on their own stay deleted.
+func (s *UserService) RestoreUser(ctx context.Context, id int64) (*model.User, error) {
+	user, err := s.repo.GetByIDUnscoped(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("service: failed to get user for restore: %w", err)
+	}
+	if !user.DeletedAt.Valid {
+		return nil, apperror.NotFound("deleted user with id %d not found", id)
+	}
+
+	err = database.WithTx(ctx, s.db, func(tx database.DBTX) error {
+		if err := s.repo.WithTx(tx).Restore(ctx, id); err != nil {
+			return fmt.Errorf("service: failed to restore user: %w", err)
+		}
+		if _, err := s.product_repo.WithTx(tx).RestoreByOwner(ctx, id, user.DeletedAt.Time); err != nil {
+			return fmt.Errorf("service: failed to restore products of user: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	user.DeletedAt.Valid = false
+	return user, nil
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"db_blueprints/config"
+	"db_blueprints/middleware"
+	db "db_blueprints/sqlx/database"
+	"db_blueprints/validation"
+	"fmt"
+	"log"
+	"net/http"
+
+	httpProduct "db_blueprints/sqlx/internal/domain/product/controller/http"
+	httpUser "db_blueprints/sqlx/internal/domain/user/controller/http"
+	userRepo "db_blueprints/sqlx/internal/domain/user/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Server struct {
+	engine *gin.Engine
+	cfg    *config.Config
+	db     db.DBTX
+}
+
+func NewServer(db db.DBTX, cfg *config.Config) *Server {
+	validation.Setup()
+
+	engine := gin.Default()
+	engine.Use(middleware.RequestID())
+	engine.Use(middleware.Admin(cfg.ADMIN_TOKEN))
+
+	return &Server{
+		engine: engine,
+		cfg:    cfg,
+		db:     db,
+	}
+}
+
+// Run serves HTTP on HTTP_PORT until ctx is done. It then stops accepting
+// connections and gives in-flight requests up to SHUTDOWN_TIMEOUT to finish,
+// so the database can be closed once Run returns.
+func (s Server) Run(ctx context.Context) error {
+	if err := s.MapRoutes(); err != nil {
+		return fmt.Errorf("map routes: %w", err)
+	}
+
+	srv := &http.Server{
+		Addr:         fmt.Sprintf(":%s", s.cfg.HTTP_PORT),
+		Handler:      s.engine,
+		ReadTimeout:  s.cfg.HTTP_READ_TIMEOUT,
+		WriteTimeout: s.cfg.HTTP_WRITE_TIMEOUT,
+		IdleTimeout:  s.cfg.HTTP_IDLE_TIMEOUT,
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.ListenAndServe()
+	}()
+	log.Printf("Listening on %s", srv.Addr)
+
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("serve http: %w", err)
+	case <-ctx.Done():
+	}
+
+	log.Println("Shutting down, waiting for in-flight requests")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.cfg.SHUTDOWN_TIMEOUT)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("shut down http server: %w", err)
+	}
+	return nil
+}
+
+func (s Server) MapRoutes() error {
+	if err := validation.RegisterUserRules(userRepo.NewUserRepository(s.db)); err != nil {
+		return err
+	}
+
+	routesV1 := s.engine.Group("/api")
+
+	httpProduct.Routes(routesV1, s.db)
+	httpUser.Routes(routesV1, s.db)
+	return nil
+}
@@ -0,0 +1,83 @@
+package validation
+
+import (
+	"context"
+	"db_blueprints/config"
+	"log"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// Setup configures gin's validator so that validation errors report the JSON
+// (or form) name of a field instead of its Go struct field name.
+func Setup() {
+	v, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return
+	}
+
+	v.RegisterTagNameFunc(fieldName)
+}
+
+func fieldName(field reflect.StructField) string {
+	for _, tag := range []string{"json", "form"} {
+		name := strings.SplitN(field.Tag.Get(tag), ",", 2)[0]
+		if name == "-" {
+			continue
+		}
+		if name != "" {
+			return name
+		}
+	}
+	return field.Name
+}
+
+// UserLookup is the part of the user repository the custom rules rely on.
+type UserLookup interface {
+	ExistsByID(ctx context.Context, id int64) (bool, error)
+	ExistsByEmail(ctx context.Context, email string) (bool, error)
+}
+
+// RegisterUserRules adds the rules that need the database:
+//
+//   - owner_exists: the int64 field references an existing user
+//   - email_unique: no live user is registered with the string field; the
+//     user services report a soft-deleted owner as a conflict
+//
+// A failing lookup lets the value through and is logged; the foreign key and
+// unique constraints still reject it at insert time.
+func RegisterUserRules(users UserLookup) error {
+	v, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return nil
+	}
+
+	if err := v.RegisterValidation("owner_exists", func(fl validator.FieldLevel) bool {
+		ctx, cancel := context.WithTimeout(context.Background(), config.DatabaseTimeout)
+		defer cancel()
+
+		exists, err := users.ExistsByID(ctx, fl.Field().Int())
+		if err != nil {
+			log.Printf("owner_exists: lookup failed: %v", err)
+			return true
+		}
+		return exists
+	}); err != nil {
+		return err
+	}
+
+	return v.RegisterValidation("email_unique", func(fl validator.FieldLevel) bool {
+		ctx, cancel := context.WithTimeout(context.Background(), config.DatabaseTimeout)
+		defer cancel()
+
+		exists, err := users.ExistsByEmail(ctx, fl.Field().String())
+		if err != nil {
+			log.Printf("email_unique: lookup failed: %v", err)
+			return true
+		}
+		return !exists
+	})
+}